@@ -1,194 +1,27 @@
 package agent
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"sync"
+	"time"
 
 	"github.com/modfin/bellman/models"
 	"github.com/modfin/bellman/models/gen"
 	"github.com/modfin/bellman/prompt"
-	"github.com/modfin/bellman/schema"
-	"github.com/modfin/bellman/tools"
 )
 
 // Run will prompt until the llm responds with no tool calls, or until maxDepth is reached. Unless Output is already
 // set, it will be set by using schema.From on the expected result struct. Does not work with gemini as of 2025-02-17.
+//
+// If the llm calls a tool that requires approval, Run returns an *ApprovalRequiredError holding the State of the run,
+// which can be continued with Resume.
 func Run[T any](maxDepth int, parallelism int, g *gen.Generator, prompts ...prompt.Prompt) (*Result[T], error) {
-	var result T
-	_, resultIsString := any(result).(string)
-	if g.Request.OutputSchema == nil && !resultIsString {
-		g = g.Output(schema.From(result))
-	}
-
-	promptMetadata := models.Metadata{Model: g.Request.Model.Name}
-	for i := 0; i < maxDepth; i++ {
-		resp, err := g.Prompt(prompts...)
-		if err != nil {
-			return nil, fmt.Errorf("failed to prompt: %w, at depth %d", err, i)
-		}
-		promptMetadata.InputTokens += resp.Metadata.InputTokens
-		promptMetadata.ThinkingTokens += resp.Metadata.ThinkingTokens
-		promptMetadata.OutputTokens += resp.Metadata.OutputTokens
-		promptMetadata.TotalTokens += resp.Metadata.TotalTokens
-
-		if !resp.IsTools() {
-			// Check if T is string type and handle directly
-			if resultIsString {
-				text, err := resp.AsText()
-				if err != nil {
-					return nil, fmt.Errorf("could not get text response: %w, at depth %d", err, i)
-				}
-				// Convert string to T (which we know is string) using unsafe casting
-				result = any(text).(T)
-			} else {
-				err = resp.Unmarshal(&result)
-				if err != nil {
-					return nil, fmt.Errorf("could not unmarshal text response: %w, at depth %d", err, i)
-				}
-			}
-			return &Result[T]{
-				Prompts:  prompts,
-				Result:   result,
-				Metadata: promptMetadata,
-				Depth:    i,
-			}, nil
-		}
-
-		callbacks, err := resp.AsTools()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get tools: %w, at depth %d", err, i)
-		}
-
-		// Pre-validate all callbacks before execution
-		for _, callback := range callbacks {
-			if callback.Ref == nil {
-				return nil, fmt.Errorf("tool %s not found in local setup", callback.Name)
-			}
-			if callback.Ref.Function == nil {
-				return nil, fmt.Errorf("tool %s has no callback function attached", callback.Name)
-			}
-		}
-
-		var callbackResults []callbackResult
-		if parallelism <= 1 {
-			callbackResults = executeCallbacksSequential(g.Request.Context, callbacks)
-		} else {
-			callbackResults = executeCallbacksParallel(g.Request.Context, callbacks, parallelism)
-		}
-
-		// Replay the assistant turn verbatim — resp.Turn carries thinking,
-		// text, and tool-call prompts in provider-correct order with any
-		// signatures already attached to Prompt.Replay.
-		prompts = append(prompts, resp.Turn...)
-
-		// Fail fast on any tool error before appending tool responses.
-		for _, cbResult := range callbackResults {
-			if cbResult.Error != nil {
-				callback := callbacks[cbResult.Index]
-				return nil, fmt.Errorf("tool %s failed: %w, arg: %s", cbResult.Name, cbResult.Error, callback.Argument)
-			}
-		}
-
-		for _, cbResult := range callbackResults {
-			prompts = append(prompts, prompt.AsToolResponse(cbResult.ID, cbResult.Name, cbResult.Response))
-		}
-
-	}
-	return nil, fmt.Errorf("max depth %d reached", maxDepth)
+	return New[T](g).MaxDepth(maxDepth).Parallelism(parallelism).Run(prompts...)
 }
 
-const customResultCalculatedTool = "__return_result_tool__"
-
 // RunWithToolsOnly will prompt until the llm responds with a certain tool call. Prefer to use the Run function above,
 // but gemini does not support the above function (requiring tools and structured output), so use this one instead for those models.
 func RunWithToolsOnly[T any](maxDepth int, parallelism int, g *gen.Generator, prompts ...prompt.Prompt) (*Result[T], error) {
-	if g.Request.OutputSchema != nil {
-		g = g.Output(nil)
-	}
-
-	var newTools []tools.Tool
-	for _, t := range g.Tools() {
-		if t.Name == customResultCalculatedTool {
-			continue
-		}
-		newTools = append(newTools, t)
-	}
-	g = g.SetTools(newTools...)
-
-	var result T
-	g = g.AddTools(tools.Tool{
-		Name:           customResultCalculatedTool,
-		Description:    "Return the final results to the user",
-		ArgumentSchema: schema.From(result),
-	})
-	g = g.SetToolConfig(tools.RequiredTool)
-
-	promptMetadata := models.Metadata{Model: g.Request.Model.Name}
-	for i := 0; i < maxDepth; i++ {
-		resp, err := g.Prompt(prompts...)
-		if err != nil {
-			return nil, fmt.Errorf("failed to prompt: %w, at depth %d", err, i)
-		}
-		promptMetadata.InputTokens += resp.Metadata.InputTokens
-		promptMetadata.ThinkingTokens += resp.Metadata.ThinkingTokens
-		promptMetadata.OutputTokens += resp.Metadata.OutputTokens
-		promptMetadata.TotalTokens += resp.Metadata.TotalTokens
-
-		callbacks, err := resp.AsTools()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get tools: %w, at depth %d", err, i)
-		}
-
-		// Pre-validate all callbacks before execution
-		for _, callback := range callbacks {
-			if callback.Name == customResultCalculatedTool {
-				var finalResult T
-				err = json.Unmarshal(callback.Argument, &finalResult)
-				if err != nil {
-					return nil, fmt.Errorf("could not unmarshal final result: %w, at depth %d", err, i)
-				}
-				return &Result[T]{
-					Prompts:  prompts,
-					Result:   finalResult,
-					Metadata: promptMetadata,
-					Depth:    i,
-				}, nil
-			}
-			if callback.Ref == nil {
-				return nil, fmt.Errorf("tool %s not found in local setup", callback.Name)
-			}
-			if callback.Ref.Function == nil {
-				return nil, fmt.Errorf("tool %s has no callback function attached", callback.Name)
-			}
-		}
-
-		var callbackResults []callbackResult
-		if parallelism <= 1 {
-			callbackResults = executeCallbacksSequential(g.Request.Context, callbacks)
-		} else {
-			callbackResults = executeCallbacksParallel(g.Request.Context, callbacks, parallelism)
-		}
-
-		// Replay the assistant turn verbatim — resp.Turn carries thinking,
-		// text, and tool-call prompts in provider-correct order with any
-		// signatures already attached to Prompt.Replay.
-		prompts = append(prompts, resp.Turn...)
-
-		// Fail fast on any tool error before appending tool responses.
-		for _, cbResult := range callbackResults {
-			if cbResult.Error != nil {
-				callback := callbacks[cbResult.Index]
-				return nil, fmt.Errorf("tool %s failed: %w, arg: %s", cbResult.Name, cbResult.Error, callback.Argument)
-			}
-		}
-
-		for _, cbResult := range callbackResults {
-			prompts = append(prompts, prompt.AsToolResponse(cbResult.ID, cbResult.Name, cbResult.Response))
-		}
-	}
-	return nil, fmt.Errorf("max depth %d reached", maxDepth)
+	return New[T](g).MaxDepth(maxDepth).Parallelism(parallelism).ToolsOnly(true).Run(prompts...)
 }
 
 type Result[T any] struct {
@@ -198,62 +31,106 @@ type Result[T any] struct {
 	Depth    int
 }
 
-// callbackResult holds the result of a single callback execution
-type callbackResult struct {
-	Index    int
-	ID       string
-	Name     string
-	Response string
-	Error    error
+// Agent holds the configuration of an agent run. Like gen.Generator, every setter returns a modified copy, so an
+// Agent can be shared and specialized freely.
+type Agent[T any] struct {
+	generator   *gen.Generator
+	maxDepth    int
+	parallelism int
+	toolsOnly   bool
+	budget      Budget
+	handoffs    map[string]*Agent[T]
+	compactor   Compactor
+	compactAt   int
+
+	checkpoints Checkpointer
+	runID       string
+
+	emit func(*Event[T]) // set for streamed runs
 }
 
-// executeCallbacksSequential executes callbacks one by one (original behavior)
-func executeCallbacksSequential(ctx context.Context, callbacks []tools.Call) []callbackResult {
-	results := make([]callbackResult, len(callbacks))
-
-	for i, callback := range callbacks {
-		response, err := callback.Ref.Function(ctx, callback)
-		results[i] = callbackResult{
-			Index:    i,
-			ID:       callback.ID,
-			Name:     callback.Name,
-			Response: response,
-			Error:    err,
-		}
+// New returns an Agent that prompts g, with a max depth of 10 and sequential tool execution.
+func New[T any](g *gen.Generator) *Agent[T] {
+	return &Agent[T]{
+		generator:   g,
+		maxDepth:    10,
+		parallelism: 1,
 	}
+}
 
-	return results
+func (a *Agent[T]) clone() *Agent[T] {
+	aa := *a
+	return &aa
 }
 
-// executeCallbacksParallel executes callbacks in parallel with limited concurrency
-func executeCallbacksParallel(ctx context.Context, callbacks []tools.Call, parallelism int) []callbackResult {
-	numCallbacks := len(callbacks)
-	results := make([]callbackResult, numCallbacks)
+// MaxDepth sets the maximum number of llm turns.
+func (a *Agent[T]) MaxDepth(maxDepth int) *Agent[T] {
+	aa := a.clone()
+	aa.maxDepth = maxDepth
+	return aa
+}
 
-	// Use a semaphore to limit concurrency
-	semaphore := make(chan struct{}, parallelism)
-	var wg sync.WaitGroup
+// Parallelism sets the number of tool calls executed concurrently.
+func (a *Agent[T]) Parallelism(parallelism int) *Agent[T] {
+	aa := a.clone()
+	aa.parallelism = parallelism
+	return aa
+}
 
-	for i, callback := range callbacks {
-		wg.Add(1)
-		go func(index int, cb tools.Call) {
-			defer wg.Done()
+// ToolsOnly makes the llm return the result through a tool call instead of structured output, see RunWithToolsOnly.
+func (a *Agent[T]) ToolsOnly(toolsOnly bool) *Agent[T] {
+	aa := a.clone()
+	aa.toolsOnly = toolsOnly
+	return aa
+}
 
-			// Acquire semaphore
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
+// Budget limits the spend of every run, see Budget.
+func (a *Agent[T]) Budget(budget Budget) *Agent[T] {
+	aa := a.clone()
+	aa.budget = budget
+	return aa
+}
+
+// Checkpoint saves the State of the run to store, under runID, after every step. An interrupted run is continued
+// with Resume.
+func (a *Agent[T]) Checkpoint(store Checkpointer, runID string) *Agent[T] {
+	aa := a.clone()
+	aa.checkpoints = store
+	aa.runID = runID
+	return aa
+}
 
-			response, err := cb.Ref.Function(ctx, cb)
-			results[index] = callbackResult{
-				Index:    index,
-				ID:       cb.ID,
-				Name:     cb.Name,
-				Response: response,
-				Error:    err,
-			}
-		}(i, callback)
+// Run starts a new run from prompts.
+func (a *Agent[T]) Run(prompts ...prompt.Prompt) (*Result[T], error) {
+	st := &State{
+		Prompts:   append([]prompt.Prompt{}, prompts...),
+		ToolsOnly: a.toolsOnly,
 	}
+	return a.prepare(st.ToolsOnly).run(st, nil)
+}
 
-	wg.Wait()
-	return results
+// Resume continues a run from state, or from the latest checkpoint if state is nil. Pending tool calls are executed
+// according to decisions before the llm is prompted again, see ApprovalRequiredError.
+func (a *Agent[T]) Resume(state *State, decisions ...Decision) (*Result[T], error) {
+	if state == nil {
+		if a.checkpoints == nil {
+			return nil, errNoState
+		}
+		var err error
+		state, err = a.checkpoints.Load(a.context(), a.runID)
+		if err != nil {
+			return nil, fmt.Errorf("could not load checkpoint %s: %w", a.runID, err)
+		}
+	}
+	runner := a
+	if state.Agent != "" {
+		to := a.find(state.Agent)
+		if to == nil {
+			return nil, fmt.Errorf("handoff agent %s not found", state.Agent)
+		}
+		runner = a.inherit(to)
+	}
+	st := state.clone()
+	st.started = time.Time{}
+	return runner.prepare(st.ToolsOnly).run(st, decisions)
 }
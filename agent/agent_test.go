@@ -0,0 +1,528 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/modfin/bellman/agent"
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/tools"
+)
+
+// scripted is a gen.Prompter that replays canned responses, one per Prompt call, and records the conversations
+// it was prompted with.
+type scripted struct {
+	request   gen.Request
+	responses []*gen.Response
+	seen      [][]prompt.Prompt
+}
+
+func (s *scripted) SetRequest(request gen.Request) {
+	s.request = request
+}
+
+func (s *scripted) Prompt(prompts ...prompt.Prompt) (*gen.Response, error) {
+	s.seen = append(s.seen, append([]prompt.Prompt{}, prompts...))
+	if len(s.responses) == 0 {
+		return nil, errors.New("script exhausted")
+	}
+	resp := s.responses[0]
+	s.responses = s.responses[1:]
+
+	// mimic providers, which attach tool references from the request
+	for i, call := range resp.Tools {
+		for _, t := range s.request.Tools {
+			if t.Name == call.Name {
+				resp.Tools[i].Ref = &t
+			}
+		}
+	}
+	return resp, nil
+}
+
+// Stream replays the next canned response as a stream of deltas and blocks.
+func (s *scripted) Stream(prompts ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
+	resp, err := s.Prompt(prompts...)
+	if err != nil {
+		return nil, err
+	}
+	stream := make(chan *gen.StreamResponse)
+	go func() {
+		defer close(stream)
+		for _, text := range resp.Texts {
+			for _, word := range strings.SplitAfter(text, " ") {
+				stream <- &gen.StreamResponse{Type: gen.TYPE_DELTA, Role: prompt.AssistantRole, Content: word}
+			}
+		}
+		for i, p := range resp.Turn {
+			r := &gen.StreamResponse{Type: gen.TYPE_BLOCK, Role: p.Role, Index: i, Block: &p}
+			if p.Role == prompt.ToolCallRole {
+				for _, call := range resp.Tools {
+					if call.ID == p.ToolCall.ToolCallID {
+						stream <- &gen.StreamResponse{Type: gen.TYPE_DELTA, Role: prompt.ToolCallRole, Index: i, ToolCall: &call}
+						r.ToolCall = &call
+					}
+				}
+			}
+			stream <- r
+		}
+		stream <- &gen.StreamResponse{Type: gen.TYPE_METADATA, Metadata: &resp.Metadata}
+		stream <- &gen.StreamResponse{Type: gen.TYPE_EOF}
+	}()
+	return stream, nil
+}
+
+func toolTurn(calls ...tools.Call) *gen.Response {
+	resp := &gen.Response{Metadata: models.Metadata{InputTokens: 10, OutputTokens: 5, TotalTokens: 15}}
+	for _, c := range calls {
+		resp.Tools = append(resp.Tools, c)
+		resp.Turn = append(resp.Turn, prompt.AsToolCall(c.ID, c.Name, c.Argument))
+	}
+	return resp
+}
+
+func textTurn(text string) *gen.Response {
+	return &gen.Response{
+		Texts:    []string{text},
+		Turn:     []prompt.Prompt{prompt.AsAssistant(text)},
+		Metadata: models.Metadata{InputTokens: 10, OutputTokens: 5, TotalTokens: 15},
+	}
+}
+
+func toolResponses(prompts []prompt.Prompt) map[string]string {
+	res := map[string]string{}
+	for _, p := range prompts {
+		if p.Role == prompt.ToolResponseRole {
+			res[p.ToolResponse.ToolCallID] = p.ToolResponse.Response
+		}
+	}
+	return res
+}
+
+func TestRunApproval(t *testing.T) {
+	var executed []string
+	fn := func(ctx context.Context, call tools.Call) (string, error) {
+		executed = append(executed, call.ID+":"+string(call.Argument))
+		return "ok", nil
+	}
+	read := tools.NewTool("read", tools.WithFunction(fn))
+	write := tools.NewTool("write", tools.WithFunction(fn), tools.WithApproval())
+
+	s := &scripted{responses: []*gen.Response{
+		toolTurn(
+			tools.Call{ID: "1", Name: "read", Argument: []byte(`{}`)},
+			tools.Call{ID: "2", Name: "write", Argument: []byte(`{"v":1}`)},
+			tools.Call{ID: "3", Name: "write", Argument: []byte(`{"v":2}`)},
+		),
+		textTurn("done"),
+	}}
+	g := (&gen.Generator{Prompter: s}).SetTools(read, write)
+
+	_, err := agent.Run[string](5, 1, g, prompt.AsUser("go"))
+	var approval *agent.ApprovalRequiredError
+	if !errors.As(err, &approval) {
+		t.Fatalf("expected ApprovalRequiredError, got %v", err)
+	}
+	if len(approval.State.Pending) != 2 {
+		t.Fatalf("expected 2 pending calls, got %d", len(approval.State.Pending))
+	}
+	if len(executed) != 1 || executed[0] != "1:{}" {
+		t.Fatalf("expected only the read call to be executed, got %v", executed)
+	}
+
+	// the state survives a round trip through json, eg. while waiting for a human
+	b, err := json.Marshal(approval.State)
+	if err != nil {
+		t.Fatalf("could not marshal state: %v", err)
+	}
+	var state agent.State
+	if err := json.Unmarshal(b, &state); err != nil {
+		t.Fatalf("could not unmarshal state: %v", err)
+	}
+
+	res, err := agent.Resume[string](5, 1, g, &state,
+		agent.Edit(state.Pending[0], []byte(`{"v":10}`)),
+		agent.Deny(state.Pending[1], "too risky"),
+	)
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if res.Result != "done" {
+		t.Fatalf("expected result done, got %q", res.Result)
+	}
+	if len(executed) != 2 || executed[1] != `2:{"v":10}` {
+		t.Fatalf("expected the edited write call to be executed, got %v", executed)
+	}
+
+	last := s.seen[len(s.seen)-1]
+	responses := toolResponses(last)
+	if len(responses) != 3 {
+		t.Fatalf("expected 3 tool responses in the conversation, got %d", len(responses))
+	}
+	if !strings.Contains(responses["3"], "too risky") {
+		t.Fatalf("expected the denial reason to reach the llm, got %q", responses["3"])
+	}
+	for _, p := range last {
+		if p.Role == prompt.ToolCallRole && p.ToolCall.ToolCallID == "2" && string(p.ToolCall.Arguments) != `{"v":10}` {
+			t.Fatalf("expected the edited arguments in the transcript, got %s", p.ToolCall.Arguments)
+		}
+	}
+	if res.Metadata.TotalTokens != 30 {
+		t.Fatalf("expected metadata to accumulate across resume, got %+v", res.Metadata)
+	}
+}
+
+func TestResumeWithoutDecision(t *testing.T) {
+	write := tools.NewTool("write", tools.WithApproval(), tools.WithFunction(func(ctx context.Context, call tools.Call) (string, error) {
+		t.Fatalf("write must not be executed without approval")
+		return "", nil
+	}))
+	s := &scripted{responses: []*gen.Response{
+		toolTurn(tools.Call{ID: "1", Name: "write", Argument: []byte(`{}`)}),
+	}}
+	g := (&gen.Generator{Prompter: s}).SetTools(write)
+
+	_, err := agent.Run[string](5, 1, g, prompt.AsUser("go"))
+	var approval *agent.ApprovalRequiredError
+	if !errors.As(err, &approval) {
+		t.Fatalf("expected ApprovalRequiredError, got %v", err)
+	}
+
+	_, err = agent.Resume[string](5, 1, g, approval.State)
+	if !errors.As(err, &approval) {
+		t.Fatalf("expected ApprovalRequiredError when resuming without decisions, got %v", err)
+	}
+
+	_, err = agent.Resume[string](5, 1, g, approval.State, agent.Approve(tools.Call{ID: "x", Name: "write"}))
+	if err == nil || errors.As(err, &approval) {
+		t.Fatalf("expected an error for a decision not matching any pending call, got %v", err)
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	store, err := agent.NewFileCheckpointer(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileCheckpointer() error = %v", err)
+	}
+
+	calls := map[string]int{}
+	flaky := func(ctx context.Context, call tools.Call) (string, error) {
+		calls[call.ID]++
+		if call.ID == "2" && calls[call.ID] == 1 {
+			return "", errors.New("connection reset")
+		}
+		return "ok", nil
+	}
+	s := &scripted{responses: []*gen.Response{
+		toolTurn(
+			tools.Call{ID: "1", Name: "fetch", Argument: []byte(`{}`)},
+			tools.Call{ID: "2", Name: "fetch", Argument: []byte(`{}`)},
+		),
+		textTurn("done"),
+	}}
+	g := (&gen.Generator{Prompter: s}).SetTools(tools.NewTool("fetch", tools.WithFunction(flaky)))
+	a := agent.New[string](g).Checkpoint(store, "run-1")
+
+	_, err = a.Run(prompt.AsUser("go"))
+	if err == nil {
+		t.Fatalf("expected the failing tool to stop the run")
+	}
+
+	state, err := store.Load(context.Background(), "run-1")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if state.Depth != 1 || len(state.Pending) != 1 || state.Pending[0].ID != "2" {
+		t.Fatalf("expected the failed call to be pending after the first turn, got %+v", state)
+	}
+
+	res, err := a.Resume(nil)
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if res.Result != "done" {
+		t.Fatalf("expected result done, got %q", res.Result)
+	}
+	if calls["1"] != 1 || calls["2"] != 2 {
+		t.Fatalf("expected completed calls not to be executed again, got %v", calls)
+	}
+	if len(s.seen) != 2 {
+		t.Fatalf("expected the completed llm turn not to be prompted again, got %d prompts", len(s.seen))
+	}
+
+	if err := store.Delete(context.Background(), "run-1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := a.Resume(nil); !errors.Is(err, agent.ErrNoCheckpoint) {
+		t.Fatalf("expected ErrNoCheckpoint after delete, got %v", err)
+	}
+}
+
+func TestCheckpointResumeParallel(t *testing.T) {
+	store, err := agent.NewFileCheckpointer(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileCheckpointer() error = %v", err)
+	}
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	flaky := func(ctx context.Context, call tools.Call) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[call.ID]++
+		if call.ID == "2" && calls[call.ID] == 1 {
+			return "", errors.New("connection reset")
+		}
+		return "ok", nil
+	}
+	s := &scripted{responses: []*gen.Response{
+		toolTurn(
+			tools.Call{ID: "1", Name: "fetch", Argument: []byte(`{}`)},
+			tools.Call{ID: "2", Name: "fetch", Argument: []byte(`{}`)},
+			tools.Call{ID: "3", Name: "fetch", Argument: []byte(`{}`)},
+		),
+		textTurn("done"),
+	}}
+	g := (&gen.Generator{Prompter: s}).SetTools(tools.NewTool("fetch", tools.WithFunction(flaky)))
+	a := agent.New[string](g).Parallelism(3).Checkpoint(store, "run-1")
+
+	_, err = a.Run(prompt.AsUser("go"))
+	if err == nil {
+		t.Fatalf("expected the failing tool to stop the run")
+	}
+
+	state, err := store.Load(context.Background(), "run-1")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(state.Pending) != 1 || state.Pending[0].ID != "2" {
+		t.Fatalf("expected only the failed call to be pending, got %+v", state.Pending)
+	}
+
+	_, err = a.Resume(nil)
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if calls["1"] != 1 || calls["2"] != 2 || calls["3"] != 1 {
+		t.Fatalf("expected succeeded calls not to be executed again, got %v", calls)
+	}
+}
+
+func TestRunStream(t *testing.T) {
+	fn := func(ctx context.Context, call tools.Call) (string, error) {
+		if call.ID == "1" {
+			time.Sleep(20 * time.Millisecond) // finishes last, but its result must come first
+		}
+		return "result " + call.ID, nil
+	}
+	s := &scripted{responses: []*gen.Response{
+		toolTurn(
+			tools.Call{ID: "1", Name: "fetch", Argument: []byte(`{}`)},
+			tools.Call{ID: "2", Name: "fetch", Argument: []byte(`{}`)},
+		),
+		textTurn("all done"),
+	}}
+	g := (&gen.Generator{Prompter: s}).SetTools(tools.NewTool("fetch", tools.WithFunction(fn)))
+
+	events, err := agent.RunStream[string](5, 2, g, prompt.AsUser("go"))
+	if err != nil {
+		t.Fatalf("RunStream() error = %v", err)
+	}
+
+	var types []string
+	var text strings.Builder
+	var results []string
+	var res *agent.Result[string]
+	for e := range events {
+		switch e.Type {
+		case agent.EVENT_DELTA:
+			text.WriteString(e.Content)
+			continue // the number of deltas is not interesting
+		case agent.EVENT_TOOL_RESULT:
+			results = append(results, e.Content)
+		case agent.EVENT_RESULT:
+			res = e.Result
+		case agent.EVENT_ERROR:
+			t.Fatalf("stream error = %v", e.Error)
+		}
+		types = append(types, string(e.Type))
+	}
+
+	want := []string{
+		"step_start", "tool_call_start", "tool_call", "tool_call_start", "tool_call", "step_end",
+		"tool_result", "tool_result",
+		"step_start", "step_end",
+		"result",
+	}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected events\n got: %v\nwant: %v", types, want)
+	}
+	if strings.Join(results, ",") != "result 1,result 2" {
+		t.Fatalf("expected tool results in call order, got %v", results)
+	}
+	if text.String() != "all done" || res == nil || res.Result != "all done" {
+		t.Fatalf("expected result all done, got text %q, result %+v", text.String(), res)
+	}
+	if res.Metadata.TotalTokens != 30 {
+		t.Fatalf("expected metadata to accumulate across turns, got %+v", res.Metadata)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	executed := 0
+	fetch := tools.NewTool("fetch", tools.WithFunction(func(ctx context.Context, call tools.Call) (string, error) {
+		executed++
+		return "ok", nil
+	}))
+	script := func() *scripted {
+		return &scripted{responses: []*gen.Response{
+			toolTurn(tools.Call{ID: "1", Name: "fetch", Argument: []byte(`{}`)}),
+			toolTurn(
+				tools.Call{ID: "2", Name: "fetch", Argument: []byte(`{}`)},
+				tools.Call{ID: "3", Name: "fetch", Argument: []byte(`{}`)},
+			),
+			textTurn("best effort"),
+		}}
+	}
+
+	s := script()
+	g := (&gen.Generator{Prompter: s}).SetTools(fetch)
+	_, err := agent.New[string](g).Budget(agent.Budget{ToolCalls: 2}).Run(prompt.AsUser("go"))
+	var exceeded *agent.BudgetExceededError[string]
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("expected BudgetExceededError, got %v", err)
+	}
+	if exceeded.Limit != "tool calls" || executed != 1 {
+		t.Fatalf("expected to stop before exceeding 2 tool calls, got limit %q and %d executed", exceeded.Limit, executed)
+	}
+	if exceeded.Result.Metadata.TotalTokens != 30 || len(exceeded.Result.Prompts) != 5 {
+		t.Fatalf("expected the partial result of two turns, got %+v", exceeded.Result)
+	}
+
+	executed = 0
+	s = script()
+	g = (&gen.Generator{Prompter: s}).SetTools(fetch)
+	res, err := agent.New[string](g).Budget(agent.Budget{OutputTokens: 10, FinalAnswer: true}).Run(prompt.AsUser("go"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if res.Result != "best effort" || executed != 1 {
+		t.Fatalf("expected a final answer after the output token limit, got %q with %d executed", res.Result, executed)
+	}
+	if s.request.ToolConfig == nil || *s.request.ToolConfig != tools.NoTool {
+		t.Fatalf("expected the final turn to be made with tools.NoTool, got %v", s.request.ToolConfig)
+	}
+	responses := toolResponses(s.seen[len(s.seen)-1])
+	if responses["2"] == "" || responses["3"] == "" {
+		t.Fatalf("expected pending calls to be answered before the final turn, got %v", responses)
+	}
+}
+
+func TestBudgetApproval(t *testing.T) {
+	executed := 0
+	fn := func(ctx context.Context, call tools.Call) (string, error) {
+		executed++
+		return "ok", nil
+	}
+	read := tools.NewTool("read", tools.WithFunction(fn))
+	write := tools.NewTool("write", tools.WithFunction(fn), tools.WithApproval())
+
+	s := &scripted{responses: []*gen.Response{
+		toolTurn(
+			tools.Call{ID: "1", Name: "read", Argument: []byte(`{}`)},
+			tools.Call{ID: "2", Name: "write", Argument: []byte(`{}`)},
+		),
+		textTurn("done"),
+	}}
+	g := (&gen.Generator{Prompter: s}).SetTools(read, write)
+	a := agent.New[string](g).Budget(agent.Budget{ToolCalls: 1})
+
+	// the write call waiting for approval does not count towards the budget
+	_, err := a.Run(prompt.AsUser("go"))
+	var approval *agent.ApprovalRequiredError
+	if !errors.As(err, &approval) {
+		t.Fatalf("expected ApprovalRequiredError, got %v", err)
+	}
+	if executed != 1 {
+		t.Fatalf("expected the read call to be executed, got %d executed", executed)
+	}
+
+	// nor does it once denied
+	res, err := a.Resume(approval.State, agent.Deny(approval.State.Pending[0], "no"))
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if res.Result != "done" || executed != 1 {
+		t.Fatalf("expected result done with 1 executed, got %q with %d executed", res.Result, executed)
+	}
+}
+
+func TestSubAgent(t *testing.T) {
+	sub := &scripted{responses: []*gen.Response{textTurn("42")}}
+	researcher := agent.New[string](&gen.Generator{Prompter: sub}).
+		AsTool("researcher", "answers questions")
+
+	s := &scripted{responses: []*gen.Response{
+		toolTurn(tools.Call{ID: "1", Name: "researcher", Argument: []byte(`{"task":"what is the answer?"}`)}),
+		textTurn("the answer is 42"),
+	}}
+	g := (&gen.Generator{Prompter: s}).SetTools(researcher)
+
+	res, err := agent.Run[string](5, 1, g, prompt.AsUser("go"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := sub.seen[0][0].Text; got != "what is the answer?" {
+		t.Fatalf("expected the task as prompt of the sub agent, got %q", got)
+	}
+	if got := toolResponses(s.seen[1])["1"]; got != "42" {
+		t.Fatalf("expected the sub agent result as tool response, got %q", got)
+	}
+	if res.Metadata.TotalTokens != 45 {
+		t.Fatalf("expected the usage of the sub agent to roll up, got %+v", res.Metadata)
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	billing := &scripted{responses: []*gen.Response{textTurn("refund issued")}}
+	s := &scripted{responses: []*gen.Response{
+		toolTurn(tools.Call{ID: "1", Name: "billing", Argument: []byte(`{}`)}),
+	}}
+
+	res, err := agent.New[string](&gen.Generator{Prompter: s}).
+		HandoffTo("billing", "hand over billing questions", agent.New[string](&gen.Generator{Prompter: billing})).
+		Run(prompt.AsUser("I want a refund"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if res.Result != "refund issued" {
+		t.Fatalf("expected the result of the billing agent, got %q", res.Result)
+	}
+	seen := billing.seen[0]
+	if len(seen) != 3 || seen[0].Text != "I want a refund" || toolResponses(seen)["1"] == "" {
+		t.Fatalf("expected the conversation to be handed over, got %+v", seen)
+	}
+	if res.Metadata.TotalTokens != 30 {
+		t.Fatalf("expected usage of both agents, got %+v", res.Metadata)
+	}
+
+	billing = &scripted{responses: []*gen.Response{textTurn("refund issued")}}
+	s = &scripted{responses: []*gen.Response{
+		toolTurn(tools.Call{ID: "1", Name: "billing", Argument: []byte(`{}`)}),
+	}}
+	_, err = agent.New[string](&gen.Generator{Prompter: s}).
+		Budget(agent.Budget{InputTokens: 10}).
+		HandoffTo("billing", "hand over billing questions", agent.New[string](&gen.Generator{Prompter: billing})).
+		Run(prompt.AsUser("I want a refund"))
+	var exceeded *agent.BudgetExceededError[string]
+	if !errors.As(err, &exceeded) || exceeded.Limit != "input tokens" || len(billing.seen) != 0 {
+		t.Fatalf("expected the budget to stop the run after the handoff, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/tools"
+)
+
+type Action string
+
+const (
+	ActionApprove Action = "approve"
+	ActionDeny    Action = "deny"
+	ActionEdit    Action = "edit"
+)
+
+// Decision is a human verdict on a pending tool call. Decisions are matched to pending calls by ID and Name.
+type Decision struct {
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"name"`
+	Action Action `json:"action"`
+
+	Argument []byte `json:"argument,omitempty"` // replaces the call argument for ActionEdit
+	Reason   string `json:"reason,omitempty"`   // passed on to the llm for ActionDeny
+}
+
+// Approve lets the call be executed as is.
+func Approve(call tools.Call) Decision {
+	return Decision{ID: call.ID, Name: call.Name, Action: ActionApprove}
+}
+
+// Deny skips the call, the llm gets a tool response saying that the call was denied, including the reason.
+func Deny(call tools.Call, reason string) Decision {
+	return Decision{ID: call.ID, Name: call.Name, Action: ActionDeny, Reason: reason}
+}
+
+// Edit executes the call with argument instead of the argument the llm provided.
+func Edit(call tools.Call, argument []byte) Decision {
+	return Decision{ID: call.ID, Name: call.Name, Action: ActionEdit, Argument: argument}
+}
+
+func (d Decision) denial() string {
+	if d.Reason == "" {
+		return "The tool call was denied by the user."
+	}
+	return "The tool call was denied by the user, reason: " + d.Reason
+}
+
+// matchDecision finds the first unused decision for call. Gemini does not assign ids to tool calls, so several
+// calls may share the same id and name, in which case decisions are used in order.
+func matchDecision(decisions []Decision, used []bool, call tools.Call) (Decision, bool) {
+	for i, d := range decisions {
+		if used[i] || d.ID != call.ID || d.Name != call.Name {
+			continue
+		}
+		used[i] = true
+		return d, true
+	}
+	return Decision{}, false
+}
+
+// ApprovalRequiredError is returned when the llm calls one or more tools that require approval. State holds
+// the run, with the calls awaiting a decision in State.Pending.
+type ApprovalRequiredError struct {
+	State *State
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	var names []string
+	for _, call := range e.State.Pending {
+		names = append(names, call.Name)
+	}
+	return fmt.Sprintf("approval required for tool calls %s, at depth %d", strings.Join(names, ", "), e.State.Depth)
+}
+
+// Resume continues a run paused by an *ApprovalRequiredError. Pending calls are executed according to decisions
+// before the llm is prompted again, denied calls are returned to the llm as tool responses. Calls without a decision
+// stay pending, and a new *ApprovalRequiredError is returned. maxDepth and g should be the same as for the original
+// run.
+func Resume[T any](maxDepth int, parallelism int, g *gen.Generator, state *State, decisions ...Decision) (*Result[T], error) {
+	if state == nil {
+		return nil, errNoState
+	}
+	return New[T](g).MaxDepth(maxDepth).Parallelism(parallelism).Resume(state, decisions...)
+}
+
+// editToolCall rewrites the arguments of call in the transcript, so the llm sees the arguments that were executed.
+func (s *State) editToolCall(call tools.Call, argument []byte) {
+	for i := len(s.Prompts) - 1; i >= 0; i-- {
+		tc := s.Prompts[i].ToolCall
+		if s.Prompts[i].Role != prompt.ToolCallRole || tc == nil {
+			continue
+		}
+		if tc.ToolCallID != call.ID || tc.Name != call.Name || !bytes.Equal(tc.Arguments, call.Argument) {
+			continue
+		}
+		edited := *tc
+		edited.Arguments = argument
+		s.Prompts[i].ToolCall = &edited
+		return
+	}
+}
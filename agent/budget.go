@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/tools"
+)
+
+// Budget limits the spend of a run. Zero values means no limit. Limits are checked between steps, so an llm turn
+// or tool call in flight is never interrupted, and a run may end slightly above a token limit.
+type Budget struct {
+	InputTokens    int
+	OutputTokens   int
+	ThinkingTokens int
+	ToolCalls      int           // executed tool calls, calls are not run if they would exceed the limit; denied and waiting calls do not count
+	Duration       time.Duration // wall-clock time, counted from the start of Run or Resume
+
+	// FinalAnswer makes the agent do one last llm turn, with tools.NoTool, when a limit is hit, instead of
+	// returning a *BudgetExceededError. Pending tool calls are answered as not executed.
+	FinalAnswer bool
+}
+
+const budgetExhausted = "The tool call was not executed, the budget of the run is exhausted."
+
+// exceeded returns the name of the first limit that is hit, if upcoming more tool calls would be executed.
+func (b Budget) exceeded(st *State, started time.Time, upcoming int) string {
+	switch {
+	case b.InputTokens > 0 && st.Metadata.InputTokens >= b.InputTokens:
+		return "input tokens"
+	case b.OutputTokens > 0 && st.Metadata.OutputTokens >= b.OutputTokens:
+		return "output tokens"
+	case b.ThinkingTokens > 0 && st.Metadata.ThinkingTokens >= b.ThinkingTokens:
+		return "thinking tokens"
+	case b.ToolCalls > 0 && st.ToolCalls+upcoming > b.ToolCalls:
+		return "tool calls"
+	case b.Duration > 0 && time.Since(started) >= b.Duration:
+		return "duration"
+	}
+	return ""
+}
+
+// BudgetExceededError is returned when a limit of the Budget is hit. Result holds the partial result, ie. the
+// conversation and usage so far, and State can be resumed, eg. with a larger budget.
+type BudgetExceededError[T any] struct {
+	Limit  string
+	Result *Result[T]
+	State  *State
+}
+
+func (e *BudgetExceededError[T]) Error() string {
+	return fmt.Sprintf("budget exceeded for %s, at depth %d", e.Limit, e.State.Depth)
+}
+
+// exhausted ends a run that has hit limit of the budget.
+func (a *Agent[T]) exhausted(st *State, limit string) (*Result[T], error) {
+	exceeded := &BudgetExceededError[T]{
+		Limit:  limit,
+		Result: &Result[T]{Prompts: st.Prompts, Metadata: st.Metadata, Depth: st.Depth},
+		State:  st,
+	}
+	if !a.budget.FinalAnswer {
+		return nil, exceeded
+	}
+
+	final := a.clone()
+	final.budget.FinalAnswer = false
+	if a.toolsOnly {
+		final.generator = a.generator.SetToolConfig(tools.ToolChoice{Name: customResultCalculatedTool})
+	} else {
+		final.generator = a.generator.SetToolConfig(tools.NoTool)
+	}
+
+	st = st.clone()
+	for _, call := range st.Pending {
+		st.Prompts = append(st.Prompts, prompt.AsToolResponse(call.ID, call.Name, budgetExhausted))
+	}
+	st.Pending, st.Decisions = nil, nil
+
+	i := st.Depth
+	resp, err := final.turn(st)
+	if err != nil {
+		return nil, err
+	}
+	result, done, err := final.result(resp, i)
+	if err != nil {
+		return nil, err
+	}
+	if !done {
+		exceeded.Result.Metadata = st.Metadata
+		return nil, exceeded
+	}
+	return &Result[T]{
+		Prompts:  st.Prompts,
+		Result:   result,
+		Metadata: st.Metadata,
+		Depth:    i,
+	}, nil
+}
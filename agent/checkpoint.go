@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNoCheckpoint = errors.New("no checkpoint found")
+
+// Checkpointer persists the State of agent runs, keyed by run id. Load returns ErrNoCheckpoint if there is no
+// State saved for the run.
+type Checkpointer interface {
+	Save(ctx context.Context, runID string, state *State) error
+	Load(ctx context.Context, runID string) (*State, error)
+	Delete(ctx context.Context, runID string) error
+}
+
+// FileCheckpointer stores every run as a json file in a directory.
+type FileCheckpointer struct {
+	dir string
+}
+
+func NewFileCheckpointer(dir string) (*FileCheckpointer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create checkpoint dir, %w", err)
+	}
+	return &FileCheckpointer{dir: dir}, nil
+}
+
+func (f *FileCheckpointer) path(runID string) (string, error) {
+	if runID == "" || runID == "." || runID == ".." || strings.ContainsAny(runID, `/\`) {
+		return "", fmt.Errorf("invalid run id %q", runID)
+	}
+	return filepath.Join(f.dir, runID+".json"), nil
+}
+
+func (f *FileCheckpointer) Save(ctx context.Context, runID string, state *State) error {
+	path, err := f.path(runID)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("could not marshal state, %w", err)
+	}
+
+	// write to a temp file and rename, so a crash never leaves a half written checkpoint
+	tmp, err := os.CreateTemp(f.dir, runID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create checkpoint file, %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("could not write checkpoint file, %w", err)
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("could not rename checkpoint file, %w", err)
+	}
+	return nil
+}
+
+func (f *FileCheckpointer) Load(ctx context.Context, runID string) (*State, error) {
+	path, err := f.path(runID)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoCheckpoint
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read checkpoint file, %w", err)
+	}
+	var state State
+	err = json.Unmarshal(b, &state)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal state, %w", err)
+	}
+	return &state, nil
+}
+
+func (f *FileCheckpointer) Delete(ctx context.Context, runID string) error {
+	path, err := f.path(runID)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not delete checkpoint file, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+)
+
+// Compactor shrinks the conversation of a run that has grown too large for the model. The conversation package
+// holds the built in strategies, eg. conversation.DropToolOutputs, conversation.Summarize and
+// conversation.SlidingWindow.
+type Compactor interface {
+	Compact(ctx context.Context, prompts []prompt.Prompt) ([]prompt.Prompt, error)
+}
+
+// Compaction makes the agent compact the conversation with compactor before an llm turn, if it is estimated to use
+// more than maxTokens. maxTokens defaults to the InputMaxToken of the model.
+func (a *Agent[T]) Compaction(compactor Compactor, maxTokens int) *Agent[T] {
+	aa := a.clone()
+	aa.compactor = compactor
+	aa.compactAt = maxTokens
+	return aa
+}
+
+func (a *Agent[T]) compact(st *State) error {
+	if a.compactor == nil {
+		return nil
+	}
+	maxTokens := a.compactAt
+	if maxTokens == 0 {
+		maxTokens = a.generator.Request.Model.InputMaxToken
+	}
+	if maxTokens <= 0 || gen.EstimateTokens(a.generator.Request, st.Prompts...) <= maxTokens {
+		return nil
+	}
+	prompts, err := a.compactor.Compact(a.context(), st.Prompts)
+	if err != nil {
+		return fmt.Errorf("could not compact conversation: %w, at depth %d", err, st.Depth)
+	}
+	st.Prompts = prompts
+	return nil
+}
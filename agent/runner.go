@@ -0,0 +1,472 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/schema"
+	"github.com/modfin/bellman/tools"
+)
+
+const customResultCalculatedTool = "__return_result_tool__"
+
+var errNoState = errors.New("no state to resume")
+
+// State is a snapshot of an agent run between two steps. It only holds serializable data, tool references are
+// re-attached from the generator when the run is resumed.
+type State struct {
+	Prompts  []prompt.Prompt `json:"prompts"`
+	Depth    int             `json:"depth"` // number of completed llm turns
+	Metadata models.Metadata `json:"metadata"`
+
+	// Pending holds the tool calls of the latest llm turn that have not yet produced a tool response, eg. calls
+	// awaiting approval.
+	Pending []tools.Call `json:"pending,omitempty"`
+	// Decisions holds the decisions for pending calls that have been approved, but not yet executed.
+	Decisions []Decision `json:"decisions,omitempty"`
+
+	ToolCalls int  `json:"tool_calls,omitempty"` // number of executed tool calls
+	ToolsOnly bool `json:"tools_only,omitempty"` // the run was started with RunWithToolsOnly
+
+	Agent   string `json:"agent,omitempty"`   // the handoff agent in control of the run, empty for the first agent
+	Handoff string `json:"handoff,omitempty"` // the handoff requested by the latest llm turn
+
+	started time.Time // start of the Run or Resume, kept across handoffs for the Duration of the Budget
+}
+
+func (s *State) clone() *State {
+	c := *s
+	c.Prompts = append([]prompt.Prompt{}, s.Prompts...)
+	c.Pending = append([]tools.Call{}, s.Pending...)
+	c.Decisions = append([]Decision{}, s.Decisions...)
+	return &c
+}
+
+// prepare returns a copy of a with the generator set up for the expected result.
+func (a *Agent[T]) prepare(toolsOnly bool) *Agent[T] {
+	aa := a.clone()
+	aa.toolsOnly = toolsOnly
+
+	var result T
+	g := a.generator
+	if toolsOnly {
+		if g.Request.OutputSchema != nil {
+			g = g.Output(nil)
+		}
+
+		var newTools []tools.Tool
+		for _, t := range g.Tools() {
+			if t.Name == customResultCalculatedTool {
+				continue
+			}
+			newTools = append(newTools, t)
+		}
+		g = g.SetTools(newTools...)
+
+		g = g.AddTools(tools.Tool{
+			Name:           customResultCalculatedTool,
+			Description:    "Return the final results to the user",
+			ArgumentSchema: schema.From(result),
+		})
+		g = g.SetToolConfig(tools.RequiredTool)
+	} else {
+		_, resultIsString := any(result).(string)
+		if g.Request.OutputSchema == nil && !resultIsString {
+			g = g.Output(schema.From(result))
+		}
+	}
+	aa.generator = g
+	return aa
+}
+
+func (a *Agent[T]) context() context.Context {
+	if a.generator.Request.Context != nil {
+		return a.generator.Request.Context
+	}
+	return context.Background()
+}
+
+// checkpoint saves st, if a Checkpointer is configured.
+func (a *Agent[T]) checkpoint(st *State) error {
+	if a.checkpoints == nil {
+		return nil
+	}
+	err := a.checkpoints.Save(a.context(), a.runID, st)
+	if err != nil {
+		return fmt.Errorf("could not save checkpoint: %w, at depth %d", err, st.Depth)
+	}
+	return nil
+}
+
+// run drives st until the llm produces a final result. Pending calls left in st are executed before the next llm
+// turn, using decisions for the calls that require approval.
+func (a *Agent[T]) run(st *State, decisions []Decision) (*Result[T], error) {
+	if st.Metadata.Model == "" {
+		st.Metadata.Model = a.generator.Request.Model.Name
+	}
+	if st.started.IsZero() {
+		st.started = time.Now()
+	}
+	for {
+		if len(st.Pending) > 0 {
+			if limit := a.budget.exceeded(st, st.started, a.runnable(st, decisions)); limit != "" {
+				return a.exhausted(st, limit)
+			}
+			err := a.drain(st, decisions)
+			if err != nil {
+				return nil, err
+			}
+			decisions = nil
+		}
+		if st.Handoff != "" {
+			return a.handoff(st)
+		}
+		if st.Depth >= a.maxDepth {
+			break
+		}
+		if limit := a.budget.exceeded(st, st.started, 0); limit != "" {
+			return a.exhausted(st, limit)
+		}
+		err := a.compact(st)
+		if err != nil {
+			return nil, err
+		}
+
+		i := st.Depth
+		resp, err := a.turn(st)
+		if err != nil {
+			return nil, err
+		}
+
+		result, done, err := a.result(resp, i)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return &Result[T]{
+				Prompts:  st.Prompts,
+				Result:   result,
+				Metadata: st.Metadata,
+				Depth:    i,
+			}, nil
+		}
+
+		callbacks, err := resp.AsTools()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tools: %w, at depth %d", err, i)
+		}
+
+		// Pre-validate all callbacks before execution
+		for _, callback := range callbacks {
+			if callback.Ref == nil {
+				return nil, fmt.Errorf("tool %s not found in local setup", callback.Name)
+			}
+			if callback.Ref.Function == nil {
+				return nil, fmt.Errorf("tool %s has no callback function attached", callback.Name)
+			}
+		}
+
+		for _, callback := range callbacks {
+			if _, ok := a.handoffs[callback.Name]; ok && st.Handoff == "" {
+				st.Handoff = callback.Name
+			}
+		}
+
+		// Replay the assistant turn verbatim — resp.Turn carries thinking,
+		// text, and tool-call prompts in provider-correct order with any
+		// signatures already attached to Prompt.Replay.
+		st.Prompts = append(st.Prompts, resp.Turn...)
+		st.Pending = callbacks
+		err = a.checkpoint(st)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("max depth %d reached", a.maxDepth)
+}
+
+// turn prompts the llm with the conversation of st, and adds the usage of the turn to st.
+func (a *Agent[T]) turn(st *State) (*gen.Response, error) {
+	i := st.Depth
+	a.send(&Event[T]{Type: EVENT_STEP_START, Depth: i})
+	resp, err := a.prompt(i, st.Prompts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prompt: %w, at depth %d", err, i)
+	}
+	a.send(&Event[T]{Type: EVENT_STEP_END, Depth: i, Metadata: &resp.Metadata})
+	st.Depth++
+	a.account(st, resp.Metadata)
+	return resp, nil
+}
+
+// result extracts the final result from resp, done is false if the llm wants to continue calling tools.
+func (a *Agent[T]) result(resp *gen.Response, depth int) (result T, done bool, err error) {
+	if a.toolsOnly {
+		for _, callback := range resp.Tools {
+			if callback.Name != customResultCalculatedTool {
+				continue
+			}
+			err = json.Unmarshal(callback.Argument, &result)
+			if err != nil {
+				return result, false, fmt.Errorf("could not unmarshal final result: %w, at depth %d", err, depth)
+			}
+			return result, true, nil
+		}
+		return result, false, nil
+	}
+
+	if resp.IsTools() {
+		return result, false, nil
+	}
+	// Check if T is string type and handle directly
+	if _, resultIsString := any(result).(string); resultIsString {
+		text, err := resp.AsText()
+		if err != nil {
+			return result, false, fmt.Errorf("could not get text response: %w, at depth %d", err, depth)
+		}
+		// Convert string to T (which we know is string) using unsafe casting
+		return any(text).(T), true, nil
+	}
+	err = resp.Unmarshal(&result)
+	if err != nil {
+		return result, false, fmt.Errorf("could not unmarshal text response: %w, at depth %d", err, depth)
+	}
+	return result, true, nil
+}
+
+// runnable counts the pending calls of st that drain will execute, ie. those that do not require approval or are
+// approved, or edited, by a decision. Calls that are denied or waiting for a decision are not counted.
+func (a *Agent[T]) runnable(st *State, decisions []Decision) int {
+	decisions = append(append([]Decision{}, st.Decisions...), decisions...)
+	used := make([]bool, len(decisions))
+
+	var n int
+	for _, call := range st.Pending {
+		ref := call.Ref
+		if ref == nil {
+			ref = a.tool(call.Name)
+		}
+		if ref == nil || !ref.RequiresApproval {
+			n++
+			continue
+		}
+		decision, ok := matchDecision(decisions, used, call)
+		if ok && decision.Action != ActionDeny {
+			n++
+		}
+	}
+	return n
+}
+
+// drain executes the pending calls of st and appends their tool responses, in call order. Calls that require
+// approval but has no matching decision are left pending, and an *ApprovalRequiredError is returned.
+func (a *Agent[T]) drain(st *State, decisions []Decision) error {
+	decisions = append(st.Decisions, decisions...)
+	used := make([]bool, len(decisions))
+
+	var ready []tools.Call
+	var approved []Decision // decisions of the calls in ready that required approval
+	var waiting []tools.Call
+	var denied []prompt.Prompt
+	for _, call := range st.Pending {
+		if call.Ref == nil {
+			call.Ref = a.tool(call.Name)
+		}
+		if call.Ref == nil {
+			return fmt.Errorf("tool %s not found in local setup", call.Name)
+		}
+		if call.Ref.Function == nil {
+			return fmt.Errorf("tool %s has no callback function attached", call.Name)
+		}
+		if !call.Ref.RequiresApproval {
+			ready = append(ready, call)
+			approved = append(approved, Decision{})
+			continue
+		}
+
+		decision, ok := matchDecision(decisions, used, call)
+		if !ok {
+			waiting = append(waiting, call)
+			continue
+		}
+		switch decision.Action {
+		case ActionApprove:
+			ready = append(ready, call)
+			approved = append(approved, decision)
+		case ActionEdit:
+			st.editToolCall(call, decision.Argument)
+			call.Argument = decision.Argument
+			ready = append(ready, call)
+			approved = append(approved, decision)
+		case ActionDeny:
+			denied = append(denied, prompt.AsToolResponse(call.ID, call.Name, decision.denial()))
+			a.send(&Event[T]{Type: EVENT_TOOL_RESULT, Depth: st.Depth - 1, ToolCall: &call, Content: decision.denial()})
+		default:
+			return fmt.Errorf("unknown decision action %q for tool %s", decision.Action, call.Name)
+		}
+	}
+	for i, decision := range decisions {
+		if !used[i] {
+			return fmt.Errorf("decision for tool %s does not match any pending tool call", decision.Name)
+		}
+	}
+
+	// pending returns what is left to do, ie. the waiting calls and the ready calls that are not completed
+	completed := make([]bool, len(ready))
+	pending := func() ([]tools.Call, []Decision) {
+		calls := append([]tools.Call{}, waiting...)
+		var ds []Decision
+		for i, call := range ready {
+			if completed[i] {
+				continue
+			}
+			calls = append(calls, call)
+			if approved[i].Action != "" {
+				ds = append(ds, approved[i])
+			}
+		}
+		return calls, ds
+	}
+
+	st.Prompts = append(st.Prompts, denied...)
+	st.Pending, st.Decisions = pending()
+	err := a.checkpoint(st)
+	if err != nil {
+		return err
+	}
+
+	// Tool responses are appended, and checkpointed, as soon as all earlier calls are done. A failing tool stops
+	// the run, leaving the failed call pending, and in sequential mode the calls after it. In parallel mode the
+	// calls after it have already run, so the responses of those that succeeded are kept, and they are not run
+	// again on resume.
+	ctx, nested := withUsage(a.context())
+	var failed *callbackResult
+	onResult := func(cbResult callbackResult) {
+		// usage of nested runs is accounted for, even if the call failed
+		a.account(st, nested.take())
+		if err != nil {
+			return
+		}
+		if cbResult.Error != nil {
+			if failed == nil {
+				failed = &cbResult
+			}
+			return
+		}
+		st.Prompts = append(st.Prompts, prompt.AsToolResponse(cbResult.ID, cbResult.Name, cbResult.Response))
+		a.send(&Event[T]{Type: EVENT_TOOL_RESULT, Depth: st.Depth - 1, ToolCall: &ready[cbResult.Index], Content: cbResult.Response})
+		st.ToolCalls++
+		completed[cbResult.Index] = true
+		st.Pending, st.Decisions = pending()
+		err = a.checkpoint(st)
+	}
+	if a.parallelism <= 1 {
+		executeCallbacksSequential(ctx, ready, onResult)
+	} else {
+		executeCallbacksParallel(ctx, ready, a.parallelism, onResult)
+	}
+	if failed != nil {
+		callback := ready[failed.Index]
+		return fmt.Errorf("tool %s failed: %w, arg: %s", failed.Name, failed.Error, callback.Argument)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(waiting) > 0 {
+		return &ApprovalRequiredError{State: st}
+	}
+	return nil
+}
+
+func (a *Agent[T]) tool(name string) *tools.Tool {
+	for _, t := range a.generator.Tools() {
+		if t.Name == name {
+			return &t
+		}
+	}
+	return nil
+}
+
+// callbackResult holds the result of a single callback execution
+type callbackResult struct {
+	Index    int
+	ID       string
+	Name     string
+	Response string
+	Error    error
+}
+
+// executeCallbacksSequential executes callbacks one by one, and stops at the first that fails
+func executeCallbacksSequential(ctx context.Context, callbacks []tools.Call, onResult func(callbackResult)) []callbackResult {
+	var results []callbackResult
+
+	for i, callback := range callbacks {
+		response, err := callback.Ref.Function(ctx, callback)
+		results = append(results, callbackResult{
+			Index:    i,
+			ID:       callback.ID,
+			Name:     callback.Name,
+			Response: response,
+			Error:    err,
+		})
+		onResult(results[i])
+		if err != nil {
+			break
+		}
+	}
+
+	return results
+}
+
+// executeCallbacksParallel executes callbacks in parallel with limited concurrency. onResult is called one result
+// at a time, in call order, as soon as all earlier callbacks have finished.
+func executeCallbacksParallel(ctx context.Context, callbacks []tools.Call, parallelism int, onResult func(callbackResult)) []callbackResult {
+	numCallbacks := len(callbacks)
+	results := make([]callbackResult, numCallbacks)
+	finished := make([]bool, numCallbacks)
+	next := 0
+
+	// Use a semaphore to limit concurrency
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for i, callback := range callbacks {
+		wg.Add(1)
+		go func(index int, cb tools.Call) {
+			defer wg.Done()
+
+			// Acquire semaphore
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			response, err := cb.Ref.Function(ctx, cb)
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[index] = callbackResult{
+				Index:    index,
+				ID:       cb.ID,
+				Name:     cb.Name,
+				Response: response,
+				Error:    err,
+			}
+			finished[index] = true
+			for next < numCallbacks && finished[next] {
+				onResult(results[next])
+				next++
+			}
+		}(i, callback)
+	}
+
+	wg.Wait()
+	return results
+}
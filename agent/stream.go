@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/tools"
+)
+
+type EventType string
+
+const EVENT_STEP_START EventType = "step_start"         // an llm turn starts
+const EVENT_DELTA EventType = "delta"                   // text delta from the llm
+const EVENT_THINKING_DELTA EventType = "thinking_delta" // thinking delta from the llm
+const EVENT_TOOL_CALL_START EventType = "tool_call_start"
+const EVENT_TOOL_CALL EventType = "tool_call"     // the llm has finished a tool call, ToolCall holds the full argument
+const EVENT_TOOL_RESULT EventType = "tool_result" // a tool call has been executed, or denied, Content holds the response
+const EVENT_STEP_END EventType = "step_end"       // an llm turn has ended, Metadata holds the usage of the turn
+const EVENT_HANDOFF EventType = "handoff"         // the run was handed over to another agent, Content holds its name
+const EVENT_RESULT EventType = "result"           // the run is done, always the last event on success
+const EVENT_ERROR EventType = "error"             // the run failed, always the last event on failure
+
+// Event is a single event of a streamed agent run. Depth is the llm turn the event belongs to.
+type Event[T any] struct {
+	Type     EventType
+	Depth    int
+	Content  string
+	ToolCall *tools.Call
+	Metadata *models.Metadata
+	Result   *Result[T]
+	Error    error
+}
+
+// RunStream works like Run, but streams every llm turn, tool call and tool result as events. The channel is closed
+// after a single EVENT_RESULT, or EVENT_ERROR, event.
+func RunStream[T any](maxDepth int, parallelism int, g *gen.Generator, prompts ...prompt.Prompt) (<-chan *Event[T], error) {
+	return New[T](g).MaxDepth(maxDepth).Parallelism(parallelism).Stream(prompts...)
+}
+
+// Stream starts a new run from prompts, driving the llm with Generator.Stream, see RunStream. The events must be
+// consumed until the channel is closed, or the context of the generator is cancelled.
+func (a *Agent[T]) Stream(prompts ...prompt.Prompt) (<-chan *Event[T], error) {
+	if a.generator == nil || a.generator.Prompter == nil {
+		return nil, errors.New("prompter is required")
+	}
+
+	events := make(chan *Event[T])
+	ctx := a.context()
+	aa := a.prepare(a.toolsOnly)
+	aa.emit = func(e *Event[T]) {
+		select {
+		case events <- e:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		defer close(events)
+		st := &State{
+			Prompts:   append([]prompt.Prompt{}, prompts...),
+			ToolsOnly: aa.toolsOnly,
+		}
+		res, err := aa.run(st, nil)
+		if err != nil {
+			aa.emit(&Event[T]{Type: EVENT_ERROR, Depth: st.Depth, Error: err})
+			return
+		}
+		aa.emit(&Event[T]{Type: EVENT_RESULT, Depth: res.Depth, Result: res, Metadata: &res.Metadata})
+	}()
+	return events, nil
+}
+
+func (a *Agent[T]) send(e *Event[T]) {
+	if a.emit != nil {
+		a.emit(e)
+	}
+}
+
+// prompt runs a single llm turn, streaming it if the run is streamed.
+func (a *Agent[T]) prompt(depth int, prompts []prompt.Prompt) (*gen.Response, error) {
+	if a.emit == nil {
+		return a.generator.Prompt(prompts...)
+	}
+	return a.stream(depth, prompts)
+}
+
+// stream runs a single llm turn with Generator.Stream, forwarding deltas as events, and assembles the turn into a
+// gen.Response, as if it had been prompted.
+func (a *Agent[T]) stream(depth int, prompts []prompt.Prompt) (*gen.Response, error) {
+	stream, err := a.generator.Stream(prompts...)
+	if err != nil {
+		return nil, err
+	}
+	// the provider may block on sending, so the stream is drained if we stop early
+	defer func() {
+		go func() {
+			for range stream {
+			}
+		}()
+	}()
+
+	resp := &gen.Response{}
+	var text, thinking, blockText strings.Builder
+	started := map[string]bool{}
+	start := func(call *tools.Call) {
+		key := call.ID + "/" + call.Name
+		if started[key] {
+			return
+		}
+		started[key] = true
+		a.send(&Event[T]{Type: EVENT_TOOL_CALL_START, Depth: depth, ToolCall: &tools.Call{ID: call.ID, Name: call.Name, Ref: call.Ref}})
+	}
+
+	for r := range stream {
+		switch r.Type {
+		case gen.TYPE_DELTA:
+			if r.ToolCall != nil {
+				start(r.ToolCall)
+				continue
+			}
+			if r.Role == prompt.AssistantRole {
+				text.WriteString(r.Content)
+				a.send(&Event[T]{Type: EVENT_DELTA, Depth: depth, Content: r.Content})
+			}
+		case gen.TYPE_THINKING_DELTA:
+			thinking.WriteString(r.Content)
+			a.send(&Event[T]{Type: EVENT_THINKING_DELTA, Depth: depth, Content: r.Content})
+		case gen.TYPE_BLOCK:
+			if r.Block == nil {
+				continue
+			}
+			resp.Turn = append(resp.Turn, *r.Block)
+			if r.Block.Role == prompt.AssistantRole {
+				blockText.WriteString(r.Block.Text)
+			}
+			if r.ToolCall != nil {
+				call := *r.ToolCall
+				start(&call)
+				resp.Tools = append(resp.Tools, call)
+				a.send(&Event[T]{Type: EVENT_TOOL_CALL, Depth: depth, ToolCall: &call})
+			}
+		case gen.TYPE_METADATA:
+			// the last metadata event holds the usage of the whole turn
+			if r.Metadata != nil {
+				resp.Metadata = *r.Metadata
+			}
+		case gen.TYPE_ERROR:
+			return nil, r.Error()
+		case gen.TYPE_EOF:
+		}
+	}
+
+	if text.Len() == 0 {
+		text.WriteString(blockText.String())
+	}
+	if text.Len() > 0 {
+		resp.Texts = []string{text.String()}
+	}
+	if thinking.Len() > 0 {
+		resp.Thinking = []string{thinking.String()}
+	}
+	if len(resp.Turn) == 0 && text.Len() > 0 {
+		// providers that does not emit blocks
+		resp.Turn = []prompt.Prompt{prompt.AsAssistant(text.String())}
+	}
+	return resp, nil
+}
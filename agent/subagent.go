@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/tools"
+)
+
+type task struct {
+	Task string `json:"task" json-description:"the task to solve, including all the context needed to solve it"`
+}
+
+// AsTool wraps the agent as a tool, letting the llm of another agent delegate tasks to it. Every call starts a new
+// run, with the task as user prompt, and returns the result as tool response, as json unless T is a string. Token
+// usage of the nested run is added to the Metadata of the calling run.
+func (a *Agent[T]) AsTool(name string, description string) tools.Tool {
+	return tools.NewTool(name,
+		tools.WithDescription(description),
+		tools.WithArgSchema(task{}),
+		tools.WithFunction(func(ctx context.Context, call tools.Call) (string, error) {
+			var arg task
+			err := json.Unmarshal(call.Argument, &arg)
+			if err != nil {
+				return "", fmt.Errorf("could not unmarshal task, %w", err)
+			}
+
+			sub := a.clone()
+			sub.generator = a.generator.WithContext(ctx)
+			res, err := sub.Run(prompt.AsUser(arg.Task))
+			if err != nil {
+				return "", fmt.Errorf("agent %s failed, %w", name, err)
+			}
+			if text, ok := any(res.Result).(string); ok {
+				return text, nil
+			}
+			b, err := json.Marshal(res.Result)
+			if err != nil {
+				return "", fmt.Errorf("could not marshal result of agent %s, %w", name, err)
+			}
+			return string(b), nil
+		}),
+	)
+}
+
+// HandoffTo lets the llm pass control of the run to another agent, by calling a tool named name. The other agent
+// continues the conversation with its own generator, ie. its own system prompt and tools, and produces the result.
+// The Budget of a, if any, still applies to the run after the handoff, counting the usage from before it.
+func (a *Agent[T]) HandoffTo(name string, description string, to *Agent[T]) *Agent[T] {
+	aa := a.clone()
+	aa.handoffs = map[string]*Agent[T]{}
+	for n, h := range a.handoffs {
+		aa.handoffs[n] = h
+	}
+	aa.handoffs[name] = to
+	aa.generator = a.generator.AddTools(tools.NewTool(name,
+		tools.WithDescription(description),
+		tools.WithArgSchema(tools.EmptyArgs{}),
+		tools.WithFunction(func(ctx context.Context, call tools.Call) (string, error) {
+			return fmt.Sprintf("The conversation was handed over to %s.", name), nil
+		}),
+	))
+	return aa
+}
+
+// find returns the agent in control after the handoff to name, searching handoffs of handoffs.
+func (a *Agent[T]) find(name string) *Agent[T] {
+	if to, ok := a.handoffs[name]; ok {
+		return to
+	}
+	for _, h := range a.handoffs {
+		if to := h.find(name); to != nil {
+			return to
+		}
+	}
+	return nil
+}
+
+// handoff continues st with the agent it was handed over to. The run keeps the stream, checkpoints and budget of
+// a, and the usage and start time in st, so the agent handed over to is bound by the limits of the run.
+func (a *Agent[T]) handoff(st *State) (*Result[T], error) {
+	name := st.Handoff
+	to := a.find(name)
+	if to == nil {
+		return nil, fmt.Errorf("handoff agent %s not found", name)
+	}
+	a.send(&Event[T]{Type: EVENT_HANDOFF, Depth: st.Depth - 1, Content: name})
+
+	st.Agent = name
+	st.Handoff = ""
+	st.ToolsOnly = to.toolsOnly
+	return a.inherit(to).prepare(st.ToolsOnly).run(st, nil)
+}
+
+// inherit returns a copy of to, that streams, checkpoints and is budgeted like a.
+func (a *Agent[T]) inherit(to *Agent[T]) *Agent[T] {
+	next := to.clone()
+	next.emit = a.emit
+	if a.budget != (Budget{}) {
+		next.budget = a.budget
+	}
+	if next.checkpoints == nil {
+		next.checkpoints = a.checkpoints
+		next.runID = a.runID
+	}
+	return next
+}
+
+// usage collects the token usage of nested runs, started from tool calls.
+type usage struct {
+	mu       sync.Mutex
+	metadata models.Metadata
+}
+
+type usageKey struct{}
+
+func withUsage(ctx context.Context) (context.Context, *usage) {
+	u := &usage{}
+	return context.WithValue(ctx, usageKey{}, u), u
+}
+
+func (u *usage) add(m models.Metadata) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	addUsage(&u.metadata, m)
+}
+
+// take returns the usage collected since the last call.
+func (u *usage) take() models.Metadata {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	m := u.metadata
+	u.metadata = models.Metadata{}
+	return m
+}
+
+func addUsage(dst *models.Metadata, m models.Metadata) {
+	dst.InputTokens += m.InputTokens
+	dst.ThinkingTokens += m.ThinkingTokens
+	dst.OutputTokens += m.OutputTokens
+	dst.TotalTokens += m.TotalTokens
+}
+
+// account adds m to the usage of st, and reports it to the run that started a, if a is a nested run.
+func (a *Agent[T]) account(st *State, m models.Metadata) {
+	addUsage(&st.Metadata, m)
+	if u, ok := a.context().Value(usageKey{}).(*usage); ok {
+		u.add(m)
+	}
+}
@@ -24,9 +24,11 @@ import (
 	"github.com/google/uuid"
 	"github.com/lmittmann/tint"
 	"github.com/modfin/bellman"
+	"github.com/modfin/bellman/gencache"
 	"github.com/modfin/bellman/models"
 	"github.com/modfin/bellman/models/embed"
 	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/models/rerank"
 	"github.com/modfin/bellman/services/anthropic"
 	"github.com/modfin/bellman/services/fireworks"
 	"github.com/modfin/bellman/services/ollama"
@@ -145,6 +147,12 @@ func main() {
 				EnvVars: []string{"BELLMAN_VLLM_MODEL"},
 				Usage:   `The model loaded on url, has to be in the same order as vllm-url. Supports * if you want to direct all requests to the same url.`,
 			},
+			&cli.StringFlag{
+				Name:    "vllm-api",
+				EnvVars: []string{"BELLMAN_VLLM_API"},
+				Value:   string(openai.APIResponses),
+				Usage:   `The api used for generation, 'responses' or 'chat_completions' for vllm versions without /v1/responses`,
+			},
 
 			&cli.StringFlag{
 				Name:    "fireworks-key",
@@ -166,6 +174,12 @@ func main() {
 				EnvVars: []string{"BELLMAN_OMLX_KEY"},
 				Usage:   `Optional API key, when the oMLX server is started with --api-key`,
 			},
+			&cli.StringFlag{
+				Name:    "omlx-api",
+				EnvVars: []string{"BELLMAN_OMLX_API"},
+				Value:   string(openai.APIResponses),
+				Usage:   `The api used for generation, 'responses' or 'chat_completions'`,
+			},
 
 			&cli.BoolFlag{
 				Name:    "disable-gen-models",
@@ -175,6 +189,35 @@ func main() {
 				Name:    "disable-embed-models",
 				EnvVars: []string{"BELLMAN_DISABLE_EMBED_MODELS"},
 			},
+			&cli.BoolFlag{
+				Name:    "disable-rerank-models",
+				EnvVars: []string{"BELLMAN_DISABLE_RERANK_MODELS"},
+			},
+			&cli.BoolFlag{
+				Name:    "validate-capabilities",
+				EnvVars: []string{"BELLMAN_VALIDATE_CAPABILITIES"},
+				Usage:   "Reject gen requests using tools, structured output, content types or max tokens not supported by the model, before calling the provider",
+			},
+			&cli.IntFlag{
+				Name:    "embed-cache-size",
+				EnvVars: []string{"BELLMAN_EMBED_CACHE_SIZE"},
+				Usage:   "Cache up to this many embeddings in memory, the least recently used are evicted. 0 disables the cache",
+			},
+			&cli.StringFlag{
+				Name:    "embed-cache-dir",
+				EnvVars: []string{"BELLMAN_EMBED_CACHE_DIR"},
+				Usage:   "Cache embeddings on disk in this directory, which is never evicted. Takes precedence over embed-cache-size",
+			},
+			&cli.IntFlag{
+				Name:    "gen-cache-size",
+				EnvVars: []string{"BELLMAN_GEN_CACHE_SIZE"},
+				Usage:   "Cache up to this many gen responses in memory, for api keys with cache enabled in api-key-json-config. 0 disables the cache",
+			},
+			&cli.StringFlag{
+				Name:    "gen-cache-ttl",
+				EnvVars: []string{"BELLMAN_GEN_CACHE_TTL"},
+				Usage:   "How long gen responses are cached, eg. '1h'. Cached until evicted if not set",
+			},
 
 			&cli.StringFlag{
 				Name:    "prometheus-metrics-basic-auth",
@@ -292,8 +335,17 @@ type Config struct {
 	HttpPort         int `cli:"http-port"`
 	InternalHttpPort int `cli:"internal-http-port"`
 
-	DisableGenModels   bool `cli:"disable-gen-models"`
-	DisableEmbedModels bool `cli:"disable-embed-models"`
+	DisableGenModels    bool `cli:"disable-gen-models"`
+	DisableEmbedModels  bool `cli:"disable-embed-models"`
+	DisableRerankModels bool `cli:"disable-rerank-models"`
+
+	ValidateCapabilities bool `cli:"validate-capabilities"`
+
+	EmbedCacheSize int    `cli:"embed-cache-size"`
+	EmbedCacheDir  string `cli:"embed-cache-dir"`
+
+	GenCacheSize int    `cli:"gen-cache-size"`
+	GenCacheTTL  string `cli:"gen-cache-ttl"`
 
 	AnthropicKey string `cli:"anthropic-key"`
 	OpenAiKey    string `cli:"openai-key"`
@@ -302,27 +354,32 @@ type Config struct {
 	OllamaURL    string   `cli:"ollama-url"`
 	VLLMURL      []string `cli:"vllm-url"`
 	VLLMModel    []string `cli:"vllm-model"`
+	VLLMAPI      string   `cli:"vllm-api"`
 	FireworksKey string   `cli:"fireworks-key"`
 	XAiKey       string   `cli:"xai-key"`
 	OMLXURL      string   `cli:"omlx-url"`
 	OMLXKey      string   `cli:"omlx-key"`
+	OMLXAPI      string   `cli:"omlx-api"`
 
 	PrometheusPushUrl string `cli:"prometheus-push-url"`
 }
 
 type ApiKeyConfig struct {
-	Id           string           `json:"id"`
-	Key          string           `json:"key"`
-	DisableGen   bool             `json:"disable_gen"`
-	DisableEmbed bool             `json:"disable_embed"`
-	RateLimit    *RateLimitConfig `json:"rate_limit"`
+	Id            string           `json:"id"`
+	Key           string           `json:"key"`
+	DisableGen    bool             `json:"disable_gen"`
+	DisableEmbed  bool             `json:"disable_embed"`
+	DisableRerank bool             `json:"disable_rerank"`
+	RateLimit     *RateLimitConfig `json:"rate_limit"`
+	Cache         bool             `json:"cache"` // caches gen responses of the key, if gen-cache-size is set
 }
 
 type featureType string
 
 const (
-	featureTypeGen   featureType = "gen"
-	featureTypeEmbed featureType = "embed"
+	featureTypeGen    featureType = "gen"
+	featureTypeEmbed  featureType = "embed"
+	featureTypeRerank featureType = "rerank"
 )
 
 func auth(apiKeyConfigs map[string]ApiKeyConfig, feature featureType) func(next http.Handler) http.Handler {
@@ -363,10 +420,15 @@ func auth(apiKeyConfigs map[string]ApiKeyConfig, feature featureType) func(next
 				httpErr(w, fmt.Errorf("embedding feature is disabled for this api key"), http.StatusForbidden)
 				return
 			}
+			if feature == featureTypeRerank && apiKeyConfig.DisableRerank {
+				httpErr(w, fmt.Errorf("rerank feature is disabled for this api key"), http.StatusForbidden)
+				return
+			}
 
 			ctx := r.Context()
 			ctx = context.WithValue(ctx, "api-key-name", name)
 			ctx = context.WithValue(ctx, "api-key-id", apiKeyConfig.Id)
+			ctx = context.WithValue(ctx, "api-key-cache", apiKeyConfig.Cache)
 			r = r.WithContext(ctx)
 
 			next.ServeHTTP(w, r)
@@ -393,6 +455,19 @@ func serve(cfg Config, apiKeyConfigs map[string]ApiKeyConfig) error {
 		logger.Info("Rate limiting enabled for api keys", "keys", len(rateLimiter.limits))
 	}
 
+	var genCache *gencache.Cache
+	if cfg.GenCacheSize > 0 {
+		var ttl time.Duration
+		if cfg.GenCacheTTL != "" {
+			ttl, err = time.ParseDuration(cfg.GenCacheTTL)
+			if err != nil {
+				return fmt.Errorf("could not parse gen-cache-ttl, %w", err)
+			}
+		}
+		genCache = gencache.New(gencache.NewMemoryStore(cfg.GenCacheSize), gencache.WithTTL(ttl))
+		logger.Info("Start", "action", "[gen] caching in memory", "size", cfg.GenCacheSize, "ttl", ttl)
+	}
+
 	h := chi.NewRouter()
 
 	r := func() *chi.Mux {
@@ -418,7 +493,10 @@ func serve(cfg Config, apiKeyConfigs map[string]ApiKeyConfig) error {
 		r.Route("/embed", Embed(proxy, apiKeyConfigs, rateLimiter))
 	}
 	if !cfg.DisableGenModels {
-		r.Route("/gen", Gen(proxy, apiKeyConfigs, rateLimiter))
+		r.Route("/gen", Gen(proxy, apiKeyConfigs, rateLimiter, cfg.ValidateCapabilities, genCache))
+	}
+	if !cfg.DisableRerankModels {
+		r.Route("/rerank", Rerank(proxy, apiKeyConfigs, rateLimiter))
 	}
 
 	server := &http.Server{Addr: fmt.Sprintf(":%d", cfg.HttpPort), Handler: h}
@@ -529,7 +607,7 @@ func (p *PromPusher) Stop(ctx context.Context) error {
 	return nil
 }
 
-func Gen(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimiter *RateLimiter) func(r chi.Router) {
+func Gen(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimiter *RateLimiter, validateCapabilities bool, cache *gencache.Cache) func(r chi.Router) {
 
 	var reqCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -608,8 +686,19 @@ func Gen(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimite
 				return
 			}
 
+			if validateCapabilities {
+				req.ValidateCapabilities = true
+				req.Model = withCapabilities(req.Model)
+			}
 			generator = generator.SetConfig(req.Request).WithContext(r.Context())
+			if cache != nil && r.Context().Value("api-key-cache").(bool) {
+				generator = cache.WrapNamespace(generator, apiKeyId)
+			}
 			response, err := generator.Prompt(req.Prompts...)
+			if errors.Is(err, gen.ErrUnsupported) {
+				httpErr(w, err, http.StatusBadRequest)
+				return
+			}
 			if err != nil {
 				logger.Error("gen request", "err", err, "apiKeyId", apiKeyId, "key", keyName)
 				err = fmt.Errorf("could not generate text, %w", err)
@@ -617,8 +706,11 @@ func Gen(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimite
 				return
 			}
 
-			// Consume actual tokens used
-			rateLimiter.Consume(apiKeyId, response.Metadata.TotalTokens)
+			// Consume actual tokens used, cached responses used none
+			cacheHit := response.Metadata.Other["cache_hit"] == true
+			if !cacheHit {
+				rateLimiter.Consume(apiKeyId, response.Metadata.TotalTokens)
+			}
 
 			logger.Info("gen request",
 				"apiKeyId", apiKeyId,
@@ -628,6 +720,7 @@ func Gen(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimite
 				"token-thinking", response.Metadata.ThinkingTokens,
 				"token-output", response.Metadata.OutputTokens,
 				"token-total", response.Metadata.TotalTokens,
+				"cache-hit", cacheHit,
 			)
 
 			// Taking some metrics...
@@ -643,6 +736,64 @@ func Gen(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimite
 
 		})
 
+		r.Post("/tokens", func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				err = fmt.Errorf("could not read request, %w", err)
+				httpErr(w, err, http.StatusBadRequest)
+				return
+			}
+
+			var req gen.FullRequest
+			err = json.Unmarshal(body, &req)
+			if err != nil {
+				err = fmt.Errorf("could not decode request, %w", err)
+				httpErr(w, err, http.StatusBadRequest)
+				return
+			}
+
+			apiKeyId := r.Context().Value("api-key-id").(string)
+			keyName := r.Context().Value("api-key-name").(string)
+
+			if !rateLimiter.HasCapacity(apiKeyId) {
+				logger.Warn("rate limit exceeded (pre-check)",
+					"apiKeyId", apiKeyId,
+					"key", keyName,
+					"model", req.Model.FQN(),
+				)
+				httpErr(w, fmt.Errorf("rate limit exceeded"), http.StatusTooManyRequests)
+				return
+			}
+
+			generator, err := proxy.Gen(req.Model)
+			if err != nil {
+				err = fmt.Errorf("could not get generator, %w", err)
+				httpErr(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			generator = generator.SetConfig(req.Request).WithContext(r.Context())
+			count, err := generator.CountTokens(req.Prompts...)
+			if err != nil {
+				logger.Error("gen tokens request", "err", err, "apiKeyId", apiKeyId, "key", keyName)
+				err = fmt.Errorf("could not count tokens, %w", err)
+				httpErr(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			logger.Info("gen tokens request",
+				"apiKeyId", apiKeyId,
+				"key", keyName,
+				"model", req.Model.FQN(),
+				"token-input", count.InputTokens,
+				"estimated", count.Estimated,
+			)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(count)
+		})
+
 		r.Post("/stream", func(w http.ResponseWriter, r *http.Request) {
 			body, err := io.ReadAll(r.Body)
 			if err != nil {
@@ -682,10 +833,21 @@ func Gen(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimite
 				return
 			}
 
+			if validateCapabilities {
+				req.ValidateCapabilities = true
+				req.Model = withCapabilities(req.Model)
+			}
 			generator = generator.SetConfig(req.Request).WithContext(r.Context())
+			if cache != nil && r.Context().Value("api-key-cache").(bool) {
+				generator = cache.WrapNamespace(generator, apiKeyId)
+			}
 
 			// Get streaming response
 			stream, err := generator.Stream(req.Prompts...)
+			if errors.Is(err, gen.ErrUnsupported) {
+				httpErr(w, err, http.StatusBadRequest)
+				return
+			}
 			if err != nil {
 				logger.Error("gen stream request", "err", err, "apiKeyId", apiKeyId, "key", keyName)
 				err = fmt.Errorf("could not start streaming, %w", err)
@@ -763,8 +925,11 @@ func Gen(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimite
 				modelName = req.Model.FQN()
 			}
 
-			// Consume actual tokens used (using API key for rate limiting)
-			rateLimiter.Consume(apiKeyId, totalTokens)
+			// Consume actual tokens used (using API key for rate limiting), cached streams used none
+			cacheHit := tokenMetadata.Other["cache_hit"] == true
+			if !cacheHit {
+				rateLimiter.Consume(apiKeyId, totalTokens)
+			}
 
 			// Log final metrics
 			logger.Info("gen stream completed",
@@ -775,6 +940,7 @@ func Gen(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimite
 				"token-thinking", tokenMetadata.ThinkingTokens,
 				"token-output", tokenMetadata.OutputTokens,
 				"token-total", totalTokens,
+				"cache-hit", cacheHit,
 			)
 
 			// Update metrics
@@ -787,6 +953,28 @@ func Gen(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimite
 	}
 }
 
+// knownGenModels holds the capability metadata of the models known to bellman, by FQN.
+var knownGenModels = func() map[string]gen.Model {
+	known := map[string]gen.Model{}
+	for _, m := range []map[string]gen.Model{anthropic.GenModels, openai.GenModels, vertexai.GenModels, ollama.GenModels} {
+		for _, model := range m {
+			known[model.FQN()] = model
+		}
+	}
+	return known
+}()
+
+// withCapabilities adds the capability metadata of known models to m, when the client did not send any, eg. if the
+// model was created with gen.ToModel.
+func withCapabilities(m gen.Model) gen.Model {
+	known, ok := knownGenModels[m.FQN()]
+	if !ok || m.SupportTools || m.SupportStructuredOutput || len(m.InputContentTypes) > 0 || m.InputMaxToken > 0 || m.OutputMaxToken > 0 {
+		return m
+	}
+	known.Config = m.Config
+	return known
+}
+
 func Embed(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimiter *RateLimiter) func(r chi.Router) {
 
 	var reqCounter = prometheus.NewCounterVec(
@@ -847,7 +1035,9 @@ func Embed(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimi
 				"key", keyName,
 				"model", req.Model.FQN(),
 				"texts", len(req.Texts),
+				"inputs", len(req.Inputs),
 				"token-total", response.Metadata.TotalTokens,
+				"cache-hits", response.Metadata.Other["cache_hits"],
 			)
 
 			// Taking some metrics...
@@ -911,10 +1101,115 @@ func Embed(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimi
 	}
 }
 
+func Rerank(proxy *bellman.Proxy, apiKeyConfigs map[string]ApiKeyConfig, rateLimiter *RateLimiter) func(r chi.Router) {
+
+	var reqCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "bellman_rerank_request_count",
+			Help:        "Number of request per key",
+			ConstLabels: nil,
+		},
+		[]string{"model", "key"},
+	)
+
+	var tokensCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "bellman_rerank_token_count",
+			Help:        "Number of token processed by model and key",
+			ConstLabels: nil,
+		},
+		[]string{"model", "key"},
+	)
+	prometheus.MustRegister(reqCounter, tokensCounter)
+
+	return func(r chi.Router) {
+		r.Use(auth(apiKeyConfigs, featureTypeRerank))
+
+		r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+			var req rerank.Request
+			err := json.NewDecoder(r.Body).Decode(&req)
+			if err != nil {
+				err = fmt.Errorf("could not decode request, %w", err)
+				httpErr(w, err, http.StatusBadRequest)
+				return
+			}
+			req.Ctx = r.Context()
+
+			apiKeyId := r.Context().Value("api-key-id").(string)
+			keyName := r.Context().Value("api-key-name").(string)
+
+			if !rateLimiter.HasCapacity(apiKeyId) {
+				logger.Warn("rate limit exceeded (pre-check)",
+					"apiKeyId", apiKeyId,
+					"key", keyName,
+					"model", req.Model.FQN(),
+				)
+				httpErr(w, fmt.Errorf("rate limit exceeded"), http.StatusTooManyRequests)
+				return
+			}
+
+			response, err := proxy.Rerank(&req)
+			if errors.Is(err, bellman.ErrNoModelProvided) {
+				err = fmt.Errorf("could not rerank documents, %w", err)
+				httpErr(w, err, http.StatusBadRequest)
+				return
+			}
+			if errors.Is(err, bellman.ErrClientNotFound) {
+				err = fmt.Errorf("could not rerank documents, %w", err)
+				httpErr(w, err, http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				err = fmt.Errorf("could not rerank documents, %w", err)
+				httpErr(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			rateLimiter.Consume(apiKeyId, response.Metadata.TotalTokens)
+
+			logger.Info("rerank request",
+				"apiKeyId", apiKeyId,
+				"key", keyName,
+				"model", req.Model.FQN(),
+				"documents", len(req.Documents),
+				"top-k", req.TopK,
+				"token-total", response.Metadata.TotalTokens,
+			)
+
+			reqCounter.WithLabelValues(response.Metadata.Model, keyName).Inc()
+			tokensCounter.WithLabelValues(response.Metadata.Model, keyName).Add(float64(response.Metadata.TotalTokens))
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(response)
+		})
+	}
+}
+
 func setupProxy(cfg Config) (*bellman.Proxy, error) {
 
 	proxy := bellman.NewProxy()
 
+	var cache embed.CacheStore
+	switch {
+	case cfg.EmbedCacheDir != "":
+		store, err := embed.NewDiskCache(cfg.EmbedCacheDir)
+		if err != nil {
+			return nil, err
+		}
+		cache = store
+		logger.Info("Start", "action", "[embed] caching on disk", "dir", cfg.EmbedCacheDir)
+	case cfg.EmbedCacheSize > 0:
+		cache = embed.NewMemoryCache(cfg.EmbedCacheSize)
+		logger.Info("Start", "action", "[embed] caching in memory", "size", cfg.EmbedCacheSize)
+	}
+	registerEmbeder := func(client embed.Embeder) {
+		if cache != nil {
+			client = embed.NewCache(client, cache)
+		}
+		proxy.RegisterEmbeder(client)
+	}
+
 	if cfg.AnthropicKey != "" {
 		client := anthropic.New(cfg.AnthropicKey)
 
@@ -927,7 +1222,7 @@ func setupProxy(cfg Config) (*bellman.Proxy, error) {
 		client := openai.New(cfg.OpenAiKey)
 
 		proxy.RegisterGen(client)
-		proxy.RegisterEmbeder(client)
+		registerEmbeder(client)
 		logger.Info("Start", "action", "[gen] adding provider", "provider", client.Provider())
 		logger.Info("Start", "action", "[embed] adding provider", "provider", client.Provider())
 	}
@@ -944,32 +1239,38 @@ func setupProxy(cfg Config) (*bellman.Proxy, error) {
 		}
 
 		proxy.RegisterGen(client)
-		proxy.RegisterEmbeder(client)
+		registerEmbeder(client)
 		logger.Info("Start", "action", "[gen] adding provider", "provider", client.Provider())
 		logger.Info("Start", "action", "[embed] adding provider", "provider", client.Provider())
 	}
 
 	if cfg.VoyageAiKey != "" {
 		client := voyageai.New(cfg.VoyageAiKey)
-		proxy.RegisterEmbeder(client)
+		registerEmbeder(client)
+		proxy.RegisterReranker(client)
 		logger.Info("Start", "action", "[embed] adding provider", "provider", client.Provider())
+		logger.Info("Start", "action", "[rerank] adding provider", "provider", client.Provider())
 	}
 
 	if cfg.OllamaURL != "" {
 		client := ollama.New(cfg.OllamaURL)
 
 		proxy.RegisterGen(client)
-		proxy.RegisterEmbeder(client)
+		registerEmbeder(client)
 		logger.Info("Start", "action", "[embed] adding provider", "provider", client.Provider())
 	}
 	if len(cfg.VLLMURL) > 0 {
 		if len(cfg.VLLMURL) != len(cfg.VLLMModel) {
 			return nil, fmt.Errorf("vllm-url and vllm-model have to be of same length")
 		}
-		client := vllm.New(cfg.VLLMURL, cfg.VLLMModel)
+		api, err := openai.ParseAPI(cfg.VLLMAPI)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse vllm-api, %w", err)
+		}
+		client := vllm.New(cfg.VLLMURL, cfg.VLLMModel).SetAPI(api)
 
 		proxy.RegisterGen(client)
-		proxy.RegisterEmbeder(client)
+		registerEmbeder(client)
 		logger.Info("Start", "action", "[embed] adding provider", "provider", client.Provider())
 	}
 
@@ -977,7 +1278,7 @@ func setupProxy(cfg Config) (*bellman.Proxy, error) {
 		client := fireworks.New(cfg.FireworksKey)
 
 		proxy.RegisterGen(client)
-		proxy.RegisterEmbeder(client)
+		registerEmbeder(client)
 		logger.Info("Start", "action", "[gen] adding provider", "provider", client.Provider())
 		logger.Info("Start", "action", "[embed] adding provider", "provider", client.Provider())
 	}
@@ -990,10 +1291,14 @@ func setupProxy(cfg Config) (*bellman.Proxy, error) {
 	}
 
 	if cfg.OMLXURL != "" {
-		client := omlx.New(cfg.OMLXURL, cfg.OMLXKey)
+		api, err := openai.ParseAPI(cfg.OMLXAPI)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse omlx-api, %w", err)
+		}
+		client := omlx.New(cfg.OMLXURL, cfg.OMLXKey).SetAPI(api)
 
 		proxy.RegisterGen(client)
-		proxy.RegisterEmbeder(client)
+		registerEmbeder(client)
 		logger.Info("Start", "action", "[gen] adding provider", "provider", client.Provider())
 		logger.Info("Start", "action", "[embed] adding provider", "provider", client.Provider())
 	}
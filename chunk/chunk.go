@@ -0,0 +1,313 @@
+package chunk
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/modfin/bellman/models/embed"
+	"github.com/modfin/bellman/models/gen"
+)
+
+const defaultMaxTokens = 512
+
+// Chunk is a part of a text, with its byte offsets in the text.
+type Chunk struct {
+	Text string `json:"text"`
+	// Start and End are the byte offsets of the chunk in the text, ie. text[Start:End]. For HTML, Text is the text
+	// content of that range, without the tags.
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// Tokens is the estimated number of tokens of Text.
+	Tokens int `json:"tokens"`
+	// Headings are the titles of the sections the chunk starts in, the top level first, for Markdown and HTML.
+	Headings []string `json:"headings,omitempty"`
+}
+
+// Chunker splits texts into chunks of at most MaxTokens, along the structure of the text. The text is broken at
+// its boundaries, eg. headings, paragraphs, lines and sentences, which are merged back into chunks as large as
+// possible, ending at the strongest boundary in the latter half of the chunk. Sentences longer than the max are
+// broken between words, and words between characters.
+//
+// Tokens are estimated with gen.EstimateTextTokens, unless WithTokenizer is used, so keep a margin to the limit of
+// the model.
+type Chunker struct {
+	format    Format
+	maxTokens int
+	overlap   int
+	tokenizer func(string) int
+}
+
+type Option func(c *Chunker)
+
+// WithMaxTokens sets the max number of tokens of a chunk. Default 512.
+func WithMaxTokens(tokens int) Option {
+	return func(c *Chunker) {
+		c.maxTokens = max(tokens, 1)
+	}
+}
+
+// WithOverlap sets the max number of tokens a chunk repeats from the end of the previous chunk. The overlap is made
+// of whole sentences, or smaller parts, so it may be less. Default 0.
+func WithOverlap(tokens int) Option {
+	return func(c *Chunker) {
+		c.overlap = max(tokens, 0)
+	}
+}
+
+// WithFormat sets the format of the text, which decides its boundaries. Default FormatText.
+func WithFormat(format Format) Option {
+	return func(c *Chunker) {
+		c.format = format
+	}
+}
+
+// WithTokenizer sets the function counting the tokens of a text, eg. the tokenizer of the embedding model.
+func WithTokenizer(tokenizer func(text string) int) Option {
+	return func(c *Chunker) {
+		c.tokenizer = tokenizer
+	}
+}
+
+func New(options ...Option) *Chunker {
+	c := &Chunker{
+		format:    FormatText,
+		maxTokens: defaultMaxTokens,
+		tokenizer: gen.EstimateTextTokens,
+	}
+	for _, op := range options {
+		op(c)
+	}
+	return c
+}
+
+// Split splits text into chunks, in order.
+func (c *Chunker) Split(text string) []Chunk {
+	return c.split(text, c.maxTokens)
+}
+
+// DocumentRequest splits text into chunks within the InputMaxTokens of model, and returns a request embedding them
+// as one document, along with the chunks, in the order of the embeddings.
+func (c *Chunker) DocumentRequest(ctx context.Context, model embed.Model, text string) (*embed.DocumentRequest, []Chunk) {
+	chunks := c.split(text, c.limit(model))
+	return embed.NewDocumentRequest(ctx, model, texts(chunks)), chunks
+}
+
+// Request splits text into chunks within the InputMaxTokens of model, and returns a request embedding them one by
+// one, along with the chunks, in the order of the embeddings.
+func (c *Chunker) Request(ctx context.Context, model embed.Model, text string) (*embed.Request, []Chunk) {
+	chunks := c.split(text, c.limit(model))
+	return embed.NewManyRequest(ctx, model, texts(chunks)), chunks
+}
+
+func (c *Chunker) limit(model embed.Model) int {
+	if model.InputMaxTokens > 0 && model.InputMaxTokens < c.maxTokens {
+		return model.InputMaxTokens
+	}
+	return c.maxTokens
+}
+
+func texts(chunks []Chunk) []string {
+	res := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		res[i] = chunk.Text
+	}
+	return res
+}
+
+// piece is text[start:end], preceded by a boundary of strength, lower is stronger.
+type piece struct {
+	start, end int
+	strength   int
+	tokens     int
+}
+
+const (
+	strengthWord = 100 + iota
+	strengthChar
+)
+
+var wordPattern = regexp.MustCompile(`\s+`)
+
+func (c *Chunker) split(text string, maxTokens int) []Chunk {
+	rules := rulesOf(c.format)
+
+	// the strongest boundary at each offset
+	boundaries := map[int]int{}
+	for _, b := range rules.boundaries(text) {
+		if b.offset <= 0 || b.offset >= len(text) {
+			continue
+		}
+		if s, ok := boundaries[b.offset]; !ok || b.strength < s {
+			boundaries[b.offset] = b.strength
+		}
+	}
+	offsets := make([]int, 0, len(boundaries))
+	for offset := range boundaries {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	var pieces []piece
+	start, strength := 0, 0
+	for _, offset := range append(offsets, len(text)) {
+		pieces = append(pieces, c.fit(text, rules, piece{start: start, end: offset, strength: strength}, maxTokens)...)
+		start, strength = offset, boundaries[offset]
+	}
+
+	var chunks []Chunk
+	done := 0 // the pieces before done are in a chunk
+	for i := 0; i < len(pieces); {
+		// as many pieces as fit, counted together rather than added up, since the text of a range may have more
+		// tokens than its pieces, eg. the newlines between html blocks
+		j := i + 1
+		for j < len(pieces) && c.count(text, rules, pieces[i].start, pieces[j].end) <= maxTokens {
+			j++
+		}
+		if j <= done {
+			// the overlap leaves no room for a new piece
+			i = done
+			continue
+		}
+		tokens := 0
+		for _, p := range pieces[i:j] {
+			tokens += p.tokens
+		}
+
+		// ending at the strongest boundary in the latter half, rather than in the middle of a section
+		end := j
+		if j < len(pieces) {
+			best := pieces[j].strength
+			for e := j - 1; e > i; e-- {
+				tokens -= pieces[e].tokens
+				if tokens*2 < maxTokens {
+					break
+				}
+				if pieces[e].strength < best {
+					end, best = e, pieces[e].strength
+				}
+			}
+		}
+
+		if chunk, ok := c.chunk(text, rules, pieces[i].start, pieces[end-1].end); ok {
+			chunks = append(chunks, chunk)
+		}
+		if end == len(pieces) {
+			break
+		}
+		done = end
+
+		// the next chunk starts with the overlap, as long as the first new piece fits with it
+		next, overlap := end, 0
+		for next-1 > i && overlap+pieces[next-1].tokens <= c.overlap {
+			next--
+			overlap += pieces[next].tokens
+		}
+		for next < end && overlap+pieces[end].tokens > maxTokens {
+			overlap -= pieces[next].tokens
+			next++
+		}
+		i = next
+	}
+
+	headings(text, rules, chunks)
+	return chunks
+}
+
+// fit breaks p into words, and words longer than maxTokens between characters, until each piece is within
+// maxTokens.
+func (c *Chunker) fit(text string, rules rules, p piece, maxTokens int) []piece {
+	p.tokens = c.tokenizer(rules.text(text[p.start:p.end]))
+	if p.tokens <= maxTokens {
+		return []piece{p}
+	}
+
+	var res []piece
+	start, strength := p.start, p.strength
+	for _, m := range wordPattern.FindAllStringIndex(text[p.start:p.end], -1) {
+		end := p.start + m[1]
+		if end == p.end || end == start {
+			continue
+		}
+		res = append(res, c.fit(text, rules, piece{start: start, end: end, strength: strength}, maxTokens)...)
+		start, strength = end, strengthWord
+	}
+	if start != p.start {
+		return append(res, c.fit(text, rules, piece{start: start, end: p.end, strength: strength}, maxTokens)...)
+	}
+
+	// a single word, as many characters as fit
+	for i, r := range text[p.start:p.end] {
+		end := p.start + i
+		if end == start || c.tokenizer(rules.text(text[start:end+utf8.RuneLen(r)])) <= maxTokens {
+			continue
+		}
+		res = append(res, piece{start: start, end: end, strength: strength, tokens: c.tokenizer(rules.text(text[start:end]))})
+		start, strength = end, strengthChar
+	}
+	if start == p.start {
+		return []piece{p}
+	}
+	return append(res, piece{start: start, end: p.end, strength: strength, tokens: c.tokenizer(rules.text(text[start:p.end]))})
+}
+
+// chunk returns the chunk of text[start:end], without surrounding whitespace, or false if it is empty.
+func (c *Chunker) chunk(text string, rules rules, start int, end int) (Chunk, bool) {
+	for start < end {
+		r, size := utf8.DecodeRuneInString(text[start:end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		start += size
+	}
+	for end > start {
+		r, size := utf8.DecodeLastRuneInString(text[start:end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		end -= size
+	}
+	content := rules.text(text[start:end])
+	if strings.TrimSpace(content) == "" {
+		return Chunk{}, false
+	}
+	return Chunk{
+		Text:   content,
+		Start:  start,
+		End:    end,
+		Tokens: c.tokenizer(content),
+	}, true
+}
+
+// count returns the tokens of the chunk of text[start:end].
+func (c *Chunker) count(text string, rules rules, start int, end int) int {
+	chunk, _ := c.chunk(text, rules, start, end)
+	return chunk.Tokens
+}
+
+// headings sets the Headings of the chunks, the sections each chunk starts in.
+func headings(text string, rules rules, chunks []Chunk) {
+	found := rules.headings(text)
+	if len(found) == 0 {
+		return
+	}
+	var path [6]string
+	k := 0
+	for i := range chunks {
+		for k < len(found) && found[k].offset <= chunks[i].Start {
+			path[found[k].level-1] = found[k].title
+			for l := found[k].level; l < len(path); l++ {
+				path[l] = ""
+			}
+			k++
+		}
+		for _, title := range path {
+			if title != "" {
+				chunks[i].Headings = append(chunks[i].Headings, title)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package chunk
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/modfin/bellman/models/embed"
+)
+
+// words counts tokens as words, to keep the expectations readable
+func words(text string) int {
+	return len(strings.Fields(text))
+}
+
+func TestSplitText(t *testing.T) {
+	// the first chunk ends at the paragraph, rather than after "Seven eight."
+	text := "One two three. Four five six.\n\nSeven eight. Nine ten eleven twelve."
+	chunks := New(WithMaxTokens(8), WithTokenizer(words)).Split(text)
+
+	expected := []string{
+		"One two three. Four five six.",
+		"Seven eight. Nine ten eleven twelve.",
+	}
+	if len(chunks) != len(expected) {
+		t.Fatalf("expected %d chunks, got %d, %+v", len(expected), len(chunks), chunks)
+	}
+	for i, chunk := range chunks {
+		if chunk.Text != expected[i] {
+			t.Errorf("chunk %d, expected %q, got %q", i, expected[i], chunk.Text)
+		}
+		if text[chunk.Start:chunk.End] != chunk.Text {
+			t.Errorf("chunk %d, offsets %d:%d do not match the text", i, chunk.Start, chunk.End)
+		}
+		if chunk.Tokens > 8 {
+			t.Errorf("chunk %d, %d tokens is over the max", i, chunk.Tokens)
+		}
+	}
+}
+
+func TestSplitLongSentence(t *testing.T) {
+	text := "a b c d e f g h i j"
+	chunks := New(WithMaxTokens(4), WithOverlap(1), WithTokenizer(words)).Split(text)
+
+	expected := []string{"a b c d", "d e f g", "g h i j"}
+	if len(chunks) != len(expected) {
+		t.Fatalf("expected %d chunks, got %+v", len(expected), chunks)
+	}
+	for i, chunk := range chunks {
+		if chunk.Text != expected[i] {
+			t.Errorf("chunk %d, expected %q, got %q", i, expected[i], chunk.Text)
+		}
+	}
+}
+
+func TestSplitMarkdown(t *testing.T) {
+	text := `# Guide
+
+Intro text here.
+
+## Install
+
+Run the installer now.
+
+` + "```sh\n# not a heading. Really.\nmake install\n```" + `
+
+## Usage
+
+Use it well.`
+
+	chunks := New(WithFormat(FormatMarkdown), WithMaxTokens(10), WithTokenizer(words)).Split(text)
+	if len(chunks) != 4 {
+		t.Fatalf("expected 4 chunks, got %d, %+v", len(chunks), chunks)
+	}
+	if chunks[1].Text != "## Install\n\nRun the installer now." {
+		t.Errorf("expected the install section, got %q", chunks[1].Text)
+	}
+	if !strings.HasPrefix(chunks[2].Text, "```sh") || !strings.HasSuffix(chunks[2].Text, "```") {
+		t.Errorf("expected the code block, got %q", chunks[2].Text)
+	}
+	if got := strings.Join(chunks[2].Headings, " > "); got != "Guide > Install" {
+		t.Errorf("expected headings Guide > Install, got %q", got)
+	}
+	if got := strings.Join(chunks[3].Headings, " > "); got != "Guide > Usage" {
+		t.Errorf("expected headings Guide > Usage, got %q", got)
+	}
+}
+
+func TestSplitHTML(t *testing.T) {
+	text := `<html><head><style>p { color: red; }</style></head><body>
+<h1>Title</h1><p>First &amp; foremost.</p>
+<h2>Part</h2><p>Second paragraph, <b>bold</b> words.</p><script>var x = "<p>";</script>
+</body></html>`
+
+	chunks := New(WithFormat(FormatHTML), WithMaxTokens(5), WithTokenizer(words)).Split(text)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d, %+v", len(chunks), chunks)
+	}
+	if chunks[0].Text != "Title\nFirst & foremost." {
+		t.Errorf("unexpected text %q", chunks[0].Text)
+	}
+	if chunks[1].Text != "Part\nSecond paragraph, bold words." {
+		t.Errorf("unexpected text %q", chunks[1].Text)
+	}
+	if !strings.HasPrefix(text[chunks[1].Start:], "<h2>") {
+		t.Errorf("expected the second chunk to start at <h2>, got %q", text[chunks[1].Start:])
+	}
+	if got := strings.Join(chunks[1].Headings, " > "); got != "Title > Part" {
+		t.Errorf("expected headings Title > Part, got %q", got)
+	}
+}
+
+func TestSplitHTMLMaxTokens(t *testing.T) {
+	// characters count the newlines that join html blocks, which words do not
+	characters := func(text string) int { return len(text) }
+	text := "<h1>T</h1><p>Hello there, a short paragraph.</p><div>" + strings.Repeat("lorem ipsum ", 20) + "</div>"
+
+	for _, maxTokens := range []int{16, 32, 128} {
+		chunks := New(WithFormat(FormatHTML), WithMaxTokens(maxTokens), WithOverlap(4), WithTokenizer(characters)).Split(text)
+		if len(chunks) == 0 {
+			t.Fatalf("expected chunks for max %d", maxTokens)
+		}
+		for _, chunk := range chunks {
+			if chunk.Tokens > maxTokens {
+				t.Errorf("expected at most %d tokens, got %d in %q", maxTokens, chunk.Tokens, chunk.Text)
+			}
+		}
+	}
+}
+
+func TestDocumentRequest(t *testing.T) {
+	model := embed.Model{Provider: "test", Name: "test", InputMaxTokens: 3}
+	req, chunks := New(WithTokenizer(words)).DocumentRequest(context.Background(), model, "a b c. d e f. g")
+
+	if len(chunks) != 3 || len(req.DocumentChunks) != 3 {
+		t.Fatalf("expected 3 chunks within the max tokens of the model, got %+v", chunks)
+	}
+	if req.DocumentChunks[1] != "d e f." || req.Model.Name != "test" {
+		t.Fatalf("unexpected request %+v", req)
+	}
+}
+
+func TestSplitLongWord(t *testing.T) {
+	text := strings.Repeat("å", 10)
+	chunks := New(WithMaxTokens(3), WithTokenizer(func(text string) int { return len([]rune(text)) })).Split(text)
+
+	if len(chunks) != 4 || chunks[0].Text != "ååå" || chunks[3].Text != "å" {
+		t.Fatalf("expected the word broken into 4 chunks, got %+v", chunks)
+	}
+}
@@ -0,0 +1,269 @@
+package chunk
+
+import (
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type Format string
+
+const (
+	// FormatText is plain text, broken at paragraphs, lines and sentences.
+	FormatText Format = "text"
+	// FormatMarkdown is also broken at headings and code blocks, and headings inside code blocks are ignored.
+	FormatMarkdown Format = "markdown"
+	// FormatHTML is broken at headings, sections and block elements, and chunked by its text content.
+	FormatHTML Format = "html"
+)
+
+// strengths of the boundaries, lower is stronger. Headings are 1 to 6, by level.
+const (
+	strengthSection = 7 + iota
+	strengthBlock
+	strengthParagraph
+	strengthLine
+	strengthSentence
+)
+
+type boundary struct {
+	offset   int
+	strength int
+}
+
+type heading struct {
+	offset int
+	level  int
+	title  string
+}
+
+type rules struct {
+	boundaries func(text string) []boundary
+	headings   func(text string) []heading
+	text       func(text string) string
+}
+
+func rulesOf(format Format) rules {
+	switch format {
+	case FormatMarkdown:
+		return rules{boundaries: markdownBoundaries, headings: markdownHeadings, text: plain}
+	case FormatHTML:
+		return rules{boundaries: htmlBoundaries, headings: htmlHeadings, text: htmlText}
+	}
+	return rules{boundaries: textBoundaries, headings: func(string) []heading { return nil }, text: plain}
+}
+
+func plain(text string) string {
+	return text
+}
+
+var (
+	paragraphPattern = regexp.MustCompile(`\n[ \t]*\n\s*`)
+	linePattern      = regexp.MustCompile(`\n\s*`)
+	sentencePattern  = regexp.MustCompile(`[.!?。！？]+["'”’)\]]*\s+`)
+)
+
+// after returns boundaries after each match of pattern.
+func after(text string, pattern *regexp.Regexp, strength int) []boundary {
+	var res []boundary
+	for _, m := range pattern.FindAllStringIndex(text, -1) {
+		res = append(res, boundary{offset: m[1], strength: strength})
+	}
+	return res
+}
+
+// before returns boundaries before each match of pattern.
+func before(text string, pattern *regexp.Regexp, strength int) []boundary {
+	var res []boundary
+	for _, m := range pattern.FindAllStringIndex(text, -1) {
+		res = append(res, boundary{offset: m[0], strength: strength})
+	}
+	return res
+}
+
+func textBoundaries(text string) []boundary {
+	res := after(text, paragraphPattern, strengthParagraph)
+	res = append(res, after(text, linePattern, strengthLine)...)
+	return append(res, after(text, sentencePattern, strengthSentence)...)
+}
+
+var (
+	markdownHeadingPattern = regexp.MustCompile(`(?m)^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+	markdownFencePattern   = regexp.MustCompile("(?m)^[ \t]*(```|~~~)")
+)
+
+// fences returns the ranges of the fenced code blocks of text, including the fences.
+func fences(text string) [][2]int {
+	var res [][2]int
+	open := -1
+	var marker string
+	for _, m := range markdownFencePattern.FindAllStringSubmatchIndex(text, -1) {
+		fence := text[m[2]:m[3]]
+		switch {
+		case open < 0:
+			open, marker = m[0], fence
+		case fence == marker:
+			end := strings.IndexByte(text[m[1]:], '\n')
+			if end < 0 {
+				end = len(text) - m[1]
+			}
+			res = append(res, [2]int{open, m[1] + end})
+			open = -1
+		}
+	}
+	if open >= 0 {
+		res = append(res, [2]int{open, len(text)})
+	}
+	return res
+}
+
+func inside(offset int, ranges [][2]int) bool {
+	for _, r := range ranges {
+		if offset > r[0] && offset < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func markdownBoundaries(text string) []boundary {
+	code := fences(text)
+
+	var res []boundary
+	for _, h := range markdownHeadings(text) {
+		res = append(res, boundary{offset: h.offset, strength: h.level})
+	}
+	for _, r := range code {
+		res = append(res, boundary{offset: r[0], strength: strengthBlock}, boundary{offset: r[1], strength: strengthBlock})
+	}
+	for _, b := range textBoundaries(text) {
+		// code is broken at lines, but not at what looks like sentences
+		if b.strength == strengthSentence && inside(b.offset, code) {
+			continue
+		}
+		res = append(res, b)
+	}
+	return res
+}
+
+func markdownHeadings(text string) []heading {
+	code := fences(text)
+
+	var res []heading
+	for _, m := range markdownHeadingPattern.FindAllStringSubmatchIndex(text, -1) {
+		if inside(m[0], code) {
+			continue
+		}
+		res = append(res, heading{
+			offset: m[0],
+			level:  m[3] - m[2],
+			title:  text[m[4]:m[5]],
+		})
+	}
+	return res
+}
+
+var (
+	htmlHeadingPattern = regexp.MustCompile(`(?is)<h([1-6])\b[^>]*>(.*?)</h[1-6]\s*>`)
+	htmlSectionPattern = regexp.MustCompile(`(?i)<(section|article|header|footer|main|nav|aside)\b`)
+	htmlBlockPattern   = regexp.MustCompile(`(?i)<(p|div|ul|ol|dl|table|pre|blockquote|figure)\b`)
+	htmlLinePattern    = regexp.MustCompile(`(?i)<(li|tr|dt|dd|br)\b`)
+	htmlRawPattern     = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>`)
+)
+
+func htmlBoundaries(text string) []boundary {
+	var res []boundary
+	for _, h := range htmlHeadings(text) {
+		res = append(res, boundary{offset: h.offset, strength: h.level})
+	}
+	res = append(res, before(text, htmlSectionPattern, strengthSection)...)
+	res = append(res, before(text, htmlBlockPattern, strengthBlock)...)
+	res = append(res, before(text, htmlLinePattern, strengthLine)...)
+	res = append(res, after(text, sentencePattern, strengthSentence)...)
+
+	// scripts and styles are not text, and left whole
+	raw := htmlRawPattern.FindAllStringIndex(text, -1)
+	return slices.DeleteFunc(res, func(b boundary) bool {
+		return slices.ContainsFunc(raw, func(r []int) bool { return b.offset > r[0] && b.offset < r[1] })
+	})
+}
+
+func htmlHeadings(text string) []heading {
+	var res []heading
+	for _, m := range htmlHeadingPattern.FindAllStringSubmatchIndex(text, -1) {
+		res = append(res, heading{
+			offset: m[0],
+			level:  int(text[m[2]] - '0'),
+			title:  htmlText(text[m[4]:m[5]]),
+		})
+	}
+	return res
+}
+
+var htmlRawEndPattern = map[string]*regexp.Regexp{
+	"script": regexp.MustCompile(`(?i)</script`),
+	"style":  regexp.MustCompile(`(?i)</style`),
+}
+
+var htmlBreakTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true, "div": true,
+	"dl": true, "dt": true, "figcaption": true, "figure": true, "footer": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// htmlText returns the text content of an html fragment, one line per block element, without scripts, styles and
+// comments.
+func htmlText(text string) string {
+	var b strings.Builder
+	for len(text) > 0 {
+		i := strings.IndexByte(text, '<')
+		if i < 0 {
+			b.WriteString(text)
+			break
+		}
+		b.WriteString(text[:i])
+		text = text[i:]
+
+		if strings.HasPrefix(text, "<!--") {
+			end := strings.Index(text, "-->")
+			if end < 0 {
+				break
+			}
+			text = text[end+3:]
+			continue
+		}
+
+		end := strings.IndexByte(text, '>')
+		if end < 0 {
+			break
+		}
+		closing := strings.HasPrefix(text, "</")
+		name := strings.ToLower(strings.TrimLeft(text[1:end], "/"))
+		if k := strings.IndexAny(name, " \t\n\r/"); k >= 0 {
+			name = name[:k]
+		}
+		text = text[end+1:]
+
+		if (name == "script" || name == "style") && !closing {
+			m := htmlRawEndPattern[name].FindStringIndex(text)
+			if m == nil {
+				break
+			}
+			text = text[m[0]:]
+			continue
+		}
+		if htmlBreakTags[name] {
+			b.WriteString("\n")
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(html.UnescapeString(b.String()), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/modfin/bellman/models/embed"
 	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/models/rerank"
 	"github.com/modfin/bellman/prompt"
 	"github.com/modfin/bellman/tools"
 )
@@ -158,7 +159,7 @@ func (v *Bellman) Embed(request *embed.Request) (*embed.Response, error) {
 		return nil, fmt.Errorf("could not read bellman response; %w", err)
 	}
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code %d; %s", res.StatusCode, string(body))
+		return nil, &embed.StatusError{StatusCode: res.StatusCode, Message: string(body)}
 	}
 
 	var response embed.Response
@@ -206,7 +207,7 @@ func (v *Bellman) EmbedDocument(request *embed.DocumentRequest) (*embed.Document
 		return nil, fmt.Errorf("could not read bellman response; %w", err)
 	}
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code %d; %s", res.StatusCode, string(body))
+		return nil, &embed.StatusError{StatusCode: res.StatusCode, Message: string(body)}
 	}
 
 	var response embed.DocumentResponse
@@ -221,6 +222,55 @@ func (v *Bellman) EmbedDocument(request *embed.DocumentRequest) (*embed.Document
 	return &response, nil
 }
 
+func (v *Bellman) Rerank(request *rerank.Request) (*rerank.Response, error) {
+	var reqc = atomic.AddInt64(&bellmanRequestNo, 1)
+
+	u, err := url.JoinPath(v.url, "rerank")
+	if err != nil {
+		return nil, fmt.Errorf("could not join url %s; %w", v.url, err)
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal bellman request; %w", err)
+	}
+
+	ctx := request.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not create bellman request; %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+v.key.String())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not post bellman request to %s; %w", u, err)
+	}
+	defer res.Body.Close()
+
+	body, err = io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bellman response; %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d; %s", res.StatusCode, string(body))
+	}
+
+	var response rerank.Response
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		v.log("[rerank] unmarshal response error", "error", err, "body", string(body))
+		return nil, fmt.Errorf("could not unmarshal bellman response; %w", err)
+	}
+
+	v.log("[rerank] response", "request", reqc, "model", request.Model.FQN(), "token-total", response.Metadata.TotalTokens)
+
+	return &response, nil
+}
+
 func (a *Bellman) Generator(options ...gen.Option) *gen.Generator {
 	var gen = &gen.Generator{
 		Prompter: &generator{
@@ -335,6 +385,68 @@ func (g *generator) Prompt(conversation ...prompt.Prompt) (*gen.Response, error)
 
 }
 
+// CountTokens counts the input tokens of the request with bellmand, implementing gen.TokenCounter
+func (g *generator) CountTokens(conversation ...prompt.Prompt) (*gen.TokenCount, error) {
+	var reqc = atomic.AddInt64(&bellmanRequestNo, 1)
+
+	u, err := url.JoinPath(g.bellman.url, "gen", "tokens")
+	if err != nil {
+		return nil, fmt.Errorf("could not join url %s; %w", g.bellman.url, err)
+	}
+	request := gen.FullRequest{
+		Request: g.request,
+		Prompts: conversation,
+	}
+
+	g.bellman.log("[gen] count tokens request",
+		"request", reqc,
+		"model", g.request.Model.FQN(),
+	)
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal bellman request; %w", err)
+	}
+
+	ctx := g.request.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not create bellman request; %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+g.bellman.key.String())
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not post bellman request to %s; %w", u, err)
+	}
+	defer res.Body.Close()
+
+	body, err = io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bellman response; %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d; %s", res.StatusCode, string(body))
+	}
+	var count gen.TokenCount
+	err = json.Unmarshal(body, &count)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal bellman response; %w", err)
+	}
+
+	g.bellman.log("[gen] count tokens response",
+		"request", reqc,
+		"model", g.request.Model.FQN(),
+		"token-input", count.InputTokens,
+	)
+	return &count, nil
+}
+
 func (g *generator) Stream(conversation ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
 	var reqc = atomic.AddInt64(&bellmanRequestNo, 1)
 
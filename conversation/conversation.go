@@ -0,0 +1,86 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+)
+
+// Compactor shrinks a conversation that no longer fits the context window of the model. A compacted conversation
+// must still be valid for every provider, ie. tool calls keep their tool responses, and the latest turn is kept as
+// is, including its thinking blocks.
+type Compactor interface {
+	Compact(ctx context.Context, prompts []prompt.Prompt) ([]prompt.Prompt, error)
+}
+
+// CompactorFunc lets an ordinary function be used as a Compactor.
+type CompactorFunc func(ctx context.Context, prompts []prompt.Prompt) ([]prompt.Prompt, error)
+
+func (f CompactorFunc) Compact(ctx context.Context, prompts []prompt.Prompt) ([]prompt.Prompt, error) {
+	return f(ctx, prompts)
+}
+
+// Estimate returns an offline estimate of the number of tokens in prompts, see gen.EstimateTokens.
+func Estimate(prompts []prompt.Prompt) int {
+	return gen.EstimateTokens(gen.Request{}, prompts...)
+}
+
+// Conversation keeps the prompts of a multi-turn chat, and compacts them before they grow past MaxTokens.
+type Conversation struct {
+	Prompts   []prompt.Prompt
+	Compactor Compactor
+	MaxTokens int // defaults to the InputMaxToken of the model
+}
+
+func New(compactor Compactor, maxTokens int) *Conversation {
+	return &Conversation{
+		Compactor: compactor,
+		MaxTokens: maxTokens,
+	}
+}
+
+// Append adds prompts to the conversation.
+func (c *Conversation) Append(prompts ...prompt.Prompt) {
+	c.Prompts = append(c.Prompts, prompts...)
+}
+
+// Compact compacts the conversation if it is estimated to use more than maxTokens.
+func (c *Conversation) Compact(ctx context.Context, maxTokens int) error {
+	if c.Compactor == nil || maxTokens <= 0 || Estimate(c.Prompts) <= maxTokens {
+		return nil
+	}
+	prompts, err := c.Compactor.Compact(ctx, c.Prompts)
+	if err != nil {
+		return fmt.Errorf("could not compact conversation, %w", err)
+	}
+	c.Prompts = prompts
+	return nil
+}
+
+// Prompt appends prompts, compacts the conversation if needed and prompts g with it. The replay-ready turn of the
+// response is appended to the conversation.
+func (c *Conversation) Prompt(g *gen.Generator, prompts ...prompt.Prompt) (*gen.Response, error) {
+	c.Append(prompts...)
+
+	ctx := g.Request.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	maxTokens := c.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = g.Request.Model.InputMaxToken
+	}
+	err := c.Compact(ctx, maxTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.Prompt(c.Prompts...)
+	if err != nil {
+		return nil, err
+	}
+	c.Append(resp.Turn...)
+	return resp, nil
+}
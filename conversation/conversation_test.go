@@ -0,0 +1,132 @@
+package conversation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+)
+
+type summarizer struct {
+	seen []prompt.Prompt
+}
+
+func (s *summarizer) SetRequest(request gen.Request) {}
+func (s *summarizer) Prompt(prompts ...prompt.Prompt) (*gen.Response, error) {
+	s.seen = prompts
+	return &gen.Response{Texts: []string{"they looked up the weather"}}, nil
+}
+func (s *summarizer) Stream(prompts ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
+	return nil, nil
+}
+
+// agentRun is a task followed by three turns, each with thinking and two tool calls
+func agentRun() []prompt.Prompt {
+	prompts := []prompt.Prompt{prompt.AsUser("what is the weather?")}
+	for _, id := range []string{"a", "b", "c"} {
+		prompts = append(prompts,
+			prompt.AsThinking("hmm", []byte("sig-"+id), ""),
+			prompt.AsToolCall(id+"1", "weather", []byte(`{}`)),
+			prompt.AsToolCall(id+"2", "weather", []byte(`{}`)),
+			prompt.AsToolResponse(id+"1", "weather", strings.Repeat("sunny ", 20)),
+			prompt.AsToolResponse(id+"2", "weather", strings.Repeat("rainy ", 20)),
+		)
+	}
+	return prompts
+}
+
+// assertValid checks that every tool call has a response, and that the latest turn is untouched
+func assertValid(t *testing.T, prompts []prompt.Prompt) {
+	t.Helper()
+	calls := map[string]bool{}
+	for _, p := range prompts {
+		switch p.Role {
+		case prompt.ToolCallRole:
+			calls[p.ToolCall.ToolCallID] = true
+		case prompt.ToolResponseRole:
+			if !calls[p.ToolResponse.ToolCallID] {
+				t.Fatalf("tool response %s without tool call", p.ToolResponse.ToolCallID)
+			}
+			delete(calls, p.ToolResponse.ToolCallID)
+		}
+	}
+	if len(calls) > 0 {
+		t.Fatalf("tool calls without response: %v", calls)
+	}
+	if prompts[0].Role != prompt.UserRole {
+		t.Fatalf("expected the conversation to start with the user, got %s", prompts[0].Role)
+	}
+	latest := agentRun()[11:]
+	tail := prompts[len(prompts)-len(latest):]
+	for i := range latest {
+		if tail[i].Role != latest[i].Role || string(tail[i].Replay) != string(latest[i].Replay) ||
+			(latest[i].ToolResponse != nil && tail[i].ToolResponse.Response != latest[i].ToolResponse.Response) {
+			t.Fatalf("expected the latest turn to be kept as is, got %+v", tail[i])
+		}
+	}
+}
+
+func TestDropToolOutputs(t *testing.T) {
+	prompts, err := DropToolOutputs(1).Compact(context.Background(), agentRun())
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	assertValid(t, prompts)
+	if len(prompts) != 16 || prompts[4].ToolResponse.Response != removedToolOutput {
+		t.Fatalf("expected old tool outputs to be replaced, got %+v", prompts[4].ToolResponse)
+	}
+	if Estimate(prompts) >= Estimate(agentRun()) {
+		t.Fatalf("expected the conversation to shrink")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	prompts, err := SlidingWindow(2).Compact(context.Background(), agentRun())
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	assertValid(t, prompts)
+	if len(prompts) != 11 || prompts[2].ToolCall.ToolCallID != "b1" {
+		t.Fatalf("expected the task and the two latest turns, got %d prompts", len(prompts))
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	s := &summarizer{}
+	c := Summarize(&gen.Generator{Prompter: s}, 1)
+	prompts, err := c.Compact(context.Background(), agentRun())
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	assertValid(t, prompts)
+	if len(prompts) != 7 || prompts[1].Text != summaryPrefix+"they looked up the weather" {
+		t.Fatalf("expected the task, a summary and the latest turn, got %+v", prompts[:2])
+	}
+	if !strings.Contains(s.seen[0].Text, "weather returned: sunny") {
+		t.Fatalf("expected the tool outputs in the transcript, got %q", s.seen[0].Text)
+	}
+
+	// a second summary replaces the first one
+	prompts = append(prompts, agentRun()[1:]...)
+	prompts, err = c.Compact(context.Background(), prompts)
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if len(prompts) != 7 || !strings.Contains(s.seen[0].Text, "user: they looked up the weather") {
+		t.Fatalf("expected the previous summary to be summarized again, got %d prompts", len(prompts))
+	}
+}
+
+func TestConversationPrompt(t *testing.T) {
+	c := New(SlidingWindow(1), 100)
+	c.Append(agentRun()...)
+	_, err := c.Prompt(&gen.Generator{Prompter: &summarizer{}})
+	if err != nil {
+		t.Fatalf("Prompt() error = %v", err)
+	}
+	if len(c.Prompts) != 6 {
+		t.Fatalf("expected the conversation to be compacted before prompting, got %d prompts", len(c.Prompts))
+	}
+}
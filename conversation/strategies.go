@@ -0,0 +1,150 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+)
+
+const removedToolOutput = "[tool output removed to save context]"
+const summaryPrefix = "Summary of the earlier conversation:\n"
+
+// split returns the leading user prompts of a conversation, eg. the task, and the turns after them. A turn starts
+// with the prompts of an assistant response, ie. thinking, text and tool calls, and holds the tool responses and
+// user prompts that follows it. Dropping, or keeping, whole turns keeps tool calls paired with their responses.
+func split(prompts []prompt.Prompt) (head []prompt.Prompt, turns [][]prompt.Prompt) {
+	i := 0
+	for i < len(prompts) && prompts[i].Role == prompt.UserRole {
+		i++
+	}
+	head = prompts[:i]
+
+	assistant := false
+	for _, p := range prompts[i:] {
+		isAssistant := p.Role == prompt.AssistantRole || p.Role == prompt.ThinkingRole || p.Role == prompt.ToolCallRole
+		if len(turns) == 0 || (isAssistant && !assistant) {
+			turns = append(turns, nil)
+		}
+		turns[len(turns)-1] = append(turns[len(turns)-1], p)
+		assistant = isAssistant
+	}
+	return head, turns
+}
+
+func join(head []prompt.Prompt, turns ...[]prompt.Prompt) []prompt.Prompt {
+	res := append([]prompt.Prompt{}, head...)
+	for _, turn := range turns {
+		res = append(res, turn...)
+	}
+	return res
+}
+
+// DropToolOutputs replaces the tool responses of all but the latest keep turns with a short placeholder. The tool
+// calls are left as is, so the llm still knows what it has done.
+func DropToolOutputs(keep int) Compactor {
+	return CompactorFunc(func(ctx context.Context, prompts []prompt.Prompt) ([]prompt.Prompt, error) {
+		head, turns := split(prompts)
+		for i := 0; i < len(turns)-max(keep, 1); i++ {
+			turn := append([]prompt.Prompt{}, turns[i]...)
+			for j, p := range turn {
+				if p.ToolResponse == nil || len(p.ToolResponse.Response) <= len(removedToolOutput) {
+					continue
+				}
+				resp := *p.ToolResponse
+				resp.Response = removedToolOutput
+				turn[j].ToolResponse = &resp
+			}
+			turns[i] = turn
+		}
+		return join(head, turns...), nil
+	})
+}
+
+// SlidingWindow keeps the leading user prompts, eg. the task, and the latest turns, dropping everything in between.
+func SlidingWindow(turns int) Compactor {
+	return CompactorFunc(func(ctx context.Context, prompts []prompt.Prompt) ([]prompt.Prompt, error) {
+		head, all := split(prompts)
+		n := max(turns, 1)
+		if len(all) <= n {
+			return prompts, nil
+		}
+		return join(head, all[len(all)-n:]...), nil
+	})
+}
+
+// Summarize replaces all but the latest keep turns with a summary, written by g. g is preferably a cheaper model
+// than the one holding the conversation. A previous summary is included in the next one.
+func Summarize(g *gen.Generator, keep int) Compactor {
+	return CompactorFunc(func(ctx context.Context, prompts []prompt.Prompt) ([]prompt.Prompt, error) {
+		head, turns := split(prompts)
+		n := max(keep, 1)
+		if len(turns) <= n {
+			return prompts, nil
+		}
+
+		var kept, old []prompt.Prompt
+		for _, p := range head {
+			if strings.HasPrefix(p.Text, summaryPrefix) {
+				old = append(old, p)
+				continue
+			}
+			kept = append(kept, p)
+		}
+		old = join(old, turns[:len(turns)-n]...)
+
+		resp, err := g.WithContext(ctx).
+			System("You summarize conversations between a user, an assistant and its tools. Keep every fact, decision " +
+				"and tool result that is needed to continue the conversation. Answer with the summary only.").
+			Prompt(prompt.AsUser(transcript(old)))
+		if err != nil {
+			return nil, fmt.Errorf("could not summarize conversation, %w", err)
+		}
+		summary, err := resp.AsText()
+		if err != nil {
+			return nil, fmt.Errorf("could not get summary, %w", err)
+		}
+
+		kept = append(kept, prompt.AsUser(summaryPrefix+summary))
+		return join(kept, turns[len(turns)-n:]...), nil
+	})
+}
+
+// Chain applies compactors in order, eg. dropping tool outputs before summarizing.
+func Chain(compactors ...Compactor) Compactor {
+	return CompactorFunc(func(ctx context.Context, prompts []prompt.Prompt) ([]prompt.Prompt, error) {
+		var err error
+		for _, c := range compactors {
+			prompts, err = c.Compact(ctx, prompts)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return prompts, nil
+	})
+}
+
+// transcript renders prompts as plain text, thinking is left out.
+func transcript(prompts []prompt.Prompt) string {
+	var sb strings.Builder
+	for _, p := range prompts {
+		switch p.Role {
+		case prompt.UserRole:
+			if p.Text != "" {
+				sb.WriteString("user: " + strings.TrimPrefix(p.Text, summaryPrefix) + "\n")
+			}
+			if p.Payload != nil {
+				sb.WriteString("user: [attachment " + p.Payload.Mime + "]\n")
+			}
+		case prompt.AssistantRole:
+			sb.WriteString("assistant: " + p.Text + "\n")
+		case prompt.ToolCallRole:
+			sb.WriteString(fmt.Sprintf("assistant called %s(%s)\n", p.ToolCall.Name, p.ToolCall.Arguments))
+		case prompt.ToolResponseRole:
+			sb.WriteString(fmt.Sprintf("%s returned: %s\n", p.ToolResponse.Name, p.ToolResponse.Response))
+		}
+	}
+	return sb.String()
+}
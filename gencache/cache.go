@@ -0,0 +1,266 @@
+package gencache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/modfin/bellman/models/embed"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+)
+
+// semanticLimit is the max number of prompts kept for semantic lookups, the oldest are dropped over it.
+const semanticLimit = 10_000
+
+// Cache caches the responses of a gen.Generator by a hash of the request, see Key. Prompts and streams are cached
+// separately, and a cached stream is replayed as the events of the original stream. Hits are reported in
+// Metadata.Other, as cache_hit, and for semantic hits, cache_similarity.
+//
+// Failed requests, and streams ending in an error, are not cached.
+type Cache struct {
+	store Store
+	ttl   time.Duration
+
+	embeder   embed.Embeder
+	model     embed.Model
+	threshold float64
+
+	mu       sync.Mutex
+	semantic []semanticEntry
+}
+
+type semanticEntry struct {
+	scope   string // the key of the request without the text of the last prompt
+	key     string
+	vector  []float64
+	expires time.Time
+}
+
+type Option func(c *Cache)
+
+// WithTTL sets how long responses are cached. Default 0, which is until evicted by the store.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.ttl = ttl
+	}
+}
+
+// WithSemantic makes requests that miss the cache hit a cached request that differs only in the text of the last
+// prompt, if the embeddings of the texts, by model, have a cosine similarity of at least threshold, eg. 0.95.
+// The last prompt has to be a text only user prompt.
+func WithSemantic(embeder embed.Embeder, model embed.Model, threshold float64) Option {
+	return func(c *Cache) {
+		c.embeder = embeder
+		c.model = model
+		c.threshold = threshold
+	}
+}
+
+func New(store Store, options ...Option) *Cache {
+	c := &Cache{store: store}
+	for _, op := range options {
+		op(c)
+	}
+	return c
+}
+
+// Wrap returns a copy of generator that prompts and streams through the cache.
+func (c *Cache) Wrap(generator *gen.Generator) *gen.Generator {
+	return c.WrapNamespace(generator, "")
+}
+
+// WrapNamespace is like Wrap, but responses are only shared between generators wrapped with the same namespace, eg.
+// the id of the api key of the caller when the cache is shared by many callers.
+func (c *Cache) WrapNamespace(generator *gen.Generator, namespace string) *gen.Generator {
+	g := *generator
+	g.Prompter = &prompter{cache: c, prompter: generator.Prompter, namespace: namespace}
+	return &g
+}
+
+// Key returns the cache key of request and prompts, as the model fqn and a sha256 of the request as json. The model,
+// system prompt, prompts, tools, output schema, constraints and sampling params are part of the key, but not the
+// context or description of the model.
+func Key(request gen.Request, prompts ...prompt.Prompt) (string, error) {
+	request.Context = nil
+	request.ValidateCapabilities = false
+	request.Model.Description = ""
+	b, err := json.Marshal(struct {
+		Request gen.Request     `json:"request"`
+		Prompts []prompt.Prompt `json:"prompts"`
+	}{request, prompts})
+	if err != nil {
+		return "", fmt.Errorf("could not marshal request, %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return request.Model.FQN() + "|" + hex.EncodeToString(sum[:]), nil
+}
+
+// query is a request to look up, and save, in the cache. The scope and embedding of the last prompt are kept, so
+// that a miss is embedded once, by lookup, and not again by save.
+type query struct {
+	namespace string
+	key       string
+	request   gen.Request
+	prompts   []prompt.Prompt
+
+	embedded bool
+	scope    string
+	vector   []float64
+}
+
+func newQuery(namespace string, request gen.Request, prompts []prompt.Prompt) (*query, error) {
+	key, err := namespaced(namespace, request, prompts)
+	if err != nil {
+		return nil, err
+	}
+	return &query{namespace: namespace, key: key, request: request, prompts: prompts}, nil
+}
+
+// namespaced returns the Key of request and prompts, prefixed by namespace if set.
+func namespaced(namespace string, request gen.Request, prompts []prompt.Prompt) (string, error) {
+	key, err := Key(request, prompts...)
+	if err != nil || namespace == "" {
+		return key, err
+	}
+	return namespace + "|" + key, nil
+}
+
+// lookup returns the cached value of q, or of a semantically similar request, and its similarity. The similarity
+// is nil if the value is found by key.
+func (c *Cache) lookup(q *query) ([]byte, *float64, bool) {
+	value, ok := c.store.Get(q.key)
+	if ok || c.embeder == nil {
+		return value, nil, ok
+	}
+	scope, vector, ok := c.embed(q)
+	if !ok {
+		return nil, nil, false
+	}
+
+	c.mu.Lock()
+	var best *semanticEntry
+	var similarity float64
+	now := time.Now()
+	for i, e := range c.semantic {
+		if e.scope != scope || (!e.expires.IsZero() && now.After(e.expires)) {
+			continue
+		}
+		s := cosine(vector, e.vector)
+		if s >= c.threshold && s > similarity {
+			best, similarity = &c.semantic[i], s
+		}
+	}
+	var match string
+	if best != nil {
+		match = best.key
+	}
+	c.mu.Unlock()
+
+	if match == "" {
+		return nil, nil, false
+	}
+	value, ok = c.store.Get(match)
+	return value, &similarity, ok
+}
+
+// save stores value by the key of q, and the embedding of the last prompt for semantic lookups.
+func (c *Cache) save(q *query, value []byte) {
+	err := c.store.Set(q.key, value, c.ttl)
+	if err != nil || c.embeder == nil {
+		return
+	}
+	scope, vector, ok := c.embed(q)
+	if !ok {
+		return
+	}
+	entry := semanticEntry{scope: scope, key: q.key, vector: vector}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	kept := c.semantic[:0]
+	for _, e := range c.semantic {
+		if e.key != q.key && (e.expires.IsZero() || now.Before(e.expires)) {
+			kept = append(kept, e)
+		}
+	}
+	c.semantic = append(kept, entry)
+	if len(c.semantic) > semanticLimit {
+		c.semantic = c.semantic[len(c.semantic)-semanticLimit:]
+	}
+}
+
+// embed returns the scope of a semantic lookup, ie. the key of the request without the text of the last prompt, and
+// the embedding of that text. It is computed once per query. Embedding is best effort, a failure is a miss.
+func (c *Cache) embed(q *query) (string, []float64, bool) {
+	if !q.embedded {
+		q.embedded = true
+		q.scope, q.vector = c.scope(q)
+	}
+	return q.scope, q.vector, q.vector != nil
+}
+
+func (c *Cache) scope(q *query) (string, []float64) {
+	if len(q.prompts) == 0 {
+		return "", nil
+	}
+	last := q.prompts[len(q.prompts)-1]
+	if last.Role != prompt.UserRole || last.Payload != nil || last.Text == "" {
+		return "", nil
+	}
+
+	scoped := append([]prompt.Prompt{}, q.prompts...)
+	scoped[len(scoped)-1].Text = ""
+	scope, err := namespaced(q.namespace, q.request, scoped)
+	if err != nil {
+		return "", nil
+	}
+
+	res, err := c.embeder.Embed(embed.NewSingleRequest(q.request.Context, c.model.WithType(embed.TypeQuery), last.Text))
+	if err != nil {
+		return "", nil
+	}
+	vector, err := res.Single()
+	if err != nil {
+		return "", nil
+	}
+	return scope, vector
+}
+
+func cosine(a []float64, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// withHit returns other with the cache hit, and its similarity if it is a semantic hit.
+func withHit(other map[string]any, similarity *float64) map[string]any {
+	res := maps.Clone(other)
+	if res == nil {
+		res = map[string]any{}
+	}
+	res["cache_hit"] = true
+	if similarity != nil {
+		res["cache_similarity"] = *similarity
+	}
+	return res
+}
@@ -0,0 +1,159 @@
+package gencache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/modfin/bellman"
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/embed"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/tools"
+)
+
+// counting counts the prompts and streams sent to the mock
+type counting struct {
+	gen.Prompter
+	calls int
+}
+
+func (c *counting) Prompt(prompts ...prompt.Prompt) (*gen.Response, error) {
+	c.calls++
+	return c.Prompter.Prompt(prompts...)
+}
+
+func (c *counting) Stream(prompts ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
+	c.calls++
+	return c.Prompter.Stream(prompts...)
+}
+
+func generator() (*gen.Generator, *counting) {
+	g := bellman.NewMock().Generator(gen.WithModel(gen.Model{Provider: bellman.MockProvider, Name: "gen"}))
+	c := &counting{Prompter: g.Prompter}
+	g.Prompter = c
+	return g, c
+}
+
+func TestPrompt(t *testing.T) {
+	g, upstream := generator()
+	tool := tools.NewTool("lookup")
+	g = New(NewMemoryStore(10)).Wrap(g).SetTools(tool)
+
+	first, err := g.Prompt(prompt.AsUser("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := g.Prompt(prompt.AsUser("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstream.calls != 1 || second.Texts[0] != first.Texts[0] || second.Metadata.Other["cache_hit"] != true {
+		t.Fatalf("expected the second prompt to hit, got %d calls and %+v", upstream.calls, second.Metadata)
+	}
+
+	_, _ = g.Temperature(0.5).Prompt(prompt.AsUser("hello"))
+	_, _ = g.System("be brief").Prompt(prompt.AsUser("hello"))
+	_, _ = g.SetTools().Prompt(prompt.AsUser("hello"))
+	if upstream.calls != 4 {
+		t.Fatalf("expected other params to miss, got %d calls", upstream.calls)
+	}
+}
+
+func TestStream(t *testing.T) {
+	g, upstream := generator()
+	g = New(NewMemoryStore(10)).Wrap(g)
+
+	collect := func() (string, *models.Metadata) {
+		stream, err := g.Stream(prompt.AsUser("hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var text string
+		var metadata *models.Metadata
+		for e := range stream {
+			switch e.Type {
+			case gen.TYPE_DELTA:
+				text += e.Content
+			case gen.TYPE_METADATA:
+				metadata = e.Metadata
+			}
+		}
+		return text, metadata
+	}
+	first, _ := collect()
+	second, metadata := collect()
+	if upstream.calls != 1 || first == "" || first != second || metadata.Other["cache_hit"] != true {
+		t.Fatalf("expected the stream to be replayed, got %d calls, %q and %q", upstream.calls, first, second)
+	}
+
+	_, _ = g.Prompt(prompt.AsUser("hi"))
+	if upstream.calls != 2 {
+		t.Fatalf("expected prompts to be cached apart from streams, got %d calls", upstream.calls)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	g, upstream := generator()
+	g = New(NewMemoryStore(10), WithTTL(time.Millisecond)).Wrap(g)
+	_, _ = g.Prompt(prompt.AsUser("hello"))
+	time.Sleep(5 * time.Millisecond)
+	_, _ = g.Prompt(prompt.AsUser("hello"))
+	if upstream.calls != 2 {
+		t.Fatalf("expected the response to expire, got %d calls", upstream.calls)
+	}
+}
+
+// lengths embeds texts by their length, so texts of equal length are equal, and counts the texts embedded
+type lengths struct {
+	embedded int
+}
+
+func (*lengths) Provider() string { return "test" }
+
+func (l *lengths) Embed(req *embed.Request) (*embed.Response, error) {
+	l.embedded += len(req.Texts)
+	return &embed.Response{Embeddings: [][]float64{{float64(len(req.Texts[0])), 1}}}, nil
+}
+
+func (*lengths) EmbedDocument(req *embed.DocumentRequest) (*embed.DocumentResponse, error) {
+	return nil, nil
+}
+
+func TestSemantic(t *testing.T) {
+	g, upstream := generator()
+	embeder := &lengths{}
+	cache := New(NewMemoryStore(10), WithSemantic(embeder, embed.Model{}, 0.9999))
+	g = cache.Wrap(g).WithContext(context.Background())
+
+	_, _ = g.Prompt(prompt.AsUser("What is the capital of Sweden?"))
+	res, err := g.Prompt(prompt.AsUser("what is the capital of sweden?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstream.calls != 1 || res.Metadata.Other["cache_similarity"] == nil {
+		t.Fatalf("expected a semantic hit, got %d calls and %+v", upstream.calls, res.Metadata)
+	}
+	if embeder.embedded != 2 {
+		t.Fatalf("expected each prompt to be embedded once, got %d", embeder.embedded)
+	}
+
+	_, _ = g.Prompt(prompt.AsUser("Hi"))
+	_, _ = g.System("other").Prompt(prompt.AsUser("what is the capital of sweden?"))
+	if upstream.calls != 3 {
+		t.Fatalf("expected other texts and requests to miss, got %d calls", upstream.calls)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	g, upstream := generator()
+	cache := New(NewMemoryStore(10), WithSemantic(&lengths{}, embed.Model{}, 0.9999))
+
+	_, _ = cache.WrapNamespace(g, "key-a").Prompt(prompt.AsUser("hello"))
+	_, _ = cache.WrapNamespace(g, "key-b").Prompt(prompt.AsUser("hello"))
+	_, _ = cache.WrapNamespace(g, "key-b").Prompt(prompt.AsUser("HELLO"))
+	if upstream.calls != 2 {
+		t.Fatalf("expected responses to be shared within, but not between, namespaces, got %d calls", upstream.calls)
+	}
+}
@@ -0,0 +1,134 @@
+package gencache
+
+import (
+	"encoding/json"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/tools"
+)
+
+// prompter is a gen.Prompter that prompts and streams through the cache.
+type prompter struct {
+	cache     *Cache
+	prompter  gen.Prompter
+	request   gen.Request
+	namespace string
+}
+
+func (p *prompter) SetRequest(request gen.Request) {
+	p.request = request
+	p.prompter.SetRequest(request)
+}
+
+// CountTokens counts with the wrapped prompter, if it is a gen.TokenCounter, since Generator.CountTokens only sees
+// the cache.
+func (p *prompter) CountTokens(prompts ...prompt.Prompt) (*gen.TokenCount, error) {
+	if counter, ok := p.prompter.(gen.TokenCounter); ok {
+		return counter.CountTokens(prompts...)
+	}
+	return &gen.TokenCount{InputTokens: gen.EstimateTokens(p.request, prompts...), Estimated: true}, nil
+}
+
+func (p *prompter) Prompt(prompts ...prompt.Prompt) (*gen.Response, error) {
+	request := p.request
+	request.Stream = false
+	q, err := newQuery(p.namespace, request, prompts)
+	if err != nil {
+		return p.prompter.Prompt(prompts...)
+	}
+
+	if value, similarity, ok := p.cache.lookup(q); ok {
+		var res gen.Response
+		if json.Unmarshal(value, &res) == nil {
+			for i := range res.Tools {
+				res.Tools[i].Ref = toolRef(request, res.Tools[i].Name)
+			}
+			res.Metadata.Other = withHit(res.Metadata.Other, similarity)
+			return &res, nil
+		}
+	}
+
+	res, err := p.prompter.Prompt(prompts...)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(res)
+	if err == nil {
+		p.cache.save(q, value)
+	}
+	return res, nil
+}
+
+func (p *prompter) Stream(prompts ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
+	request := p.request
+	request.Stream = true
+	q, err := newQuery(p.namespace, request, prompts)
+	if err != nil {
+		return p.prompter.Stream(prompts...)
+	}
+
+	if value, similarity, ok := p.cache.lookup(q); ok {
+		var events []*gen.StreamResponse
+		if json.Unmarshal(value, &events) == nil {
+			return replay(request, events, similarity), nil
+		}
+	}
+
+	upstream, err := p.prompter.Stream(prompts...)
+	if err != nil {
+		return nil, err
+	}
+	stream := make(chan *gen.StreamResponse)
+	go func() {
+		defer close(stream)
+		var events []*gen.StreamResponse
+		failed := false
+		for e := range upstream {
+			// recorded as json before it is sent, since the receiver may change it
+			b, err := json.Marshal(e)
+			var recorded gen.StreamResponse
+			if err != nil || json.Unmarshal(b, &recorded) != nil {
+				failed = true
+			}
+			events = append(events, &recorded)
+			failed = failed || e.Type == gen.TYPE_ERROR
+
+			stream <- e
+
+			if e.Type == gen.TYPE_EOF && !failed {
+				value, err := json.Marshal(events)
+				if err == nil {
+					p.cache.save(q, value)
+				}
+			}
+		}
+	}()
+	return stream, nil
+}
+
+// replay returns a stream of the cached events, with the hit in the metadata events.
+func replay(request gen.Request, events []*gen.StreamResponse, similarity *float64) <-chan *gen.StreamResponse {
+	stream := make(chan *gen.StreamResponse, len(events))
+	for _, e := range events {
+		if e.ToolCall != nil {
+			e.ToolCall.Ref = toolRef(request, e.ToolCall.Name)
+		}
+		if e.Metadata != nil {
+			e.Metadata.Other = withHit(e.Metadata.Other, similarity)
+		}
+		stream <- e
+	}
+	close(stream)
+	return stream
+}
+
+// toolRef returns the tool of the request named name, since the callbacks of tools are not cached.
+func toolRef(request gen.Request, name string) *tools.Tool {
+	for i := range request.Tools {
+		if request.Tools[i].Name == name {
+			return &request.Tools[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package gencache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Store stores cached responses by key. Caching is best effort, so a store that fails to get a value should report
+// a miss, and errors of Set are ignored by the Cache.
+type Store interface {
+	Get(key string) ([]byte, bool)
+	// Set stores value by key, for ttl, or until evicted if ttl is 0.
+	Set(key string, value []byte, ttl time.Duration) error
+}
+
+// MemoryStore is an in-memory Store, that evicts the least recently used responses over its capacity.
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // of *memoryEntry, the most recently used first
+	entries  map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryStore returns a MemoryStore of at most capacity responses.
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: max(capacity, 1),
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (m *MemoryStore) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.order.Remove(e)
+		delete(m.entries, key)
+		return nil, false
+	}
+	m.order.MoveToFront(e)
+	return entry.value, true
+}
+
+func (m *MemoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := &memoryEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if e, ok := m.entries[key]; ok {
+		e.Value = entry
+		m.order.MoveToFront(e)
+		return nil
+	}
+	m.entries[key] = m.order.PushFront(entry)
+	for m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).key)
+	}
+	return nil
+}
+
+// Len returns the number of responses in the store, including expired ones not yet evicted.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
@@ -48,6 +48,9 @@ func (m *MockClient) Embed(request *embed.Request) (*embed.Response, error) {
 	// Generate mock embeddings (384 dimensions for simplicity)
 	embeddings := make([][]float64, len(request.Texts))
 	dimensions := 384
+	if request.Dimensions > 0 {
+		dimensions = request.Dimensions
+	}
 
 	for i, text := range request.Texts {
 		embeddings[i] = make([]float64, dimensions)
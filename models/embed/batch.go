@@ -0,0 +1,236 @@
+package embed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/gen"
+)
+
+const (
+	defaultBatchConcurrency = 4
+	defaultBatchRetries     = 2
+	defaultBatchBackoff     = 500 * time.Millisecond
+)
+
+// Batcher is an Embeder that splits the texts of a Request into batches, according to Model.MaxRequestTexts and
+// Model.MaxRequestTokens, and embeds them with the wrapped Embeder. Batches are dispatched with bounded concurrency
+// and retried on transient failures, see StatusError, and the embeddings are returned in the order of the texts.
+//
+// Tokens are estimated with gen.EstimateTextTokens, which is rough, so keep a margin in MaxRequestTokens if the texts
+// are close to the limit. A single text over the limit is sent alone, and left to the provider to reject or truncate.
+type Batcher struct {
+	embeder     Embeder
+	concurrency int
+	retries     int
+	backoff     time.Duration
+}
+
+type BatchOption func(b *Batcher)
+
+// WithConcurrency sets the max number of batches in flight at once. Default 4.
+func WithConcurrency(concurrency int) BatchOption {
+	return func(b *Batcher) {
+		b.concurrency = max(concurrency, 1)
+	}
+}
+
+// WithRetries sets the number of times a batch failing with a transient error, ie. a network error, 429 or 5xx, is
+// retried, with exponential backoff starting at backoff.
+// Default 2 retries, starting at 500ms.
+func WithRetries(retries int, backoff time.Duration) BatchOption {
+	return func(b *Batcher) {
+		b.retries = max(retries, 0)
+		b.backoff = backoff
+	}
+}
+
+func NewBatcher(embeder Embeder, options ...BatchOption) *Batcher {
+	b := &Batcher{
+		embeder:     embeder,
+		concurrency: defaultBatchConcurrency,
+		retries:     defaultBatchRetries,
+		backoff:     defaultBatchBackoff,
+	}
+	for _, op := range options {
+		op(b)
+	}
+	return b
+}
+
+func (b *Batcher) Provider() string {
+	return b.embeder.Provider()
+}
+
+// EmbedDocument is passed on as is, since the chunks of a document has to be embedded together.
+func (b *Batcher) EmbedDocument(req *DocumentRequest) (*DocumentResponse, error) {
+	return b.embeder.EmbedDocument(req)
+}
+
+func (b *Batcher) Embed(req *Request) (*Response, error) {
+	batches := split(req)
+	if len(batches) < 2 {
+		return b.embed(req)
+	}
+
+	ctx := req.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	responses := make([]*Response, len(batches))
+	errs := make([]error, len(batches))
+
+	semaphore := make(chan struct{}, b.concurrency)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			batch.Ctx = ctx
+			responses[i], errs[i] = b.embed(batch)
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the error that caused the cancellation, rather than the cancellations it caused
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+	}
+	res := &Response{Metadata: models.Metadata{Model: req.Model.FQN()}}
+	for i, r := range responses {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		res.Embeddings = append(res.Embeddings, r.Embeddings...)
+		res.Int8 = append(res.Int8, r.Int8...)
+		res.Uint8 = append(res.Uint8, r.Uint8...)
+		res.Metadata.InputTokens += r.Metadata.InputTokens
+		res.Metadata.TotalTokens += r.Metadata.TotalTokens
+	}
+	return res, nil
+}
+
+// StatusError is an unexpected http status code of an embedding request, returned by the providers.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code, %d, %s", e.StatusCode, e.Message)
+}
+
+// transient is true if err is worth retrying, ie. a network error, or a rate limit or server error of the provider.
+// Other errors, eg. too many texts, too long input or a bad api key, fail the same way again.
+func transient(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// embed embeds one batch, retrying transient errors with exponential backoff.
+func (b *Batcher) embed(req *Request) (*Response, error) {
+	ctx := req.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	backoff := b.backoff
+	var err error
+	for attempt := 0; attempt <= b.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		var res *Response
+		res, err = b.embeder.Embed(req)
+		if err == nil {
+			n := len(res.Embeddings) + len(res.Int8) + len(res.Uint8)
+			if n != len(req.Texts)+len(req.Inputs) {
+				return nil, fmt.Errorf("expected %d embeddings, got %d", len(req.Texts)+len(req.Inputs), n)
+			}
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !transient(err) {
+			return nil, fmt.Errorf("could not embed batch of %d texts, %w", len(req.Texts)+len(req.Inputs), err)
+		}
+	}
+	return nil, fmt.Errorf("could not embed batch of %d texts after %d attempts, %w", len(req.Texts)+len(req.Inputs), b.retries+1, err)
+}
+
+// split splits req into requests of batches of its texts, or of its multimodal inputs. Inputs are only split by
+// MaxRequestTexts, since the tokens of payloads can not be estimated.
+func split(req *Request) []*Request {
+	var requests []*Request
+	if req.IsMultimodal() {
+		size := req.Model.MaxRequestTexts
+		if size <= 0 {
+			size = len(req.Inputs)
+		}
+		for batch := range slices.Chunk(req.Inputs, size) {
+			r := *req
+			r.Inputs = batch
+			requests = append(requests, &r)
+		}
+		return requests
+	}
+	for _, batch := range Batches(req.Model, req.Texts) {
+		r := *req
+		r.Texts = batch
+		requests = append(requests, &r)
+	}
+	return requests
+}
+
+// Batches splits texts into batches within the MaxRequestTexts and MaxRequestTokens of model. The texts keep their
+// order, so the batches can be concatenated back.
+func Batches(model Model, texts []string) [][]string {
+	var batches [][]string
+	var batch []string
+	var tokens int
+	for _, text := range texts {
+		t := gen.EstimateTextTokens(text)
+		full := model.MaxRequestTexts > 0 && len(batch) >= model.MaxRequestTexts
+		over := model.MaxRequestTokens > 0 && tokens+t > model.MaxRequestTokens
+		if len(batch) > 0 && (full || over) {
+			batches = append(batches, batch)
+			batch, tokens = nil, 0
+		}
+		batch = append(batch, text)
+		tokens += t
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
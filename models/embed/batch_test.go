@@ -0,0 +1,113 @@
+package embed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/modfin/bellman/models"
+)
+
+// flaky embeds each text as its length, and fails the first request of any batch starting with "fail".
+type flaky struct {
+	mu      sync.Mutex
+	batches [][]string
+	failed  bool
+}
+
+func (f *flaky) Provider() string { return "test" }
+
+func (f *flaky) Embed(req *Request) (*Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if strings.HasPrefix(req.Texts[0], "fail") && !f.failed {
+		f.failed = true
+		return nil, &StatusError{StatusCode: http.StatusTooManyRequests, Message: "rate limited"}
+	}
+	f.batches = append(f.batches, req.Texts)
+	res := &Response{Metadata: models.Metadata{TotalTokens: len(req.Texts)}}
+	for _, text := range req.Texts {
+		res.Embeddings = append(res.Embeddings, []float64{float64(len(text))})
+	}
+	return res, nil
+}
+
+func (f *flaky) EmbedDocument(req *DocumentRequest) (*DocumentResponse, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestBatcher(t *testing.T) {
+	f := &flaky{}
+	model := Model{Provider: "test", Name: "test", MaxRequestTexts: 2}
+	texts := []string{"a", "bb", "fail", "dddd", "eeeee"}
+
+	res, err := NewBatcher(f, WithConcurrency(2), WithRetries(1, time.Millisecond)).
+		Embed(NewManyRequest(context.Background(), model, texts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.batches) != 3 || !f.failed || res.Metadata.TotalTokens != 5 {
+		t.Fatalf("expected 3 batches, one retried, got %v", f.batches)
+	}
+	for i, emb := range res.Embeddings {
+		if emb[0] != float64(len(texts[i])) {
+			t.Fatalf("expected embeddings in the order of the texts, got %v", res.Embeddings)
+		}
+	}
+
+	batches := Batches(Model{MaxRequestTokens: 10}, []string{strings.Repeat("word ", 8), "short", strings.Repeat("word ", 30)})
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("expected the texts to be split by tokens, got %v", batches)
+	}
+}
+
+// failing fails every request with err, counting the attempts.
+type failing struct {
+	err      error
+	attempts int
+}
+
+func (f *failing) Provider() string { return "test" }
+
+func (f *failing) Embed(req *Request) (*Response, error) {
+	f.attempts++
+	return nil, f.err
+}
+
+func (f *failing) EmbedDocument(req *DocumentRequest) (*DocumentResponse, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestBatcherRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"rate limited", &StatusError{StatusCode: http.StatusTooManyRequests}, 3},
+		{"server error", fmt.Errorf("could not embed, %w", &StatusError{StatusCode: http.StatusServiceUnavailable}), 3},
+		{"network error", fmt.Errorf("could not post request, %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), 3},
+		{"too many texts", &StatusError{StatusCode: http.StatusBadRequest, Message: "too many texts"}, 1},
+		{"unauthorized", &StatusError{StatusCode: http.StatusUnauthorized}, 1},
+		{"other error", errors.New("could not decode response"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &failing{err: tt.err}
+			_, err := NewBatcher(f, WithRetries(2, time.Millisecond)).
+				Embed(NewManyRequest(context.Background(), Model{Provider: "test", Name: "test"}, []string{"a"}))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected the error to be returned, got %v", err)
+			}
+			if f.attempts != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, f.attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package embed
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/modfin/bellman/models"
+)
+
+// CacheStore stores embeddings by key. Caching is best effort, so a store that fails to get an embedding should
+// report a miss, and errors of Set are ignored by the Cache.
+type CacheStore interface {
+	Get(key string) ([]float64, bool)
+	Set(key string, embedding []float64) error
+}
+
+// Cache is an Embeder that caches embeddings by model, type, dimensions and text, and only sends the texts that are
+// not cached to the wrapped Embeder. The number of hits and misses are reported in Metadata.Other, as cache_hits
+// and cache_misses. The tokens in Metadata are the tokens of the misses.
+//
+// Embeddings of document chunks are cached by the whole document, since they depend on the other chunks. Multimodal
+// inputs and quantized output types are not cached.
+type Cache struct {
+	embeder Embeder
+	store   CacheStore
+}
+
+func NewCache(embeder Embeder, store CacheStore) *Cache {
+	return &Cache{embeder: embeder, store: store}
+}
+
+func (c *Cache) Provider() string {
+	return c.embeder.Provider()
+}
+
+// CacheKey returns the cache key of text embedded by model, with dimensions, as model fqn, type, dimensions and
+// the sha256 of the text.
+func CacheKey(model Model, dimensions int, text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return model.FQN() + "|" + string(model.Type) + "|" + strconv.Itoa(dimensions) + "|" + hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) Embed(req *Request) (*Response, error) {
+	if req.IsMultimodal() || req.OutputDType.Quantized() {
+		return c.embeder.Embed(req)
+	}
+
+	embeddings := make([][]float64, len(req.Texts))
+	keys := make([]string, len(req.Texts))
+	var misses []string
+	missed := map[string][]int{} // the texts of each miss, a text may be repeated
+	hits := 0
+	for i, text := range req.Texts {
+		keys[i] = CacheKey(req.Model, req.Dimensions, text)
+		if e, ok := c.store.Get(keys[i]); ok {
+			embeddings[i] = e
+			hits++
+			continue
+		}
+		if _, ok := missed[text]; !ok {
+			misses = append(misses, text)
+		}
+		missed[text] = append(missed[text], i)
+	}
+
+	res := &Response{Metadata: models.Metadata{Model: req.Model.FQN()}}
+	if len(misses) > 0 {
+		r := *req
+		r.Texts = misses
+		upstream, err := c.embeder.Embed(&r)
+		if err != nil {
+			return nil, err
+		}
+		if len(upstream.Embeddings) != len(misses) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(misses), len(upstream.Embeddings))
+		}
+		for k, text := range misses {
+			// duplicates of a text get their own copy, so that changing one does not change the others
+			for j, i := range missed[text] {
+				embeddings[i] = upstream.Embeddings[k]
+				if j > 0 {
+					embeddings[i] = slices.Clone(upstream.Embeddings[k])
+				}
+			}
+			_ = c.store.Set(keys[missed[text][0]], upstream.Embeddings[k])
+		}
+		res.Metadata = upstream.Metadata
+	}
+
+	res.Embeddings = embeddings
+	res.Metadata.Other = withCacheStats(res.Metadata.Other, hits, len(req.Texts)-hits)
+	return res, nil
+}
+
+func (c *Cache) EmbedDocument(req *DocumentRequest) (*DocumentResponse, error) {
+	if req.OutputDType.Quantized() {
+		return c.embeder.EmbedDocument(req)
+	}
+
+	// the chunks are keyed by the document, and their index in it
+	document := CacheKey(req.Model, req.Dimensions, strings.Join(req.DocumentChunks, "\x00"))
+	res := &DocumentResponse{
+		Embeddings: make([][]float64, len(req.DocumentChunks)),
+		Metadata:   models.Metadata{Model: req.Model.FQN()},
+	}
+	hit := true
+	for i := range req.DocumentChunks {
+		e, ok := c.store.Get(document + "|" + strconv.Itoa(i))
+		if !ok {
+			hit = false
+			break
+		}
+		res.Embeddings[i] = e
+	}
+	if hit {
+		res.Metadata.Other = withCacheStats(nil, len(req.DocumentChunks), 0)
+		return res, nil
+	}
+
+	upstream, err := c.embeder.EmbedDocument(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(upstream.Embeddings) == len(req.DocumentChunks) {
+		for i, e := range upstream.Embeddings {
+			_ = c.store.Set(document+"|"+strconv.Itoa(i), e)
+		}
+	}
+	upstream.Metadata.Other = withCacheStats(upstream.Metadata.Other, 0, len(req.DocumentChunks))
+	return upstream, nil
+}
+
+func withCacheStats(other map[string]any, hits int, misses int) map[string]any {
+	res := maps.Clone(other)
+	if res == nil {
+		res = map[string]any{}
+	}
+	res["cache_hits"] = hits
+	res["cache_misses"] = misses
+	return res
+}
+
+// MemoryCache is an in-memory CacheStore, that evicts the least recently used embeddings over its capacity.
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // of *memoryEntry, the most recently used first
+	entries  map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key       string
+	embedding []float64
+}
+
+// NewMemoryCache returns a MemoryCache of at most capacity embeddings.
+func NewMemoryCache(capacity int) *MemoryCache {
+	return &MemoryCache{
+		capacity: max(capacity, 1),
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (m *MemoryCache) Get(key string) ([]float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	m.order.MoveToFront(e)
+	return slices.Clone(e.Value.(*memoryEntry).embedding), true
+}
+
+func (m *MemoryCache) Set(key string, embedding []float64) error {
+	embedding = slices.Clone(embedding)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok {
+		e.Value.(*memoryEntry).embedding = embedding
+		m.order.MoveToFront(e)
+		return nil
+	}
+	m.entries[key] = m.order.PushFront(&memoryEntry{key: key, embedding: embedding})
+	for m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).key)
+	}
+	return nil
+}
+
+// Len returns the number of embeddings in the cache.
+func (m *MemoryCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+// DiskCache is a CacheStore of one file per embedding in a directory, which survives restarts, and can be shared by
+// processes. Nothing is evicted, so clean up the directory if it grows too large.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a DiskCache in dir, which is created if it does not exist.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cache dir, %w", err)
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+// path returns the file of key, in a sub directory of the first byte of its hash, to keep directories small.
+func (d *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(d.dir, name[:2], name)
+}
+
+func (d *DiskCache) Get(key string) ([]float64, bool) {
+	b, err := os.ReadFile(d.path(key))
+	if err != nil || len(b) == 0 || len(b)%8 != 0 {
+		return nil, false
+	}
+	embedding := make([]float64, len(b)/8)
+	for i := range embedding {
+		embedding[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:]))
+	}
+	return embedding, true
+}
+
+// Set writes the embedding to a temporary file, that is renamed into place, so readers never see a partial file.
+func (d *DiskCache) Set(key string, embedding []float64) error {
+	b := make([]byte, len(embedding)*8)
+	for i, x := range embedding {
+		binary.LittleEndian.PutUint64(b[i*8:], math.Float64bits(x))
+	}
+
+	path := d.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("could not create cache dir, %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create cache file, %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not write cache file, %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close cache file, %w", err)
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return fmt.Errorf("could not rename cache file, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package embed
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/modfin/bellman/models"
+)
+
+// counting embeds each text as its length, and keeps the texts it was sent
+type counting struct {
+	texts []string
+}
+
+func (c *counting) Provider() string { return "test" }
+
+func (c *counting) Embed(req *Request) (*Response, error) {
+	c.texts = append(c.texts, req.Texts...)
+	res := &Response{Metadata: models.Metadata{Model: req.Model.FQN(), TotalTokens: len(req.Texts)}}
+	for _, text := range req.Texts {
+		res.Embeddings = append(res.Embeddings, []float64{float64(len(text))})
+	}
+	return res, nil
+}
+
+func (c *counting) EmbedDocument(req *DocumentRequest) (*DocumentResponse, error) {
+	c.texts = append(c.texts, req.DocumentChunks...)
+	res := &DocumentResponse{Metadata: models.Metadata{Model: req.Model.FQN()}}
+	for _, chunk := range req.DocumentChunks {
+		res.Embeddings = append(res.Embeddings, []float64{float64(len(chunk))})
+	}
+	return res, nil
+}
+
+func TestCache(t *testing.T) {
+	for name, store := range map[string]func() (CacheStore, error){
+		"memory": func() (CacheStore, error) { return NewMemoryCache(10), nil },
+		"disk":   func() (CacheStore, error) { return NewDiskCache(t.TempDir()) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, err := store()
+			if err != nil {
+				t.Fatal(err)
+			}
+			upstream := &counting{}
+			cache := NewCache(upstream, s)
+			model := Model{Provider: "test", Name: "test"}
+			ctx := context.Background()
+
+			_, err = cache.Embed(NewManyRequest(ctx, model, []string{"a", "bb"}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := cache.Embed(NewManyRequest(ctx, model, []string{"bb", "ccc", "ccc", "a"}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(upstream.texts) != 3 || upstream.texts[2] != "ccc" {
+				t.Fatalf("expected only the miss ccc to be sent once, got %v", upstream.texts)
+			}
+			if res.Embeddings[0][0] != 2 || res.Embeddings[2][0] != 3 || res.Embeddings[3][0] != 1 {
+				t.Fatalf("unexpected embeddings %v", res.Embeddings)
+			}
+			if res.Metadata.Other["cache_hits"] != 2 || res.Metadata.Other["cache_misses"] != 2 {
+				t.Fatalf("expected 2 hits and 2 misses, got %v", res.Metadata.Other)
+			}
+
+			// embeddings returned are not shared, neither between duplicates nor with the cache
+			res.Embeddings[1][0] = 99
+			again, _ := cache.Embed(NewSingleRequest(ctx, model, "ccc"))
+			if res.Embeddings[2][0] != 3 || again.Embeddings[0][0] != 3 {
+				t.Fatalf("expected changes to an embedding not to leak, got %v and %v", res.Embeddings, again.Embeddings)
+			}
+
+			// another type is another embedding
+			_, _ = cache.Embed(NewSingleRequest(ctx, model.WithType(TypeQuery), "a"))
+			if len(upstream.texts) != 4 {
+				t.Fatalf("expected a query to miss, got %v", upstream.texts)
+			}
+
+			doc := NewDocumentRequest(ctx, model, []string{"x", "yy"})
+			_, _ = cache.EmbedDocument(doc)
+			dres, err := cache.EmbedDocument(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(upstream.texts) != 6 || dres.Embeddings[1][0] != 2 || dres.Metadata.Other["cache_hits"] != 2 {
+				t.Fatalf("expected the document to hit, got %v, %+v", upstream.texts, dres)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheEvicts(t *testing.T) {
+	m := NewMemoryCache(2)
+	_ = m.Set("a", []float64{1})
+	_ = m.Set("b", []float64{2})
+	m.Get("a")
+	_ = m.Set("c", []float64{3})
+	if _, ok := m.Get("b"); ok || m.Len() != 2 {
+		t.Fatal("expected b, the least recently used, to be evicted")
+	}
+	if _, ok := m.Get("a"); !ok {
+		t.Fatal(errors.New("expected a to be kept"))
+	}
+}
@@ -0,0 +1,47 @@
+package embed
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// DecodeFloat32 decodes a base64 encoded embedding of little endian float32, as returned by providers when the
+// encoding format is base64. It is about a quarter of the size of the json numbers, and faster to decode.
+func DecodeFloat32(encoded string) ([]float64, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode base64 embedding, %w", err)
+	}
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("base64 embedding of %d bytes is not float32", len(data))
+	}
+	embedding := make([]float64, len(data)/4)
+	for i := range embedding {
+		embedding[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])))
+	}
+	return embedding, nil
+}
+
+// DecodeInt8 decodes a base64 encoded embedding of int8, ie. of DTypeInt8 or DTypeBinary.
+func DecodeInt8(encoded string) ([]int8, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode base64 embedding, %w", err)
+	}
+	embedding := make([]int8, len(data))
+	for i, b := range data {
+		embedding[i] = int8(b)
+	}
+	return embedding, nil
+}
+
+// DecodeUint8 decodes a base64 encoded embedding of uint8, ie. of DTypeUint8 or DTypeUbinary.
+func DecodeUint8(encoded string) ([]uint8, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode base64 embedding, %w", err)
+	}
+	return data, nil
+}
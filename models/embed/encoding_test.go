@@ -0,0 +1,33 @@
+package embed
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestDecodeFloat32(t *testing.T) {
+	data := make([]byte, 0, 12)
+	for _, f := range []float32{0.5, -1.25, 3} {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(f))
+	}
+
+	embedding, err := DecodeFloat32(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embedding) != 3 || embedding[0] != 0.5 || embedding[1] != -1.25 || embedding[2] != 3 {
+		t.Fatalf("unexpected embedding %v", embedding)
+	}
+
+	i8, err := DecodeInt8(base64.StdEncoding.EncodeToString([]byte{0xff, 0x7f}))
+	if err != nil || len(i8) != 2 || i8[0] != -1 || i8[1] != 127 {
+		t.Fatalf("unexpected int8 embedding %v, %v", i8, err)
+	}
+
+	_, err = DecodeFloat32(base64.StdEncoding.EncodeToString([]byte{1, 2, 3}))
+	if err == nil {
+		t.Fatal("expected an error for a truncated float32")
+	}
+}
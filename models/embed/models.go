@@ -21,6 +21,23 @@ const (
 	TypeNone     Type = ""
 )
 
+// DType is the data type of the embeddings, see Request.OutputDType.
+type DType string
+
+const (
+	DTypeFloat DType = "float"
+	DTypeInt8  DType = "int8"
+	DTypeUint8 DType = "uint8"
+	// DTypeBinary and DTypeUbinary are bit packed, 8 dimensions in each value, as int8 and uint8.
+	DTypeBinary  DType = "binary"
+	DTypeUbinary DType = "ubinary"
+)
+
+// Quantized reports if embeddings of the data type are returned in Int8 or Uint8, rather than in Embeddings.
+func (d DType) Quantized() bool {
+	return d != "" && d != DTypeFloat
+}
+
 type Model struct {
 	Provider string `json:"provider"`
 	Name     string `json:"name"`
@@ -32,6 +49,14 @@ type Model struct {
 	InputMaxTokens   int `json:"input_max_tokens,omitempty"`
 	OutputDimensions int `json:"output_dimensions,omitempty"`
 
+	// MaxRequestTexts and MaxRequestTokens are the limits of the provider on the number of texts, and the total number
+	// of tokens, in one request. Zero means no known limit. Batcher splits requests according to them.
+	MaxRequestTexts  int `json:"max_request_texts,omitempty"`
+	MaxRequestTokens int `json:"max_request_tokens,omitempty"`
+
+	// InputContentTypes are the mime types of payloads in multimodal Request.Inputs, eg. images
+	InputContentTypes []string `json:"input_content_types,omitempty"`
+
 	Config map[string]any `json:"config,omitempty"`
 }
 
@@ -63,6 +88,29 @@ type Request struct {
 	Ctx   context.Context `json:"-"`
 	Model Model           `json:"model"`
 	Texts []string        `json:"texts"`
+	// Inputs are multimodal inputs, for models with InputContentTypes, used instead of Texts
+	Inputs []Input `json:"inputs,omitempty"`
+
+	// Dimensions reduces the embeddings to the number of dimensions, for models trained with Matryoshka
+	// representation learning. Zero is the default of the model.
+	Dimensions int `json:"dimensions,omitempty"`
+	// OutputDType is the data type of the embeddings. Quantized embeddings are returned in Response.Int8 or
+	// Response.Uint8. Empty is DTypeFloat.
+	OutputDType DType `json:"output_dtype,omitempty"`
+}
+
+// WithDimensions returns a copy of the request with the embeddings reduced to dimensions.
+func (r *Request) WithDimensions(dimensions int) *Request {
+	rr := *r
+	rr.Dimensions = dimensions
+	return &rr
+}
+
+// WithOutputDType returns a copy of the request with the embeddings returned as dtype.
+func (r *Request) WithOutputDType(dtype DType) *Request {
+	rr := *r
+	rr.OutputDType = dtype
+	return &rr
 }
 
 func NewSingleRequest(ctx context.Context, model Model, text string) *Request {
@@ -93,8 +141,13 @@ func (r *Request) FirstText() string {
 }
 
 type Response struct {
-	Embeddings [][]float64     `json:"embeddings"`
-	Metadata   models.Metadata `json:"metadata,omitempty"`
+	Embeddings [][]float64 `json:"embeddings"`
+
+	// Int8 holds embeddings of DTypeInt8 and DTypeBinary, and Uint8 of DTypeUint8 and DTypeUbinary.
+	Int8  [][]int8  `json:"int8,omitempty"`
+	Uint8 [][]uint8 `json:"uint8,omitempty"`
+
+	Metadata models.Metadata `json:"metadata,omitempty"`
 }
 
 func (r *Response) Single() ([]float64, error) {
@@ -154,6 +207,10 @@ type DocumentRequest struct {
 	Ctx            context.Context `json:"-"`
 	Model          Model           `json:"model"`
 	DocumentChunks []string        `json:"document_chunks"`
+
+	// Dimensions and OutputDType, see Request.
+	Dimensions  int   `json:"dimensions,omitempty"`
+	OutputDType DType `json:"output_dtype,omitempty"`
 }
 
 func NewDocumentRequest(ctx context.Context, model Model, chunks []string) *DocumentRequest {
@@ -165,8 +222,13 @@ func NewDocumentRequest(ctx context.Context, model Model, chunks []string) *Docu
 }
 
 type DocumentResponse struct {
-	Embeddings [][]float64     `json:"embeddings"`
-	Metadata   models.Metadata `json:"metadata,omitempty"`
+	Embeddings [][]float64 `json:"embeddings"`
+
+	// Int8 and Uint8, see Response.
+	Int8  [][]int8  `json:"int8,omitempty"`
+	Uint8 [][]uint8 `json:"uint8,omitempty"`
+
+	Metadata models.Metadata `json:"metadata,omitempty"`
 }
 
 func (r *DocumentResponse) AsFloat64() [][]float64 {
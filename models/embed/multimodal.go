@@ -0,0 +1,95 @@
+package embed
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/modfin/bellman/prompt"
+)
+
+// Input is a multimodal input, of text and payloads, eg. images, that is embedded into one vector. Payloads are
+// inline base64 data, or a URI the provider can fetch.
+type Input struct {
+	Parts []Part `json:"parts"`
+}
+
+// Part is either a text or a payload.
+type Part struct {
+	Text    string          `json:"text,omitempty"`
+	Payload *prompt.Payload `json:"payload,omitempty"`
+}
+
+func NewInput(parts ...Part) Input {
+	return Input{Parts: parts}
+}
+
+func TextPart(text string) Part {
+	return Part{Text: text}
+}
+
+func DataPart(mime string, data []byte) Part {
+	return Part{Payload: &prompt.Payload{Mime: mime, Data: base64.StdEncoding.EncodeToString(data)}}
+}
+
+func URIPart(mime string, uri string) Part {
+	return Part{Payload: &prompt.Payload{Mime: mime, Uri: uri}}
+}
+
+// NewMultimodalRequest creates a request embedding inputs, which is supported by models with InputContentTypes.
+func NewMultimodalRequest(ctx context.Context, model Model, inputs []Input) *Request {
+	return &Request{
+		Ctx:    ctx,
+		Model:  model,
+		Inputs: inputs,
+	}
+}
+
+// IsMultimodal reports if the request has multimodal Inputs, rather than Texts.
+func (r *Request) IsMultimodal() bool {
+	return len(r.Inputs) > 0
+}
+
+// MultimodalInputs returns the Inputs of the request, or the Texts as text only inputs, for multimodal models.
+func (r *Request) MultimodalInputs() ([]Input, error) {
+	if len(r.Inputs) > 0 && len(r.Texts) > 0 {
+		return nil, fmt.Errorf("both texts and inputs provided, use one of them")
+	}
+	if len(r.Inputs) > 0 {
+		for i, input := range r.Inputs {
+			for _, part := range input.Parts {
+				if part.Payload == nil {
+					continue
+				}
+				if !r.Model.SupportsContentType(part.Payload.Mime) {
+					return nil, fmt.Errorf("input %d: content type %s is not supported by %s", i, part.Payload.Mime, r.Model.FQN())
+				}
+			}
+		}
+		return r.Inputs, nil
+	}
+	inputs := make([]Input, len(r.Texts))
+	for i, text := range r.Texts {
+		inputs[i] = NewInput(TextPart(text))
+	}
+	return inputs, nil
+}
+
+// IsMultimodal reports if the model embeds multimodal inputs, ie. has InputContentTypes.
+func (m Model) IsMultimodal() bool {
+	return len(m.InputContentTypes) > 0
+}
+
+// SupportsContentType reports if the model embeds payloads of mime. Models without any InputContentTypes declared,
+// eg. created with ToModel, are assumed to support it, and left to the provider to reject.
+func (m Model) SupportsContentType(mime string) bool {
+	if len(m.InputContentTypes) == 0 {
+		return true
+	}
+	for _, t := range m.InputContentTypes {
+		if t == mime {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package embed
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMultimodalInputs(t *testing.T) {
+	model := Model{Provider: "test", Name: "test", InputContentTypes: []string{"image/png"}, MaxRequestTexts: 2}
+
+	req := NewMultimodalRequest(context.Background(), model, []Input{
+		NewInput(TextPart("a"), DataPart("image/png", []byte{1, 2, 3})),
+		NewInput(TextPart("b")),
+		NewInput(URIPart("image/png", "https://example.com/c.png")),
+	})
+	inputs, err := req.MultimodalInputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 3 || inputs[0].Parts[1].Payload.Data != "AQID" {
+		t.Fatalf("unexpected inputs %+v", inputs)
+	}
+	if batches := split(req); len(batches) != 2 || len(batches[0].Inputs) != 2 || len(batches[1].Inputs) != 1 {
+		t.Fatalf("expected batches of 2 and 1 inputs, got %d", len(batches))
+	}
+
+	req.Inputs = append(req.Inputs, NewInput(DataPart("image/tiff", []byte{1})))
+	if _, err := req.MultimodalInputs(); err == nil {
+		t.Fatal("expected an error for an unsupported content type")
+	}
+
+	inputs, err = NewManyRequest(context.Background(), model, []string{"a", "b"}).MultimodalInputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 || inputs[1].Parts[0].Text != "b" {
+		t.Fatalf("expected texts as text inputs, got %+v", inputs)
+	}
+}
@@ -0,0 +1,78 @@
+package files
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+// Files is implemented by providers where files can be uploaded once, and referred to in prompts by their URI with
+// prompt.AsUserWithURI, rather than sent inline in every request.
+type Files interface {
+	Provider() string
+	Upload(req *UploadRequest) (*File, error)
+	List(ctx context.Context) ([]File, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type File struct {
+	Provider  string    `json:"provider"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Mime      string    `json:"mime_type,omitempty"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+
+	// URI refers to the file in prompts, eg. prompt.AsUserWithURI(file.Mime, file.URI). It is a gs:// uri for
+	// VertexAI, and a bellman-file:// uri of the id for providers that refer to files by id, see URI.
+	URI string `json:"uri"`
+}
+
+type UploadRequest struct {
+	Ctx  context.Context `json:"-"`
+	Name string          `json:"name"`
+	Mime string          `json:"mime_type"`
+
+	// Reader is the content of the file, which is streamed to the provider, so large files are not held in memory.
+	Reader io.Reader `json:"-"`
+}
+
+func NewUploadRequest(ctx context.Context, name string, mime string, reader io.Reader) *UploadRequest {
+	return &UploadRequest{
+		Ctx:    ctx,
+		Name:   name,
+		Mime:   mime,
+		Reader: reader,
+	}
+}
+
+// Context returns the context of the request, or context.Background if it has none.
+func (r *UploadRequest) Context() context.Context {
+	if r.Ctx == nil {
+		return context.Background()
+	}
+	return r.Ctx
+}
+
+// Scheme is the scheme of URIs of files that providers refer to by id, eg. OpenAI and Anthropic.
+const Scheme = "bellman-file"
+
+// URI returns the uri of the file with id, uploaded to provider, eg. bellman-file://OpenAI/file-abc123.
+func URI(provider string, id string) string {
+	return Scheme + "://" + provider + "/" + id
+}
+
+// ParseURI returns the provider and id of a uri returned by URI. It is false for any other uri, eg. https:// or gs://
+// uris, which are passed on to the provider as they are.
+func ParseURI(uri string) (provider string, id string, ok bool) {
+	rest, found := strings.CutPrefix(uri, Scheme+"://")
+	if !found {
+		return "", "", false
+	}
+	provider, id, found = strings.Cut(rest, "/")
+	if !found || provider == "" || id == "" {
+		return "", "", false
+	}
+	return provider, id, true
+}
@@ -0,0 +1,76 @@
+package gen
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/modfin/bellman/prompt"
+)
+
+var ErrUnsupported = errors.New("unsupported by model")
+
+// CapabilityError tells which part of a request the model does not support, according to its Model metadata.
+type CapabilityError struct {
+	Model      string
+	Capability string
+	Detail     string
+}
+
+func (e *CapabilityError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("model %s does not support %s", e.Model, e.Capability)
+	}
+	return fmt.Sprintf("model %s does not support %s, %s", e.Model, e.Capability, e.Detail)
+}
+
+func (e *CapabilityError) Unwrap() error {
+	return ErrUnsupported
+}
+
+// declared is true if the model carries any capability metadata. A model only defined by provider and name, eg. a
+// custom model, is not validated.
+func (m Model) declared() bool {
+	return m.SupportTools || m.SupportStructuredOutput || len(m.InputContentTypes) > 0 || m.TextOnly ||
+		m.InputMaxToken > 0 || m.OutputMaxToken > 0
+}
+
+// Validate checks the request, and prompts, against the capabilities declared by the model, ie. SupportTools,
+// SupportStructuredOutput, InputContentTypes, TextOnly and OutputMaxToken. All problems are returned, joined, as
+// *CapabilityError, matching ErrUnsupported with errors.Is. Models without any capability metadata are not checked.
+func (r Request) Validate(prompts ...prompt.Prompt) error {
+	m := r.Model
+	if !m.declared() {
+		return nil
+	}
+
+	var errs []error
+	if len(r.Tools) > 0 && !m.SupportTools && !r.EmulateTools {
+		errs = append(errs, &CapabilityError{Model: m.FQN(), Capability: "tools"})
+	}
+	if r.OutputSchema != nil && !m.SupportStructuredOutput && !r.EmulateStructuredOutput {
+		errs = append(errs, &CapabilityError{Model: m.FQN(), Capability: "structured output"})
+	}
+	if r.MaxTokens != nil && m.OutputMaxToken > 0 && *r.MaxTokens > m.OutputMaxToken {
+		errs = append(errs, &CapabilityError{
+			Model:      m.FQN(),
+			Capability: "max tokens",
+			Detail:     fmt.Sprintf("got %d, the limit is %d", *r.MaxTokens, m.OutputMaxToken),
+		})
+	}
+	if len(m.InputContentTypes) > 0 || m.TextOnly {
+		seen := map[string]bool{}
+		for i, p := range prompts {
+			if p.Payload == nil || seen[p.Payload.Mime] || slices.Contains(m.InputContentTypes, p.Payload.Mime) {
+				continue
+			}
+			seen[p.Payload.Mime] = true
+			errs = append(errs, &CapabilityError{
+				Model:      m.FQN(),
+				Capability: "input content type " + p.Payload.Mime,
+				Detail:     fmt.Sprintf("in prompt %d", i),
+			})
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,85 @@
+package gen
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/schema"
+	"github.com/modfin/bellman/tools"
+)
+
+func TestRequestValidate(t *testing.T) {
+	model := Model{
+		Provider:          "test",
+		Name:              "small",
+		InputContentTypes: []string{prompt.MimeImagePNG},
+		OutputMaxToken:    100,
+	}
+
+	err := Request{Model: Model{Provider: "test", Name: "custom"}, Tools: []tools.Tool{{Name: "a"}}}.Validate()
+	if err != nil {
+		t.Fatalf("expected models without metadata to pass, got %v", err)
+	}
+
+	err = Request{Model: model}.Validate(prompt.AsUser("hello"), prompt.AsUserWithData(prompt.MimeImagePNG, []byte{1}))
+	if err != nil {
+		t.Fatalf("expected valid request, got %v", err)
+	}
+
+	err = Request{
+		Model:        model,
+		Tools:        []tools.Tool{{Name: "a"}},
+		OutputSchema: &schema.JSON{Type: schema.Object},
+		MaxTokens:    new(200),
+	}.Validate(prompt.AsUserWithData(prompt.MimeApplicationPDF, []byte{1}))
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	var capabilities []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var ce *CapabilityError
+		if !errors.As(e, &ce) {
+			t.Fatalf("expected CapabilityError, got %T", e)
+		}
+		capabilities = append(capabilities, ce.Capability)
+	}
+	expected := []string{"tools", "structured output", "max tokens", "input content type " + prompt.MimeApplicationPDF}
+	if len(capabilities) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, capabilities)
+	}
+	for i := range expected {
+		if capabilities[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, capabilities)
+		}
+	}
+}
+
+func TestRequestValidateTextOnly(t *testing.T) {
+	model := Model{Provider: "test", Name: "text", TextOnly: true}
+	err := Request{Model: model}.Validate(prompt.AsUser("hello"))
+	if err != nil {
+		t.Fatalf("expected text to pass, got %v", err)
+	}
+	err = Request{Model: model}.Validate(prompt.AsUserWithData(prompt.MimeImagePNG, []byte{1}))
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported for an image, got %v", err)
+	}
+}
+
+func TestCheckConstraints(t *testing.T) {
+	r := Request{Model: Model{Provider: "test", Name: "local"}, Choices: []string{"yes", "no"}}
+	if err := r.CheckConstraints(ConstraintChoices); err != nil {
+		t.Fatalf("expected supported constraint, got %v", err)
+	}
+	if err := r.CheckConstraints(ConstraintRegex); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	r.OutputSchema = &schema.JSON{Type: schema.String}
+	if err := r.CheckConstraints(ConstraintChoices); err == nil || errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected error for both output schema and choices, got %v", err)
+	}
+	if err := (Request{}).CheckConstraints(); err != nil {
+		t.Fatalf("expected no error without constraints, got %v", err)
+	}
+}
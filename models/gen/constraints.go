@@ -0,0 +1,52 @@
+package gen
+
+import (
+	"errors"
+	"slices"
+)
+
+// Constrained decoding, ie. restricting the output of the model to a regex, a list of choices or a grammar.
+const (
+	ConstraintRegex   = "regex"
+	ConstraintChoices = "choices"
+	ConstraintGrammar = "grammar"
+)
+
+// Constraints returns the constrained decoding fields set on the request, eg. ConstraintRegex.
+func (r Request) Constraints() []string {
+	var constraints []string
+	if r.Regex != "" {
+		constraints = append(constraints, ConstraintRegex)
+	}
+	if len(r.Choices) > 0 {
+		constraints = append(constraints, ConstraintChoices)
+	}
+	if r.Grammar != "" {
+		constraints = append(constraints, ConstraintGrammar)
+	}
+	return constraints
+}
+
+// CheckConstraints is used by prompters to reject requests with constrained decoding they do not support. A
+// *CapabilityError, matching ErrUnsupported, is returned for every constraint not in supported.
+func (r Request) CheckConstraints(supported ...string) error {
+	constraints := r.Constraints()
+	if len(constraints) == 0 {
+		return nil
+	}
+	if len(constraints) > 1 || r.OutputSchema != nil {
+		return errors.New("only one of output schema, regex, choices and grammar can be set")
+	}
+
+	var errs []error
+	for _, c := range constraints {
+		if !slices.Contains(supported, c) {
+			errs = append(errs, &CapabilityError{
+				Model:      r.Model.FQN(),
+				Capability: "constrained decoding",
+				Detail:     c + " is not supported by the provider",
+			})
+		}
+	}
+	return errors.Join(errs...)
+}
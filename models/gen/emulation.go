@@ -0,0 +1,423 @@
+package gen
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/schema"
+	"github.com/modfin/bellman/tools"
+)
+
+// toolEnvelope is the json reply a model without native tool support is instructed to give, when tools are emulated.
+type toolEnvelope struct {
+	Content   json.RawMessage `json:"content,omitempty"`
+	ToolCalls []envelopeCall  `json:"tool_calls,omitempty"`
+}
+
+type envelopeCall struct {
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type envelopeResult struct {
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"name"`
+	Result string `json:"result"`
+}
+
+const toolResultsPrefix = "Results of the tool calls:\n"
+
+// emulatesTools is true if tools are described in the prompt, rather than sent to the provider.
+func (r Request) emulatesTools() bool {
+	return r.EmulateTools && !r.Model.SupportTools
+}
+
+// emulateTools returns the request and prompts to send to a model without native tool support. The tools are
+// described in the system prompt together with the reply envelope, and tool calls and responses of earlier turns
+// are rewritten as plain assistant and user prompts.
+func emulateTools(r Request, prompts []prompt.Prompt) (Request, []prompt.Prompt) {
+	sent := r
+	sent.Tools = nil
+	sent.ToolConfig = nil
+	sent.EmulateTools = false
+
+	if len(r.Tools) > 0 && (r.ToolConfig == nil || *r.ToolConfig != tools.NoTool) {
+		instructions := toolInstructions(r)
+		if sent.SystemPrompt != "" {
+			instructions = sent.SystemPrompt + "\n\n" + instructions
+		}
+		sent.SystemPrompt = instructions
+		if r.Model.SupportStructuredOutput {
+			sent.OutputSchema = envelopeSchema(r)
+		} else {
+			sent.OutputSchema = nil
+		}
+	}
+
+	return sent, toolHistory(prompts)
+}
+
+func toolInstructions(r Request) string {
+	var sb strings.Builder
+	sb.WriteString("You have access to the following tools:\n")
+	for _, t := range r.Tools {
+		sb.WriteString("\n- " + t.Name)
+		if t.Description != "" {
+			sb.WriteString(": " + t.Description)
+		}
+		args := []byte("{}")
+		if t.ArgumentSchema != nil {
+			args, _ = json.Marshal(t.ArgumentSchema)
+		}
+		sb.WriteString("\n  arguments json schema: " + string(args) + "\n")
+	}
+
+	sb.WriteString("\nAlways reply with a single json object, and nothing else, on the form\n")
+	sb.WriteString(`{"content": <your answer>, "tool_calls": [{"name": "<tool name>", "arguments": {<arguments following the schema of the tool>}}]}`)
+	sb.WriteString("\nTo call tools, leave out content and list one or more calls in tool_calls. ")
+	sb.WriteString("The results of the calls are given to you in the next message. ")
+	sb.WriteString("To answer, leave out tool_calls.")
+	if r.OutputSchema != nil {
+		s, _ := json.Marshal(r.OutputSchema)
+		sb.WriteString(" The answer in content must be json following the schema: " + string(s))
+	} else {
+		sb.WriteString(" The answer in content must be a json string.")
+	}
+
+	switch {
+	case r.ToolConfig == nil || *r.ToolConfig == tools.AutoTool:
+	case *r.ToolConfig == tools.RequiredTool:
+		sb.WriteString("\nYou must call at least one tool.")
+	default:
+		sb.WriteString("\nYou must call the tool " + r.ToolConfig.Name + ".")
+	}
+	return sb.String()
+}
+
+// envelopeSchema is the schema of toolEnvelope, used to constrain the output of models with structured output.
+func envelopeSchema(r Request) *schema.JSON {
+	var names []interface{}
+	for _, t := range r.Tools {
+		if r.ToolConfig == nil || slices.Contains(tools.ControlTools, *r.ToolConfig) || r.ToolConfig.Name == t.Name {
+			names = append(names, t.Name)
+		}
+	}
+	content := r.OutputSchema
+	if content == nil {
+		content = &schema.JSON{Type: schema.String}
+	}
+	return &schema.JSON{
+		Type: schema.Object,
+		Properties: map[string]*schema.JSON{
+			"content": content,
+			"tool_calls": {
+				Type: schema.Array,
+				Items: &schema.JSON{
+					Type: schema.Object,
+					Properties: map[string]*schema.JSON{
+						"name":      {Type: schema.String, Enum: names},
+						"arguments": {Type: schema.Object},
+					},
+					Required: []string{"name", "arguments"},
+				},
+			},
+		},
+	}
+}
+
+// toolHistory rewrites tool calls as assistant prompts holding the envelope, and tool responses as user prompts.
+func toolHistory(prompts []prompt.Prompt) []prompt.Prompt {
+	var res []prompt.Prompt
+	for i := 0; i < len(prompts); i++ {
+		p := prompts[i]
+		switch p.Role {
+		case prompt.ToolCallRole:
+			var env toolEnvelope
+			if n := len(res); n > 0 && res[n-1].Role == prompt.AssistantRole {
+				env.Content, _ = json.Marshal(res[n-1].Text)
+				res = res[:n-1]
+			}
+			for ; i < len(prompts) && prompts[i].Role == prompt.ToolCallRole; i++ {
+				call := prompts[i].ToolCall
+				args := json.RawMessage(call.Arguments)
+				if len(args) == 0 {
+					args = json.RawMessage("{}")
+				}
+				env.ToolCalls = append(env.ToolCalls, envelopeCall{ID: call.ToolCallID, Name: call.Name, Arguments: args})
+			}
+			i--
+			b, _ := json.Marshal(env)
+			res = append(res, prompt.AsAssistant(string(b)))
+		case prompt.ToolResponseRole:
+			var results []envelopeResult
+			for ; i < len(prompts) && prompts[i].Role == prompt.ToolResponseRole; i++ {
+				resp := prompts[i].ToolResponse
+				results = append(results, envelopeResult{ID: resp.ToolCallID, Name: resp.Name, Result: resp.Response})
+			}
+			i--
+			b, _ := json.Marshal(results)
+			res = append(res, prompt.AsUser(toolResultsPrefix+string(b)))
+		default:
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// parseToolEnvelope turns the reply of a model with emulated tools into a response with tool calls, as if the model
+// had called them natively. Replies that are not an envelope are returned as text, see checkToolChoice.
+func parseToolEnvelope(r Request, resp *Response) *Response {
+	text := strings.Join(resp.Texts, "")
+	raw, ok := ExtractJSON(text)
+	if !ok {
+		return resp
+	}
+	var env toolEnvelope
+	err := json.Unmarshal([]byte(raw), &env)
+	if err != nil || (env.Content == nil && env.ToolCalls == nil) {
+		return resp
+	}
+
+	res := &Response{
+		Thinking: resp.Thinking,
+		Metadata: resp.Metadata,
+	}
+	for _, p := range resp.Turn {
+		if p.Role == prompt.ThinkingRole {
+			res.Turn = append(res.Turn, p)
+		}
+	}
+
+	if content := envelopeContent(env.Content); content != "" {
+		res.Texts = []string{content}
+		res.Turn = append(res.Turn, prompt.AsAssistant(content))
+	}
+	for _, c := range env.ToolCalls {
+		args := []byte(c.Arguments)
+		if len(args) == 0 || string(args) == "null" {
+			args = []byte("{}")
+		}
+		call := tools.Call{ID: newCallID(), Name: c.Name, Argument: args}
+		for i := range r.Tools {
+			if r.Tools[i].Name == c.Name {
+				call.Ref = &r.Tools[i]
+				break
+			}
+		}
+		res.Tools = append(res.Tools, call)
+		res.Turn = append(res.Turn, prompt.AsToolCall(call.ID, call.Name, call.Argument))
+	}
+	return res
+}
+
+// envelopeContent returns the answer of an envelope, json strings are unquoted.
+func envelopeContent(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func newCallID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "call_" + hex.EncodeToString(b)
+}
+
+// ExtractJSON returns the first json object, or array, in text, eg. in a fenced ```json block or surrounded by prose.
+func ExtractJSON(text string) (string, bool) {
+	if start := strings.Index(text, "```"); start >= 0 {
+		block := text[start+3:]
+		if nl := strings.IndexByte(block, '\n'); nl >= 0 && !strings.ContainsAny(block[:nl], "{[") {
+			block = block[nl+1:]
+		}
+		if end := strings.Index(block, "```"); end >= 0 {
+			block = strings.TrimSpace(block[:end])
+			if json.Valid([]byte(block)) {
+				return block, true
+			}
+		}
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] != '{' && text[i] != '[' {
+			continue
+		}
+		var raw json.RawMessage
+		err := json.NewDecoder(strings.NewReader(text[i:])).Decode(&raw)
+		if err == nil {
+			return string(raw), true
+		}
+	}
+	return "", false
+}
+
+// promptEmulatedTools prompts a model without native tool support, see Request.EmulateTools.
+func promptEmulatedTools(prompter Prompter, r Request, prompts []prompt.Prompt) (*Response, error) {
+	sent, sentPrompts := emulateTools(r, prompts)
+	sent.Stream = false
+	if len(r.Tools) == 0 || (r.ToolConfig != nil && *r.ToolConfig == tools.NoTool) {
+		if r.emulatesOutput() {
+			return promptEmulatedOutput(prompter, sent, sentPrompts, r.OutputSchema)
+		}
+		prompter.SetRequest(sent)
+		return prompter.Prompt(sentPrompts...)
+	}
+	return promptChecked(prompter, sent, sentPrompts, func(resp *Response) (*Response, error) {
+		res := parseToolEnvelope(r, resp)
+		err := checkToolChoice(r, res)
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Tools) == 0 && r.emulatesOutput() {
+			return checkOutput(res, r.OutputSchema)
+		}
+		return res, nil
+	})
+}
+
+// checkToolChoice returns an error if the ToolConfig of r requires a tool call, and it is missing from res, eg. when
+// the reply was not an envelope.
+func checkToolChoice(r Request, res *Response) error {
+	if r.ToolConfig == nil || *r.ToolConfig == tools.AutoTool || *r.ToolConfig == tools.NoTool {
+		return nil
+	}
+	if *r.ToolConfig == tools.RequiredTool {
+		if len(res.Tools) == 0 {
+			return errors.New("no tool was called, at least one tool must be called")
+		}
+		return nil
+	}
+	for _, call := range res.Tools {
+		if call.Name == r.ToolConfig.Name {
+			return nil
+		}
+	}
+	return fmt.Errorf("the tool %s was not called", r.ToolConfig.Name)
+}
+
+const emulatedOutputRetries = 2
+
+// emulatesOutput is true if the output schema is described in the prompt, rather than sent to the provider.
+func (r Request) emulatesOutput() bool {
+	return r.EmulateStructuredOutput && !r.Model.SupportStructuredOutput && r.OutputSchema != nil
+}
+
+func outputInstructions(s *schema.JSON) string {
+	b, _ := json.Marshal(s)
+	return "Reply with json following the json schema below, and nothing else.\n" + string(b)
+}
+
+// promptEmulatedOutput prompts a model without native structured output, see Request.EmulateStructuredOutput.
+func promptEmulatedOutput(prompter Prompter, r Request, prompts []prompt.Prompt, output *schema.JSON) (*Response, error) {
+	sent := r
+	sent.Stream = false
+	sent.OutputSchema = nil
+	sent.StrictOutput = false
+	sent.EmulateStructuredOutput = false
+	sent.SystemPrompt = outputInstructions(output)
+	if r.SystemPrompt != "" {
+		sent.SystemPrompt = r.SystemPrompt + "\n\n" + sent.SystemPrompt
+	}
+	return promptChecked(prompter, sent, prompts, func(resp *Response) (*Response, error) {
+		return checkOutput(resp, output)
+	})
+}
+
+// checkOutput extracts the json of resp and validates it against output. The json replaces the text of resp, so
+// that Response.Unmarshal works.
+func checkOutput(resp *Response, output *schema.JSON) (*Response, error) {
+	raw, ok := ExtractJSON(strings.Join(resp.Texts, ""))
+	if !ok {
+		return nil, errors.New("the reply holds no json")
+	}
+	err := output.Validate([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	res := *resp
+	res.Texts = []string{raw}
+	res.Turn = nil
+	for _, p := range resp.Turn {
+		if p.Role != prompt.AssistantRole {
+			res.Turn = append(res.Turn, p)
+		}
+	}
+	res.Turn = append(res.Turn, prompt.AsAssistant(raw))
+	return &res, nil
+}
+
+// promptChecked prompts until check accepts the response, at most emulatedOutputRetries times more. The rejected
+// reply is added to the conversation, together with what was wrong with it, before the next attempt. The usage of
+// all attempts is added up.
+func promptChecked(prompter Prompter, sent Request, prompts []prompt.Prompt, check func(*Response) (*Response, error)) (*Response, error) {
+	var usage models.Metadata
+	for attempt := 0; ; attempt++ {
+		prompter.SetRequest(sent)
+		resp, err := prompter.Prompt(prompts...)
+		if err != nil {
+			return nil, err
+		}
+		usage.Model = resp.Metadata.Model
+		usage.Other = resp.Metadata.Other
+		usage.InputTokens += resp.Metadata.InputTokens
+		usage.ThinkingTokens += resp.Metadata.ThinkingTokens
+		usage.OutputTokens += resp.Metadata.OutputTokens
+		usage.TotalTokens += resp.Metadata.TotalTokens
+
+		res, err := check(resp)
+		if err == nil {
+			res.Metadata = usage
+			return res, nil
+		}
+		if attempt >= emulatedOutputRetries {
+			return nil, fmt.Errorf("could not get valid output after %d attempts, %w", attempt+1, err)
+		}
+		prompts = append(slices.Clone(prompts),
+			prompt.AsAssistant(strings.Join(resp.Texts, "")),
+			prompt.AsUser(fmt.Sprintf("The reply is not valid, %v. Reply again, following the instructions.", err)),
+		)
+	}
+}
+
+// replay streams a complete response, for prompts that can not be streamed, eg. when tools are emulated.
+func replay(resp *Response) <-chan *StreamResponse {
+	stream := make(chan *StreamResponse, len(resp.Turn)+len(resp.Thinking)+len(resp.Texts)+2)
+	for _, t := range resp.Thinking {
+		stream <- &StreamResponse{Type: TYPE_THINKING_DELTA, Role: prompt.ThinkingRole, Content: t}
+	}
+	for _, t := range resp.Texts {
+		stream <- &StreamResponse{Type: TYPE_DELTA, Role: prompt.AssistantRole, Content: t}
+	}
+	for i, p := range resp.Turn {
+		block := p
+		e := &StreamResponse{Type: TYPE_BLOCK, Role: p.Role, Index: i, Block: &block}
+		if p.ToolCall != nil {
+			for _, c := range resp.Tools {
+				if c.ID == p.ToolCall.ToolCallID {
+					call := c
+					e.ToolCall = &call
+				}
+			}
+		}
+		stream <- e
+	}
+	metadata := resp.Metadata
+	stream <- &StreamResponse{Type: TYPE_METADATA, Metadata: &metadata}
+	stream <- &StreamResponse{Type: TYPE_EOF}
+	close(stream)
+	return stream
+}
@@ -0,0 +1,162 @@
+package gen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/schema"
+	"github.com/modfin/bellman/tools"
+)
+
+// replier replies with canned texts, and records the requests it gets.
+type replier struct {
+	replies  []string
+	requests []Request
+	prompts  [][]prompt.Prompt
+	request  Request
+}
+
+func (r *replier) SetRequest(request Request) { r.request = request }
+
+func (r *replier) Prompt(prompts ...prompt.Prompt) (*Response, error) {
+	r.requests = append(r.requests, r.request)
+	r.prompts = append(r.prompts, prompts)
+	text := r.replies[0]
+	r.replies = r.replies[1:]
+	return &Response{Texts: []string{text}, Turn: []prompt.Prompt{prompt.AsAssistant(text)}}, nil
+}
+
+func (r *replier) Stream(prompts ...prompt.Prompt) (<-chan *StreamResponse, error) {
+	resp, err := r.Prompt(prompts...)
+	if err != nil {
+		return nil, err
+	}
+	return replay(resp), nil
+}
+
+func TestEmulateTools(t *testing.T) {
+	type Args struct {
+		Symbol string `json:"symbol"`
+	}
+	quote := tools.NewTool("get_quote", tools.WithDescription("get the quote of a stock"), tools.WithArgSchema(Args{}))
+
+	p := &replier{replies: []string{
+		"Sure!\n```json\n{\"tool_calls\": [{\"name\": \"get_quote\", \"arguments\": {\"symbol\": \"AAPL\"}}]}\n```",
+		`{"content": "AAPL is at 100"}`,
+	}}
+	g := (&Generator{Prompter: p}).
+		Model(Model{Provider: "test", Name: "local"}).
+		System("You are a stock bot").
+		SetTools(quote).
+		EmulateTools(true)
+
+	conversation := []prompt.Prompt{prompt.AsUser("What is the price of AAPL?")}
+	resp, err := g.Prompt(conversation...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := p.requests[0]
+	if len(sent.Tools) != 0 || !strings.HasPrefix(sent.SystemPrompt, "You are a stock bot\n\n") ||
+		!strings.Contains(sent.SystemPrompt, "get_quote: get the quote of a stock") {
+		t.Fatalf("expected tools in the system prompt only, got %+v", sent)
+	}
+	if len(resp.Tools) != 1 || resp.Tools[0].Ref == nil || resp.Tools[0].ID == "" ||
+		string(resp.Tools[0].Argument) != `{"symbol": "AAPL"}` {
+		t.Fatalf("expected a call to get_quote, got %+v", resp.Tools)
+	}
+	if len(resp.Turn) != 1 || resp.Turn[0].Role != prompt.ToolCallRole {
+		t.Fatalf("expected a tool call turn, got %+v", resp.Turn)
+	}
+
+	call := resp.Tools[0]
+	conversation = append(conversation, resp.Turn...)
+	conversation = append(conversation, prompt.AsToolResponse(call.ID, call.Name, "100"))
+	stream, err := g.Stream(conversation...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text string
+	for r := range stream {
+		if r.Type == TYPE_DELTA {
+			text += r.Content
+		}
+	}
+	if text != "AAPL is at 100" {
+		t.Fatalf("expected the content of the envelope, got %q", text)
+	}
+
+	history := p.prompts[1]
+	if len(history) != 3 || history[1].Role != prompt.AssistantRole || history[2].Role != prompt.UserRole ||
+		!strings.Contains(history[1].Text, `"name":"get_quote"`) || !strings.HasPrefix(history[2].Text, toolResultsPrefix) {
+		t.Fatalf("expected tool calls and responses as text, got %+v", history)
+	}
+}
+
+func TestEmulateRequiredTool(t *testing.T) {
+	quote := tools.NewTool("get_quote", tools.WithDescription("get the quote of a stock"))
+
+	p := &replier{replies: []string{
+		"AAPL is at 100",
+		`{"tool_calls": [{"name": "get_quote", "arguments": {}}]}`,
+	}}
+	g := (&Generator{Prompter: p}).
+		Model(Model{Provider: "test", Name: "local"}).
+		SetTools(quote).
+		SetToolConfig(tools.ToolChoice{Name: "get_quote"}).
+		EmulateTools(true)
+
+	resp, err := g.Prompt(prompt.AsUser("What is the price of AAPL?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.requests) != 2 || len(resp.Tools) != 1 || resp.Tools[0].Name != "get_quote" {
+		t.Fatalf("expected a retry until the tool was called, got %d requests and %+v", len(p.requests), resp)
+	}
+
+	p = &replier{replies: []string{"100", "100", "100"}}
+	g = (&Generator{Prompter: p}).
+		Model(Model{Provider: "test", Name: "local"}).
+		SetTools(quote).
+		SetToolConfig(tools.RequiredTool).
+		EmulateTools(true)
+	_, err = g.Prompt(prompt.AsUser("What is the price of AAPL?"))
+	if err == nil {
+		t.Fatal("expected an error when no tool is called")
+	}
+}
+
+func TestEmulateStructuredOutput(t *testing.T) {
+	type Quote struct {
+		Symbol string  `json:"symbol"`
+		Price  float64 `json:"price" json-minimum:"0"`
+	}
+
+	p := &replier{replies: []string{
+		`The price is {"symbol": "AAPL", "price": -1}`,
+		"Here you go:\n```json\n{\"symbol\": \"AAPL\", \"price\": 100}\n```",
+	}}
+	resp, err := (&Generator{Prompter: p}).
+		Model(Model{Provider: "test", Name: "local"}).
+		Output(schema.From(Quote{})).
+		EmulateStructuredOutput(true).
+		Prompt(prompt.AsUser("What is the price of AAPL?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.requests[0].OutputSchema != nil || !strings.Contains(p.requests[0].SystemPrompt, `"price"`) {
+		t.Fatalf("expected the schema in the system prompt only, got %+v", p.requests[0])
+	}
+	if len(p.prompts[1]) != 3 || !strings.Contains(p.prompts[1][2].Text, "less than the minimum") {
+		t.Fatalf("expected a retry with the validation error, got %+v", p.prompts[1])
+	}
+
+	var quote Quote
+	err = resp.Unmarshal(&quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Symbol != "AAPL" || quote.Price != 100 {
+		t.Fatalf("unexpected quote %+v", quote)
+	}
+}
@@ -32,6 +32,19 @@ func (b *Generator) Stream(prompts ...prompt.Prompt) (<-chan *StreamResponse, er
 	}
 	r := b.clone().Request
 	r.Stream = true
+	if r.ValidateCapabilities {
+		err := r.Validate(prompts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.emulatesTools() || r.emulatesOutput() {
+		resp, err := b.clone().Prompt(prompts...)
+		if err != nil {
+			return nil, err
+		}
+		return replay(resp), nil
+	}
 	prompter.SetRequest(r)
 	return prompter.Stream(prompts...)
 }
@@ -41,7 +54,20 @@ func (b *Generator) Prompt(prompts ...prompt.Prompt) (*Response, error) {
 	if prompter == nil {
 		return nil, errors.New("prompter is required")
 	}
-	prompter.SetRequest(b.clone().Request)
+	r := b.clone().Request
+	if r.ValidateCapabilities {
+		err := r.Validate(prompts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.emulatesTools() {
+		return promptEmulatedTools(prompter, r, prompts)
+	}
+	if r.emulatesOutput() {
+		return promptEmulatedOutput(prompter, r, prompts, r.OutputSchema)
+	}
+	prompter.SetRequest(r)
 	return prompter.Prompt(prompts...)
 }
 
@@ -97,6 +123,9 @@ func (b *Generator) clone() *Generator {
 	if b.Request.StopSequences != nil {
 		bb.Request.StopSequences = append([]string{}, b.Request.StopSequences...)
 	}
+	if b.Request.Choices != nil {
+		bb.Request.Choices = append([]string{}, b.Request.Choices...)
+	}
 
 	return &bb
 }
@@ -211,6 +240,60 @@ func (b *Generator) IncludeThinkingParts(thinkingParts bool) *Generator {
 	return bb
 }
 
+// Regex constrains the output of the model to match pattern, see Request.Regex.
+func (b *Generator) Regex(pattern string) *Generator {
+	bb := b.clone()
+	bb.Request.Regex = pattern
+
+	return bb
+}
+
+// Choices constrains the output of the model to exactly one of choices, see Request.Choices.
+func (b *Generator) Choices(choices ...string) *Generator {
+	bb := b.clone()
+	bb.Request.Choices = choices
+
+	return bb
+}
+
+// Grammar constrains the output of the model to an EBNF, or GBNF, grammar, see Request.Grammar.
+func (b *Generator) Grammar(grammar string) *Generator {
+	bb := b.clone()
+	bb.Request.Grammar = grammar
+
+	return bb
+}
+
+// ValidateCapabilities makes Prompt and Stream check the request against the capabilities of the model, returning
+// a *CapabilityError before anything is sent to the provider.
+func (b *Generator) ValidateCapabilities(validate bool) *Generator {
+	bb := b.clone()
+	bb.Request.ValidateCapabilities = validate
+
+	return bb
+}
+
+// EmulateTools lets models without native tool support, ie. where Model.SupportTools is false, use tools. The tools
+// are described in the system prompt, and the model is instructed to reply with a json envelope that is parsed into
+// Response.Tools, as if the tools were called natively. Streaming is emulated by replaying the whole response.
+func (b *Generator) EmulateTools(emulate bool) *Generator {
+	bb := b.clone()
+	bb.Request.EmulateTools = emulate
+
+	return bb
+}
+
+// EmulateStructuredOutput lets models without native structured output, ie. where Model.SupportStructuredOutput is
+// false, use an output schema. The schema is described in the system prompt, and the json of the reply, fenced or
+// surrounded by text, is validated against it. Invalid replies are retried, telling the model what was wrong. The
+// clean json is returned in Response.Texts, so that Response.Unmarshal works.
+func (b *Generator) EmulateStructuredOutput(emulate bool) *Generator {
+	bb := b.clone()
+	bb.Request.EmulateStructuredOutput = emulate
+
+	return bb
+}
+
 type Option func(generator *Generator) *Generator
 
 func WithRequest(req Request) Option {
@@ -308,3 +391,39 @@ func WithThinkingParts(thinkingParts bool) Option {
 		return g.IncludeThinkingParts(thinkingParts)
 	}
 }
+
+func WithValidateCapabilities(validate bool) Option {
+	return func(g *Generator) *Generator {
+		return g.ValidateCapabilities(validate)
+	}
+}
+
+func WithEmulateTools(emulate bool) Option {
+	return func(g *Generator) *Generator {
+		return g.EmulateTools(emulate)
+	}
+}
+
+func WithEmulateStructuredOutput(emulate bool) Option {
+	return func(g *Generator) *Generator {
+		return g.EmulateStructuredOutput(emulate)
+	}
+}
+
+func WithRegex(pattern string) Option {
+	return func(g *Generator) *Generator {
+		return g.Regex(pattern)
+	}
+}
+
+func WithChoices(choices ...string) Option {
+	return func(g *Generator) *Generator {
+		return g.Choices(choices...)
+	}
+}
+
+func WithGrammar(grammar string) Option {
+	return func(g *Generator) *Generator {
+		return g.Grammar(grammar)
+	}
+}
@@ -25,6 +25,9 @@ type Model struct {
 	Description string `json:"description,omitempty"`
 
 	InputContentTypes []string `json:"input_content_types,omitempty"`
+	// TextOnly declares that the model takes no payloads, eg. images, since an empty InputContentTypes means that
+	// the content types are not known.
+	TextOnly bool `json:"text_only,omitempty"`
 
 	InputMaxToken  int `json:"input_max_token,omitempty"`
 	OutputMaxToken int `json:"output_max_token,omitempty"`
@@ -31,6 +31,24 @@ type Request struct {
 	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
 	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
 	StopSequences    []string `json:"stop_sequences,omitempty"`
+
+	// Regex, Choices and Grammar constrains the output of the model, for providers with constrained decoding, eg. vLLM
+	// and Ollama. Only one of them, or OutputSchema, can be set. Other providers return ErrUnsupported.
+	Regex   string   `json:"regex,omitempty"`
+	Choices []string `json:"choices,omitempty"`
+	Grammar string   `json:"grammar,omitempty"` // EBNF, or GBNF, grammar
+
+	// ValidateCapabilities makes Generator.Prompt and Generator.Stream check the request against the capabilities
+	// of the model before it is sent, see Request.Validate.
+	ValidateCapabilities bool `json:"validate_capabilities,omitempty"`
+
+	// EmulateTools describes Tools in the system prompt of models that does not SupportTools, and parses the tool
+	// calls out of the reply, see Generator.EmulateTools.
+	EmulateTools bool `json:"emulate_tools,omitempty"`
+
+	// EmulateStructuredOutput describes OutputSchema in the system prompt of models that does not
+	// SupportStructuredOutput, and validates the json of the reply, see Generator.EmulateStructuredOutput.
+	EmulateStructuredOutput bool `json:"emulate_structured_output,omitempty"`
 }
 
 type FullRequest struct {
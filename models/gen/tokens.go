@@ -0,0 +1,172 @@
+package gen
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/modfin/bellman/prompt"
+)
+
+// TokenCounter is implemented by prompters that can count the input tokens of a request, ie. the prompts together
+// with the system prompt, tools and output schema, without running it.
+type TokenCounter interface {
+	CountTokens(prompts ...prompt.Prompt) (*TokenCount, error)
+}
+
+// TokenCount is the number of input tokens of a request, and the response of the token counting endpoint of bellmand.
+type TokenCount struct {
+	InputTokens int `json:"input_tokens"`
+	// Estimated is set when InputTokens is an offline estimate, see EstimateTokens, rather than counted by the
+	// provider. Estimates may be off by tens of percent.
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// CountTokens returns the number of input tokens a prompt with prompts would use. Prompters implementing
+// TokenCounter count them with the provider, eg. Anthropic count_tokens or Vertex countTokens, others are estimated
+// offline with EstimateTokens, and marked as TokenCount.Estimated.
+func (b *Generator) CountTokens(prompts ...prompt.Prompt) (*TokenCount, error) {
+	prompter := b.Prompter
+	if prompter == nil {
+		return nil, errors.New("prompter is required")
+	}
+	counter, ok := prompter.(TokenCounter)
+	if !ok {
+		return &TokenCount{InputTokens: EstimateTokens(b.Request, prompts...), Estimated: true}, nil
+	}
+	prompter.SetRequest(b.clone().Request)
+	return counter.CountTokens(prompts...)
+}
+
+// Rough token cost of things that are not text. Providers count media by dimensions, pages or duration, which is
+// not known offline.
+const (
+	tokensPerMessage = 4
+	tokensPerTool    = 8
+	tokensPerImage   = 765
+	tokensPerMedia   = 1000 // media only referenced by uri
+)
+
+// EstimateTokens estimates the input tokens of request and prompts offline. It is a heuristic, not a tokenizer: it
+// splits text like a BPE tokenizer splits it before merging, eg. the cl100k and o200k encodings of OpenAI, and
+// guesses the number of tokens per piece. Use Generator.CountTokens for exact counts where the provider supports it.
+func EstimateTokens(request Request, prompts ...prompt.Prompt) int {
+	tokens := 0
+	if request.SystemPrompt != "" {
+		tokens += tokensPerMessage + EstimateTextTokens(request.SystemPrompt)
+	}
+	for _, t := range request.Tools {
+		tokens += tokensPerTool + EstimateTextTokens(t.Name) + EstimateTextTokens(t.Description)
+		if t.ArgumentSchema != nil {
+			b, _ := json.Marshal(t.ArgumentSchema)
+			tokens += EstimateTextTokens(string(b))
+		}
+	}
+	if request.OutputSchema != nil {
+		b, _ := json.Marshal(request.OutputSchema)
+		tokens += EstimateTextTokens(string(b))
+	}
+
+	for _, p := range prompts {
+		tokens += tokensPerMessage + EstimateTextTokens(p.Text)
+		if p.ToolCall != nil {
+			tokens += EstimateTextTokens(p.ToolCall.Name) + EstimateTextTokens(string(p.ToolCall.Arguments))
+		}
+		if p.ToolResponse != nil {
+			tokens += EstimateTextTokens(p.ToolResponse.Name) + EstimateTextTokens(p.ToolResponse.Response)
+		}
+		if p.Thinking != nil {
+			tokens += EstimateTextTokens(p.Thinking.Text)
+		}
+		if p.Payload != nil {
+			tokens += estimatePayloadTokens(p.Payload)
+		}
+	}
+	return tokens
+}
+
+func estimatePayloadTokens(payload *prompt.Payload) int {
+	if prompt.MIMEImages[payload.Mime] {
+		return tokensPerImage
+	}
+	if payload.Data == "" {
+		return tokensPerMedia
+	}
+
+	size := len(payload.Data) * 3 / 4 // base64
+	switch {
+	case payload.Mime == prompt.MimeTextPlain:
+		return size / 4
+	case prompt.MIMEAudio[payload.Mime]:
+		return size/500 + 1 // ~32 tokens per second at 128kbit/s
+	case prompt.MIMEVideo[payload.Mime]:
+		return size/4000 + 1 // ~260 tokens per second at 8Mbit/s
+	default: // documents, eg. pdf
+		return size/32 + 1
+	}
+}
+
+type runeClass int
+
+const (
+	classLetter runeClass = iota
+	classDigit
+	classSpace
+	classOther
+)
+
+func classify(r rune) runeClass {
+	switch {
+	case unicode.IsLetter(r) || unicode.IsMark(r):
+		return classLetter
+	case unicode.IsDigit(r):
+		return classDigit
+	case unicode.IsSpace(r):
+		return classSpace
+	}
+	return classOther
+}
+
+// EstimateTextTokens estimates the number of tokens of text, see EstimateTokens.
+func EstimateTextTokens(text string) int {
+	tokens := 0
+	for len(text) > 0 {
+		r, _ := utf8.DecodeRuneInString(text)
+		class := classify(r)
+		end := strings.IndexFunc(text, func(c rune) bool { return classify(c) != class })
+		if end < 0 {
+			end = len(text)
+		}
+		piece := text[:end]
+		text = text[end:]
+
+		switch class {
+		case classLetter:
+			ascii, other := 0, 0
+			for _, c := range piece {
+				if c < utf8.RuneSelf {
+					ascii++
+				} else {
+					other++
+				}
+			}
+			if other == 0 {
+				tokens += 1 + (ascii-1)/7 // common words are a single token, long and rare words are split
+			} else {
+				tokens += other + (ascii+3)/4
+			}
+		case classDigit:
+			tokens += (utf8.RuneCountInString(piece) + 2) / 3 // numbers are split in groups of three digits
+		case classSpace:
+			// a single space is merged with the following word
+			if piece != " " {
+				tokens += 1 + strings.Count(piece, "\n")/2
+			}
+		case classOther:
+			tokens += (utf8.RuneCountInString(piece) + 1) / 2
+		}
+	}
+	return tokens
+}
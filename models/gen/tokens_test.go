@@ -0,0 +1,56 @@
+package gen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/schema"
+	"github.com/modfin/bellman/tools"
+)
+
+func TestEstimateTextTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello world", 2},
+		{"The quick brown fox jumps over the lazy dog.", 10},
+		{"internationalization", 3},
+		{"1234567", 3},
+		{`{"symbol": "AAPL"}`, 6},
+		{"日本語", 3},
+	}
+	for _, tt := range tests {
+		if got := EstimateTextTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTextTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	type Args struct {
+		Symbol string `json:"symbol" json-description:"the ticker symbol of a stock"`
+	}
+	prompts := []prompt.Prompt{prompt.AsUser(strings.Repeat("hello ", 100))}
+
+	base := EstimateTokens(Request{}, prompts...)
+	if base != 104 {
+		t.Fatalf("expected 104 tokens for 100 words and message overhead, got %d", base)
+	}
+
+	withTools := EstimateTokens(Request{
+		SystemPrompt: "You are a stock broker",
+		Tools:        []tools.Tool{tools.NewTool("get_price", tools.WithArgSchema(Args{}))},
+		OutputSchema: schema.From(Args{}),
+	}, prompts...)
+	if withTools <= base+30 {
+		t.Fatalf("expected system prompt, tools and schema to be counted, got %d", withTools)
+	}
+
+	withImage := EstimateTokens(Request{}, append(prompts, prompt.AsUserWithData(prompt.MimeImagePNG, []byte("png")))...)
+	if withImage != base+tokensPerMessage+tokensPerImage {
+		t.Fatalf("expected the image to be counted, got %d", withImage)
+	}
+}
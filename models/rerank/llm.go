@@ -0,0 +1,120 @@
+package rerank
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/schema"
+)
+
+// llmBatchSize is the number of documents scored in each prompt, since models score long lists poorly.
+const llmBatchSize = 10
+
+const llmSystemPrompt = `You are a search relevance judge. You are given a query and a numbered list of documents.
+Score how relevant each document is to the query, from 0 to 10, where 0 is unrelated and 10 answers the query fully.
+Judge each document on its own, and score every document in the list.`
+
+type llmScores struct {
+	Scores []struct {
+		Index int `json:"index" json-description:"the number of the document"`
+		Score int `json:"score" json-description:"the relevance of the document to the query" json-minimum:"0" json-maximum:"10"`
+	} `json:"scores"`
+}
+
+// LLM is a Reranker that lets a language model score the relevance of the documents, for when there is no rerank
+// model at hand. The documents are scored in batches of 10, and the scores, 0 to 10, are scaled to 0 to 1. It is
+// slower and more expensive than a rerank model, and the scores are coarse.
+//
+// The generator needs structured output, or EmulateStructuredOutput for models without it.
+type LLM struct {
+	generator *gen.Generator
+}
+
+func NewLLM(generator *gen.Generator) *LLM {
+	return &LLM{generator: generator}
+}
+
+func (l *LLM) Provider() string {
+	return l.generator.Request.Model.Provider
+}
+
+func (l *LLM) Rerank(req *Request) (*Response, error) {
+	if len(req.Documents) == 0 {
+		return nil, fmt.Errorf("no documents provided")
+	}
+
+	g := l.generator.
+		System(llmSystemPrompt).
+		Output(schema.From(llmScores{}))
+	if req.Ctx != nil {
+		g = g.WithContext(req.Ctx)
+	}
+
+	res := &Response{
+		Metadata: models.Metadata{Model: g.Request.Model.FQN()},
+	}
+	for start := 0; start < len(req.Documents); start += llmBatchSize {
+		batch := req.Documents[start:min(start+llmBatchSize, len(req.Documents))]
+
+		var text strings.Builder
+		fmt.Fprintf(&text, "Query: %s\n\nDocuments:\n", req.Query)
+		for i, doc := range batch {
+			fmt.Fprintf(&text, "[%d] %s\n\n", i, doc)
+		}
+
+		resp, err := g.Prompt(prompt.AsUser(text.String()))
+		if err != nil {
+			return nil, fmt.Errorf("could not prompt for relevance scores, %w", err)
+		}
+		var scores llmScores
+		err = resp.Unmarshal(&scores)
+		if err != nil {
+			return nil, fmt.Errorf("could not unmarshal relevance scores, %w", err)
+		}
+
+		// documents the model skipped are scored 0, rather than dropped
+		scored := make([]float64, len(batch))
+		for _, s := range scores.Scores {
+			if s.Index >= 0 && s.Index < len(batch) {
+				scored[s.Index] = float64(min(max(s.Score, 0), 10)) / 10
+			}
+		}
+		for i, score := range scored {
+			res.Results = append(res.Results, Result{
+				Index:          start + i,
+				Document:       batch[i],
+				RelevanceScore: score,
+			})
+		}
+
+		res.Metadata.InputTokens += resp.Metadata.InputTokens
+		res.Metadata.OutputTokens += resp.Metadata.OutputTokens
+		res.Metadata.ThinkingTokens += resp.Metadata.ThinkingTokens
+		res.Metadata.TotalTokens += resp.Metadata.TotalTokens
+	}
+
+	res.Results = Sort(res.Results, req.TopK)
+	return res, nil
+}
+
+// Sort sorts results by relevance, the most relevant first, keeping the order of the documents for equal scores, and
+// returns the topK first. Zero topK returns all results.
+func Sort(results []Result, topK int) []Result {
+	slices.SortStableFunc(results, func(a, b Result) int {
+		switch {
+		case a.RelevanceScore > b.RelevanceScore:
+			return -1
+		case a.RelevanceScore < b.RelevanceScore:
+			return 1
+		}
+		return 0
+	})
+	if topK > 0 && topK < len(results) {
+		results = results[:topK]
+	}
+	return results
+}
@@ -0,0 +1,65 @@
+package rerank
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+)
+
+// judge scores documents containing "fox" 9, and all other documents 2.
+type judge struct {
+	request gen.Request
+	prompts int
+}
+
+func (j *judge) SetRequest(request gen.Request) { j.request = request }
+
+func (j *judge) Prompt(prompts ...prompt.Prompt) (*gen.Response, error) {
+	j.prompts++
+	var scores []string
+	for _, line := range strings.Split(prompts[0].Text, "\n") {
+		idx, doc, ok := strings.Cut(strings.TrimPrefix(line, "["), "] ")
+		if !ok {
+			continue
+		}
+		score := "2"
+		if strings.Contains(doc, "fox") {
+			score = "9"
+		}
+		scores = append(scores, `{"index":`+idx+`,"score":`+score+`}`)
+	}
+	text := `{"scores":[` + strings.Join(scores, ",") + `]}`
+	return &gen.Response{Texts: []string{text}, Metadata: models.Metadata{TotalTokens: 10}}, nil
+}
+
+func (j *judge) Stream(prompts ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
+	return nil, nil
+}
+
+func TestLLM(t *testing.T) {
+	j := &judge{}
+	g := (&gen.Generator{Prompter: j}).Model(gen.Model{Provider: "test", Name: "judge"})
+
+	docs := make([]string, 12)
+	for i := range docs {
+		docs[i] = "the lazy dog"
+	}
+	docs[3] = "the quick brown fox"
+	docs[11] = "a fox in the henhouse"
+
+	res, err := NewLLM(g).Rerank(NewRequest(context.Background(), Model{}, "where is the fox?", docs).WithTopK(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.prompts != 2 || j.request.OutputSchema == nil || res.Metadata.TotalTokens != 20 {
+		t.Fatalf("expected 2 prompts with an output schema, got %d, %+v", j.prompts, j.request)
+	}
+	if len(res.Results) != 3 || res.Results[0].Index != 3 || res.Results[1].Index != 11 || res.Results[0].RelevanceScore != 0.9 ||
+		res.Results[2].Index != 0 {
+		t.Fatalf("unexpected results %+v", res.Results)
+	}
+}
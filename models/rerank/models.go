@@ -0,0 +1,97 @@
+package rerank
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/modfin/bellman/models"
+)
+
+type Reranker interface {
+	Provider() string
+	Rerank(req *Request) (*Response, error)
+}
+
+type Model struct {
+	Provider string `json:"provider"`
+	Name     string `json:"name"`
+
+	Description string `json:"description,omitempty"`
+
+	// InputMaxTokens is the max number of tokens of the query and a document together.
+	InputMaxTokens int `json:"input_max_tokens,omitempty"`
+	// MaxRequestDocuments is the max number of documents in one request.
+	MaxRequestDocuments int `json:"max_request_documents,omitempty"`
+
+	Config map[string]any `json:"config,omitempty"`
+}
+
+func (m Model) FQN() string {
+	return m.String()
+}
+
+func (m Model) String() string {
+	return m.Provider + "/" + m.Name
+}
+
+func ToModel(fqn string) (Model, error) {
+	provider, name, found := strings.Cut(fqn, "/")
+	if !found {
+		return Model{}, errors.New("invalid fqn, did not find a '/' separating provider and model")
+	}
+	return Model{
+		Provider: provider,
+		Name:     name,
+	}, nil
+}
+
+type Request struct {
+	Ctx       context.Context `json:"-"`
+	Model     Model           `json:"model"`
+	Query     string          `json:"query"`
+	Documents []string        `json:"documents"`
+
+	// TopK is the number of results returned, the most relevant first. Zero returns all documents.
+	TopK int `json:"top_k,omitempty"`
+}
+
+func NewRequest(ctx context.Context, model Model, query string, documents []string) *Request {
+	return &Request{
+		Ctx:       ctx,
+		Model:     model,
+		Query:     query,
+		Documents: documents,
+	}
+}
+
+// WithTopK returns a copy of the request, returning the k most relevant documents.
+func (r *Request) WithTopK(k int) *Request {
+	rr := *r
+	rr.TopK = k
+	return &rr
+}
+
+type Result struct {
+	// Index of the document in Request.Documents
+	Index    int    `json:"index"`
+	Document string `json:"document"`
+	// RelevanceScore is the relevance of the document to the query, higher is more relevant. Its range depends on the
+	// model, but is typically between 0 and 1.
+	RelevanceScore float64 `json:"relevance_score"`
+}
+
+type Response struct {
+	// Results are sorted by relevance, the most relevant first
+	Results  []Result        `json:"results"`
+	Metadata models.Metadata `json:"metadata,omitempty"`
+}
+
+// Documents returns the documents of the results, the most relevant first.
+func (r *Response) Documents() []string {
+	docs := make([]string, len(r.Results))
+	for i, res := range r.Results {
+		docs[i] = res.Document
+	}
+	return docs
+}
@@ -5,20 +5,23 @@ import (
 	"fmt"
 	"github.com/modfin/bellman/models/embed"
 	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/models/rerank"
 )
 
 var ErrNoModelProvided = errors.New("no model was provided")
 var ErrClientNotFound = errors.New("client not found")
 
 type Proxy struct {
-	embeders map[string]embed.Embeder
-	gens     map[string]gen.Gen
+	embeders  map[string]embed.Embeder
+	gens      map[string]gen.Gen
+	rerankers map[string]rerank.Reranker
 }
 
 func NewProxy() *Proxy {
 	p := &Proxy{
-		embeders: map[string]embed.Embeder{},
-		gens:     map[string]gen.Gen{},
+		embeders:  map[string]embed.Embeder{},
+		gens:      map[string]gen.Gen{},
+		rerankers: map[string]rerank.Reranker{},
 	}
 
 	return p
@@ -30,6 +33,9 @@ func (p *Proxy) RegisterEmbeder(embeder embed.Embeder) {
 func (p *Proxy) RegisterGen(llm gen.Gen) {
 	p.gens[llm.Provider()] = llm
 }
+func (p *Proxy) RegisterReranker(reranker rerank.Reranker) {
+	p.rerankers[reranker.Provider()] = reranker
+}
 
 func (p *Proxy) Embed(embed *embed.Request) (*embed.Response, error) {
 	client, ok := p.embeders[embed.Model.Provider]
@@ -79,3 +85,22 @@ func (p *Proxy) Gen(model gen.Model) (*gen.Generator, error) {
 
 	return client.Generator(gen.WithModel(model)), nil
 }
+
+// Rerank reranks with the reranker of the provider of the model. Providers without a reranker, but with a registered
+// gen client, falls back to rerank.LLM, where the model is the language model scoring the documents.
+func (p *Proxy) Rerank(req *rerank.Request) (*rerank.Response, error) {
+	if req.Model.Name == "" {
+		return nil, fmt.Errorf("rerank.Model.Name is not set, %w", ErrNoModelProvided)
+	}
+
+	client, ok := p.rerankers[req.Model.Provider]
+	if ok && client != nil {
+		return client.Rerank(req)
+	}
+
+	g, err := p.Gen(gen.Model{Provider: req.Model.Provider, Name: req.Model.Name, Config: req.Model.Config})
+	if err != nil {
+		return nil, fmt.Errorf("no reranker registerd for provider '%s', %w", req.Model.Provider, err)
+	}
+	return rerank.NewLLM(g).Rerank(req)
+}
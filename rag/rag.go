@@ -0,0 +1,257 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/modfin/bellman/models/embed"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/models/rerank"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/vectorstore"
+)
+
+const (
+	defaultTopK        = 20
+	defaultTokenBudget = 4000
+)
+
+const defaultInstructions = `Answer the question using only the numbered sources below.
+Cite the sources each statement is based on with their number in square brackets, eg. [1] or [2][5].
+If the sources do not contain the answer, say that you do not know, rather than guessing.`
+
+// Chunk is a retrieved part of a source.
+type Chunk struct {
+	ID       string         `json:"id"`
+	Text     string         `json:"text"`
+	Score    float64        `json:"score"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// Retriever returns the k chunks most similar to the embedding of a question, the most similar first.
+type Retriever interface {
+	Retrieve(ctx context.Context, vector []float64, k int) ([]Chunk, error)
+}
+
+// RetrieverFunc lets an ordinary function be used as a Retriever.
+type RetrieverFunc func(ctx context.Context, vector []float64, k int) ([]Chunk, error)
+
+func (f RetrieverFunc) Retrieve(ctx context.Context, vector []float64, k int) ([]Chunk, error) {
+	return f(ctx, vector, k)
+}
+
+// FromStore returns a Retriever searching store, for documents matching all filters.
+func FromStore(store *vectorstore.Store, filters ...vectorstore.Filter) Retriever {
+	return RetrieverFunc(func(ctx context.Context, vector []float64, k int) ([]Chunk, error) {
+		results, err := store.SearchVector(vector, k, filters...)
+		if err != nil {
+			return nil, err
+		}
+		chunks := make([]Chunk, len(results))
+		for i, r := range results {
+			chunks[i] = Chunk{ID: r.ID, Text: r.Text, Score: r.Score, Metadata: r.Metadata}
+		}
+		return chunks, nil
+	})
+}
+
+// Citation is a source cited in the answer.
+type Citation struct {
+	// Ref is the number the source had in the prompt, as cited in the text, eg. 2 for [2].
+	Ref   int   `json:"ref"`
+	Chunk Chunk `json:"chunk"`
+}
+
+type Answer struct {
+	*gen.Response
+	// Citations are the sources cited in the answer, in the order they are first cited.
+	Citations []Citation `json:"citations"`
+	// Sources are the chunks given to the model, Sources[i] as [i+1].
+	Sources []Chunk `json:"sources"`
+}
+
+// RAG answers questions from retrieved chunks. The question is embedded as embed.TypeQuery, the top k chunks are
+// retrieved, optionally reranked, and as many as fit the token budget are given to the model, most relevant first,
+// numbered for the model to cite.
+type RAG struct {
+	embeder   embed.Embeder
+	model     embed.Model
+	retriever Retriever
+	generator *gen.Generator
+
+	reranker     rerank.Reranker
+	rerankModel  rerank.Model
+	topK         int
+	tokenBudget  int
+	instructions string
+}
+
+type Option func(r *RAG)
+
+// WithTopK sets the number of chunks retrieved. Default 20.
+func WithTopK(k int) Option {
+	return func(r *RAG) {
+		r.topK = max(k, 1)
+	}
+}
+
+// WithTokenBudget sets the max number of tokens of the chunks in the prompt, estimated with gen.EstimateTextTokens.
+// Default 4000.
+func WithTokenBudget(tokens int) Option {
+	return func(r *RAG) {
+		r.tokenBudget = max(tokens, 1)
+	}
+}
+
+// WithReranker reranks the retrieved chunks with model before they are packed into the prompt, eg. with a
+// rerank.NewLLM, or a bellman.Proxy.
+func WithReranker(reranker rerank.Reranker, model rerank.Model) Option {
+	return func(r *RAG) {
+		r.reranker = reranker
+		r.rerankModel = model
+	}
+}
+
+// WithInstructions replaces the instructions in the system prompt, which ask the model to cite the sources by their
+// number in square brackets. Citations are only found in the answer in that form.
+func WithInstructions(instructions string) Option {
+	return func(r *RAG) {
+		r.instructions = instructions
+	}
+}
+
+func New(embeder embed.Embeder, model embed.Model, retriever Retriever, generator *gen.Generator, options ...Option) *RAG {
+	r := &RAG{
+		embeder:      embeder,
+		model:        model,
+		retriever:    retriever,
+		generator:    generator,
+		topK:         defaultTopK,
+		tokenBudget:  defaultTokenBudget,
+		instructions: defaultInstructions,
+	}
+	for _, op := range options {
+		op(r)
+	}
+	return r
+}
+
+// Retrieve returns the chunks for question, retrieved and reranked, the most relevant first.
+func (r *RAG) Retrieve(ctx context.Context, question string) ([]Chunk, error) {
+	res, err := r.embeder.Embed(embed.NewSingleRequest(ctx, r.model.WithType(embed.TypeQuery), question))
+	if err != nil {
+		return nil, fmt.Errorf("could not embed question, %w", err)
+	}
+	vector, err := res.Single()
+	if err != nil {
+		return nil, fmt.Errorf("could not get question embedding, %w", err)
+	}
+
+	chunks, err := r.retriever.Retrieve(ctx, vector, r.topK)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve chunks, %w", err)
+	}
+	if r.reranker == nil || len(chunks) == 0 {
+		return chunks, nil
+	}
+
+	docs := make([]string, len(chunks))
+	for i, c := range chunks {
+		docs[i] = c.Text
+	}
+	reranked, err := r.reranker.Rerank(rerank.NewRequest(ctx, r.rerankModel, question, docs))
+	if err != nil {
+		return nil, fmt.Errorf("could not rerank chunks, %w", err)
+	}
+	ordered := make([]Chunk, 0, len(reranked.Results))
+	for _, result := range reranked.Results {
+		if result.Index < 0 || result.Index >= len(chunks) {
+			continue
+		}
+		c := chunks[result.Index]
+		c.Score = result.RelevanceScore
+		ordered = append(ordered, c)
+	}
+	return ordered, nil
+}
+
+// Ask answers question from the retrieved chunks, with the citations of the answer.
+func (r *RAG) Ask(ctx context.Context, question string) (*Answer, error) {
+	chunks, err := r.Retrieve(ctx, question)
+	if err != nil {
+		return nil, err
+	}
+	sources := Pack(chunks, r.tokenBudget)
+	if len(sources) == 0 {
+		return nil, errors.New("no chunks retrieved, or none fit the token budget")
+	}
+
+	g := r.generator.WithContext(ctx)
+	system := r.instructions
+	if g.Request.SystemPrompt != "" {
+		system = g.Request.SystemPrompt + "\n\n" + system
+	}
+	res, err := g.System(system).Prompt(prompt.AsUser(Prompt(question, sources)))
+	if err != nil {
+		return nil, fmt.Errorf("could not prompt for answer, %w", err)
+	}
+
+	text, _ := res.AsText()
+	return &Answer{
+		Response:  res,
+		Citations: Cite(text, sources),
+		Sources:   sources,
+	}, nil
+}
+
+// Pack returns the chunks, in order, that fit within tokens together. Chunks that do not fit are skipped, so a
+// smaller chunk further down may still be included.
+func Pack(chunks []Chunk, tokens int) []Chunk {
+	var res []Chunk
+	used := 0
+	for _, c := range chunks {
+		t := gen.EstimateTextTokens(c.Text)
+		if used+t > tokens {
+			continue
+		}
+		used += t
+		res = append(res, c)
+	}
+	return res
+}
+
+// Prompt returns the user prompt of the sources, numbered from 1, and the question.
+func Prompt(question string, sources []Chunk) string {
+	var b strings.Builder
+	b.WriteString("Sources:\n\n")
+	for i, c := range sources {
+		fmt.Fprintf(&b, "[%d] %s\n\n", i+1, c.Text)
+	}
+	b.WriteString("Question: ")
+	b.WriteString(question)
+	return b.String()
+}
+
+var citationPattern = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// Cite returns the sources cited in text, eg. [2] or [1, 3], in the order they are first cited. Numbers that are not
+// of a source are ignored.
+func Cite(text string, sources []Chunk) []Citation {
+	var res []Citation
+	seen := map[int]bool{}
+	for _, m := range citationPattern.FindAllStringSubmatch(text, -1) {
+		for _, n := range strings.Split(m[1], ",") {
+			ref, err := strconv.Atoi(strings.TrimSpace(n))
+			if err != nil || ref < 1 || ref > len(sources) || seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			res = append(res, Citation{Ref: ref, Chunk: sources[ref-1]})
+		}
+	}
+	return res
+}
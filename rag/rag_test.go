@@ -0,0 +1,94 @@
+package rag
+
+import (
+	"context"
+	"testing"
+
+	"github.com/modfin/bellman"
+	"github.com/modfin/bellman/models/embed"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/models/rerank"
+	"github.com/modfin/bellman/vectorstore"
+)
+
+var texts = []string{
+	"Stockholm is the capital of Sweden.",
+	"Oslo is the capital of Norway.",
+	"Helsinki is the capital of Finland.",
+	"Copenhagen is the capital of Denmark.",
+}
+
+// reverse reranks the documents in reverse order
+type reverse struct{}
+
+func (reverse) Provider() string { return "test" }
+
+func (reverse) Rerank(req *rerank.Request) (*rerank.Response, error) {
+	res := &rerank.Response{}
+	for i := len(req.Documents) - 1; i >= 0; i-- {
+		res.Results = append(res.Results, rerank.Result{Index: i, Document: req.Documents[i], RelevanceScore: float64(i)})
+	}
+	return res, nil
+}
+
+func TestAsk(t *testing.T) {
+	ctx := context.Background()
+	client := bellman.NewMock()
+	model := embed.Model{Provider: bellman.MockProvider, Name: "embed"}
+
+	res, err := client.Embed(embed.NewManyRequest(ctx, model, texts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := vectorstore.FromResponse(texts, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, _ := vectorstore.New()
+	_, err = store.Add(docs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := client.Generator(gen.WithModel(gen.Model{Provider: bellman.MockProvider, Name: "gen"}))
+
+	// the mock embeds equal texts equally, so the question finds itself first
+	answer, err := New(client, model, FromStore(store), generator, WithTopK(2)).Ask(ctx, texts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answer.Sources) != 2 || answer.Sources[0].Text != texts[2] {
+		t.Fatalf("expected 2 sources, %q first, got %+v", texts[2], answer.Sources)
+	}
+	if len(answer.Texts) != 1 || answer.Metadata.Model != "Mock/gen" {
+		t.Fatalf("expected the response of the generator, got %+v", answer.Response)
+	}
+	// the mock echoes the prompt, which cites all sources
+	if len(answer.Citations) != 2 || answer.Citations[0].Chunk.Text != texts[2] {
+		t.Fatalf("expected 2 citations, got %+v", answer.Citations)
+	}
+
+	answer, err = New(client, model, FromStore(store), generator, WithTopK(2), WithReranker(reverse{}, rerank.Model{})).Ask(ctx, texts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Sources[1].Text != texts[2] || answer.Sources[0].Score != 1 {
+		t.Fatalf("expected the sources in reranked order, got %+v", answer.Sources)
+	}
+}
+
+func TestPackAndCite(t *testing.T) {
+	chunks := []Chunk{
+		{ID: "a", Text: texts[0]},
+		{ID: "b", Text: "a long chunk " + texts[0] + texts[1] + texts[2] + texts[3]},
+		{ID: "c", Text: texts[1]},
+	}
+	sources := Pack(chunks, 2*gen.EstimateTextTokens(texts[0]))
+	if len(sources) != 2 || sources[0].ID != "a" || sources[1].ID != "c" {
+		t.Fatalf("expected a and c to fit, got %+v", sources)
+	}
+
+	citations := Cite("Oslo is the capital [2], as is Stockholm [1, 2]. See also [3] and [x].", sources)
+	if len(citations) != 2 || citations[0].Ref != 2 || citations[0].Chunk.ID != "c" || citations[1].Chunk.ID != "a" {
+		t.Fatalf("expected citations of c and a, got %+v", citations)
+	}
+}
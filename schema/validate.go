@@ -0,0 +1,183 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate checks that data is json following the schema. Every violation is returned, joined, with the path to
+// the offending value, eg. "$.addresses[1].number: 0 is less than the minimum 1".
+func (s *JSON) Validate(data []byte) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v any
+	err := d.Decode(&v)
+	if err != nil {
+		return fmt.Errorf("could not decode json, %w", err)
+	}
+	if d.More() {
+		return errors.New("could not decode json, unexpected data after the value")
+	}
+	var errs []error
+	s.validate(s, "$", v, &errs)
+	return errors.Join(errs...)
+}
+
+func (s *JSON) validate(root *JSON, path string, v any, errs *[]error) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, fmt.Errorf("%s: "+format, append([]any{path}, args...)...))
+	}
+
+	if s.Ref != "" {
+		ref := s.resolve(root)
+		if ref == nil {
+			fail("could not resolve %s", s.Ref)
+			return
+		}
+		ref.validate(root, path, v, errs)
+		return
+	}
+
+	if v == nil {
+		if s.Type != "" && !s.Nullable {
+			fail("null is not allowed")
+		}
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		fail("%v is not one of %v", v, s.Enum)
+	}
+
+	switch s.Type {
+	case Object:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("expected object, got %s", typeOf(v))
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				fail("missing required property %s", name)
+			}
+		}
+		for name, value := range obj {
+			p, ok := s.Properties[name]
+			switch {
+			case ok:
+				p.validate(root, path+"."+name, value, errs)
+			case s.AdditionalProperties != nil:
+				s.AdditionalProperties.validate(root, path+"."+name, value, errs)
+			}
+		}
+	case Array:
+		arr, ok := v.([]any)
+		if !ok {
+			fail("expected array, got %s", typeOf(v))
+			return
+		}
+		if s.MinItems != nil && len(arr) < *s.MinItems {
+			fail("%d items is less than the minimum %d", len(arr), *s.MinItems)
+		}
+		if s.MaxItems != nil && len(arr) > *s.MaxItems {
+			fail("%d items is more than the maximum %d", len(arr), *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				s.Items.validate(root, fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case String:
+		str, ok := v.(string)
+		if !ok {
+			fail("expected string, got %s", typeOf(v))
+			return
+		}
+		n := utf8.RuneCountInString(str)
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("length %d is less than the minimum %d", n, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("length %d is more than the maximum %d", n, *s.MaxLength)
+		}
+		if s.Pattern != nil {
+			re, err := regexp.Compile(*s.Pattern)
+			if err == nil && !re.MatchString(str) {
+				fail("%q does not match the pattern %s", str, *s.Pattern)
+			}
+		}
+	case Number, Integer:
+		num, ok := v.(json.Number)
+		if !ok {
+			fail("expected %s, got %s", s.Type, typeOf(v))
+			return
+		}
+		f, err := num.Float64()
+		if err != nil {
+			fail("invalid number %s", num)
+			return
+		}
+		if s.Type == Integer && f != math.Trunc(f) {
+			fail("expected integer, got %s", num)
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("%s is less than the minimum %v", num, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail("%s is more than the maximum %v", num, *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+			fail("%s is not more than the exclusive minimum %v", num, *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum {
+			fail("%s is not less than the exclusive maximum %v", num, *s.ExclusiveMaximum)
+		}
+	case Boolean:
+		if _, ok := v.(bool); !ok {
+			fail("expected boolean, got %s", typeOf(v))
+		}
+	}
+}
+
+// resolve returns the schema referenced by s.Ref, eg. #/$defs/Address, from the definitions of root or s.
+func (s *JSON) resolve(root *JSON) *JSON {
+	name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+	if !ok {
+		return nil
+	}
+	if ref, ok := root.Defs[name]; ok {
+		return ref
+	}
+	return s.Defs[name]
+}
+
+func inEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,48 @@
+package schema_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/modfin/bellman/schema"
+)
+
+func TestValidate(t *testing.T) {
+	type Address struct {
+		Street string `json:"street" json-min-length:"1"`
+		Number int    `json:"number" json-minimum:"1"`
+	}
+	type Person struct {
+		Name      string    `json:"name"`
+		Status    string    `json:"status" json-enum:"active,inactive"`
+		Email     *string   `json:"email"`
+		Addresses []Address `json:"addresses" json-max-items:"2"`
+	}
+	s := schema.From(Person{})
+
+	err := s.Validate([]byte(`{"name": "Ada", "status": "active", "email": null, "addresses": [{"street": "Main", "number": 1}]}`))
+	if err != nil {
+		t.Fatalf("expected valid json, got %v", err)
+	}
+
+	err = s.Validate([]byte(`{"name": 1, "status": "gone", "addresses": [{"street": "", "number": 1.5}]}`))
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, expected := range []string{
+		"$.name: expected string, got number",
+		"$.status: gone is not one of [active inactive]",
+		"$: missing required property email",
+		"$.addresses[0].street: length 0 is less than the minimum 1",
+		"$.addresses[0].number: expected integer, got 1.5",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %v", expected, err)
+		}
+	}
+
+	err = s.Validate([]byte(`{"name": "Ada"} trailing`))
+	if err == nil {
+		t.Fatal("expected error for trailing data")
+	}
+}
@@ -0,0 +1,166 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/modfin/bellman/models/files"
+)
+
+// filesBetaVersion is the beta of the Files API, needed both to manage files and to use them in messages.
+const filesBetaVersion = "files-api-2025-04-14"
+
+const filesURL = "https://api.anthropic.com/v1/files"
+
+type fileObject struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	MimeType  string    `json:"mime_type"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type fileList struct {
+	Data    []fileObject `json:"data"`
+	HasMore bool         `json:"has_more"`
+	LastID  string       `json:"last_id"`
+}
+
+func toFile(f fileObject) files.File {
+	return files.File{
+		Provider:  Provider,
+		ID:        f.ID,
+		Name:      f.Filename,
+		Mime:      f.MimeType,
+		Size:      f.SizeBytes,
+		CreatedAt: f.CreatedAt,
+		URI:       files.URI(Provider, f.ID),
+	}
+}
+
+// Upload uploads a file with the Files API, to be used in prompts by its URI. Images are sent as image blocks, and
+// other files, eg. PDFs and text, as document blocks.
+func (a *Anthropic) Upload(request *files.UploadRequest) (*files.File, error) {
+	var reqc = atomic.AddInt64(&requestNo, 1)
+	if request.Reader == nil {
+		return nil, errors.New("no file content provided")
+	}
+
+	body, contentType := multipartFile(request)
+	req, err := http.NewRequestWithContext(request.Context(), "POST", filesURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request, %w", err)
+	}
+	req.Header.Set("content-type", contentType)
+
+	var file fileObject
+	err = a.doFiles(req, &file)
+	if err != nil {
+		return nil, err
+	}
+	a.log("[files] uploaded", "request", reqc, "id", file.ID, "bytes", file.SizeBytes)
+	res := toFile(file)
+	return &res, nil
+}
+
+func (a *Anthropic) List(ctx context.Context) ([]files.File, error) {
+	var res []files.File
+	after := ""
+	for {
+		query := url.Values{"limit": {"1000"}}
+		if after != "" {
+			query.Set("after_id", after)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", filesURL+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not create request, %w", err)
+		}
+		var list fileList
+		err = a.doFiles(req, &list)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range list.Data {
+			res = append(res, toFile(f))
+		}
+		if !list.HasMore || list.LastID == "" {
+			return res, nil
+		}
+		after = list.LastID
+	}
+}
+
+func (a *Anthropic) Delete(ctx context.Context, id string) error {
+	u, err := url.JoinPath(filesURL, id)
+	if err != nil {
+		return fmt.Errorf("could not construct files URL, %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "DELETE", u, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request, %w", err)
+	}
+	return a.doFiles(req, nil)
+}
+
+func (a *Anthropic) doFiles(req *http.Request, v any) error {
+	req.Header.Set("x-api-key", a.apiKey)
+	req.Header.Set("anthropic-version", Version)
+	req.Header.Set("anthropic-beta", filesBetaVersion)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send files request, %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, err := io.ReadAll(resp.Body)
+		return errors.Join(fmt.Errorf("unexpected status code, %d, err: {%s}", resp.StatusCode, string(b)), err)
+	}
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("could not decode files response, %w", err)
+	}
+	return nil
+}
+
+// multipartFile returns a multipart/form-data body of the file of request, and its content type. The file is
+// streamed through a pipe, rather than read into memory, which is closed by the http client when done.
+func multipartFile(request *files.UploadRequest) (io.ReadCloser, string) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	go func() {
+		err := func() error {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(request.Name)))
+			if request.Mime != "" {
+				header.Set("Content-Type", request.Mime)
+			}
+			part, err := w.CreatePart(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(part, request.Reader)
+			if err != nil {
+				return err
+			}
+			return w.Close()
+		}()
+		pw.CloseWithError(err)
+	}()
+	return pr, w.FormDataContentType()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
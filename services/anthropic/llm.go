@@ -14,6 +14,7 @@ import (
 	"sync/atomic"
 
 	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/files"
 	"github.com/modfin/bellman/models/gen"
 	"github.com/modfin/bellman/prompt"
 	"github.com/modfin/bellman/tools"
@@ -30,6 +31,10 @@ func (g *generator) SetRequest(config gen.Request) {
 	g.request = config
 }
 func (g *generator) Stream(conversation ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
+	err := g.request.CheckConstraints()
+	if err != nil {
+		return nil, err
+	}
 	g.request.Stream = true
 	req, reqModel, err := g.prompt(conversation...)
 	if err != nil {
@@ -346,6 +351,10 @@ func (g *generator) Stream(conversation ...prompt.Prompt) (<-chan *gen.StreamRes
 }
 
 func (g *generator) Prompt(conversation ...prompt.Prompt) (*gen.Response, error) {
+	err := g.request.CheckConstraints()
+	if err != nil {
+		return nil, err
+	}
 
 	req, reqModel, err := g.prompt(conversation...)
 	if err != nil {
@@ -435,7 +444,7 @@ func (g *generator) Prompt(conversation ...prompt.Prompt) (*gen.Response, error)
 	return res, nil
 }
 func (g *generator) prompt(conversation ...prompt.Prompt) (*http.Request, request, error) {
-	var pdfBeta bool
+	var pdfBeta, filesBeta bool
 
 	model := request{
 		Stream:    g.request.Stream,
@@ -572,6 +581,19 @@ func (g *generator) prompt(conversation ...prompt.Prompt) (*http.Request, reques
 					Text: t.Text,
 				})
 			}
+			if t.Payload != nil && t.Payload.Uri != "" {
+				source, err := uriSource(t.Payload.Uri)
+				if err != nil {
+					return nil, model, err
+				}
+				filesBeta = filesBeta || source.Type == "file"
+				blockType := "document"
+				if strings.HasPrefix(t.Payload.Mime, "image/") {
+					blockType = "image"
+				}
+				appendBlock("user", reqContent{Type: blockType, Source: source})
+				continue
+			}
 			if t.Payload != nil {
 				if t.Payload.Mime == "application/pdf" {
 					appendBlock("user", reqContent{
@@ -618,5 +640,20 @@ func (g *generator) prompt(conversation ...prompt.Prompt) (*http.Request, reques
 	if pdfBeta {
 		req.Header.Add("anthropic-beta", "pdfs-2024-09-25")
 	}
+	if filesBeta {
+		req.Header.Add("anthropic-beta", filesBetaVersion)
+	}
 	return req, model, nil
 }
+
+// uriSource returns the source of a payload by uri, a file uploaded with the Files API, see files.URI, or a url.
+func uriSource(uri string) (*reqContentSource, error) {
+	provider, id, ok := files.ParseURI(uri)
+	if ok && provider != Provider {
+		return nil, fmt.Errorf("file %s is uploaded to %s, and cannot be used with %s", id, provider, Provider)
+	}
+	if ok {
+		return &reqContentSource{Type: "file", FileID: id}, nil
+	}
+	return &reqContentSource{Type: "url", URL: uri}, nil
+}
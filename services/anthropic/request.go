@@ -78,9 +78,11 @@ type reqContent struct {
 
 // https://docs.anthropic.com/en/api/messages-examples#vision
 type reqContentSource struct {
-	Type      string `json:"type"`           // eg base64
-	MediaType string `json:"media_type"`     //image/jpeg, image/png, image/gif, and image/webp
-	Data      string `json:"data,omitempty"` // base64 encoded.
+	Type      string `json:"type"`                 // base64, url or file
+	MediaType string `json:"media_type,omitempty"` //image/jpeg, image/png, image/gif, and image/webp
+	Data      string `json:"data,omitempty"`       // base64 encoded.
+	URL       string `json:"url,omitempty"`
+	FileID    string `json:"file_id,omitempty"`
 }
 
 type ExtendedThinkingType string
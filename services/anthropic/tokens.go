@@ -0,0 +1,83 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+)
+
+// https://docs.anthropic.com/en/api/messages-count-tokens
+type countRequest struct {
+	Model    string               `json:"model"`
+	System   string               `json:"system,omitempty"`
+	Tool     *reqToolChoice       `json:"tool_choice,omitempty"`
+	Tools    []reqTool            `json:"tools,omitempty"`
+	Messages []reqMessages        `json:"messages"`
+	Thinking *reqExtendedThinking `json:"thinking,omitempty"`
+}
+
+type countResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
+// CountTokens counts the input tokens of the request with the count_tokens endpoint, implementing gen.TokenCounter
+func (g *generator) CountTokens(conversation ...prompt.Prompt) (*gen.TokenCount, error) {
+	req, reqModel, err := g.prompt(conversation...)
+	if err != nil {
+		return nil, err
+	}
+
+	reqdata, err := json.Marshal(countRequest{
+		Model:    reqModel.Model,
+		System:   reqModel.System,
+		Tool:     reqModel.Tool,
+		Tools:    reqModel.Tools,
+		Messages: reqModel.Messages,
+		Thinking: reqModel.Thinking,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal count request, %w", err)
+	}
+	countReq, err := http.NewRequestWithContext(req.Context(), "POST", "https://api.anthropic.com/v1/messages/count_tokens", bytes.NewReader(reqdata))
+	if err != nil {
+		return nil, fmt.Errorf("could not create count request, %w", err)
+	}
+	countReq.Header = req.Header.Clone()
+
+	reqc := atomic.AddInt64(&requestNo, 1)
+	g.anthropic.log("[gen] count tokens request",
+		"request", reqc,
+		"model", g.request.Model.FQN(),
+	)
+
+	resp, err := http.DefaultClient.Do(countReq)
+	if err != nil {
+		return nil, fmt.Errorf("could not post count request, %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, err := io.ReadAll(resp.Body)
+		return nil, errors.Join(fmt.Errorf("unexpected status code, %d, err: {%s}", resp.StatusCode, string(b)), err)
+	}
+
+	var respModel countResponse
+	err = json.NewDecoder(resp.Body).Decode(&respModel)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode count response, %w", err)
+	}
+
+	g.anthropic.log("[gen] count tokens response",
+		"request", reqc,
+		"model", g.request.Model.FQN(),
+		"token-input", respModel.InputTokens,
+	)
+	return &gen.TokenCount{InputTokens: respModel.InputTokens}, nil
+}
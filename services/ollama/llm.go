@@ -1,14 +1,18 @@
 package ollama
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync/atomic"
 
 	"github.com/modfin/bellman/models"
@@ -27,15 +31,367 @@ type generator struct {
 func (g *generator) SetRequest(config gen.Request) {
 	g.request = config
 }
+
+// Stream streams the response over the ndjson stream of /api/chat. Ollama sends tool calls whole, so each call is
+// emitted as a single tool call delta. With regex or choices constraints, the reply is a json string, which is
+// unquoted and emitted as a single delta when done, like Prompt returns it.
 func (g *generator) Stream(conversation ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
-	return nil, errors.New("not implmented")
+	g.request.Stream = true
+	req, reqModel, err := g.prompt(conversation...)
+	if err != nil {
+		return nil, err
+	}
+
+	reqc := atomic.AddInt64(&requestNo, 1)
+	g.logRequest(reqc)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not post ollama request, %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, errors.Join(fmt.Errorf("unexpected status code, %d, err: {%s}", resp.StatusCode, string(b)), err)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	stream := make(chan *gen.StreamResponse)
+
+	go func() {
+		defer resp.Body.Close()
+		defer close(stream)
+
+		defer func() {
+			stream <- &gen.StreamResponse{
+				Type: gen.TYPE_EOF,
+			}
+		}()
+
+		var thinking, text strings.Builder
+		var calls []tools.Call
+		constrained := len(g.request.Constraints()) > 0
+
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var chunk genResponse
+			err := json.Unmarshal(line, &chunk)
+			if err != nil {
+				stream <- &gen.StreamResponse{
+					Type:    gen.TYPE_ERROR,
+					Content: fmt.Sprintf("could not unmarshal chunk, %v", err),
+				}
+				return
+			}
+			if chunk.Error != "" {
+				stream <- &gen.StreamResponse{Type: gen.TYPE_ERROR, Content: chunk.Error}
+				return
+			}
+
+			if chunk.Message.Thinking != "" {
+				thinking.WriteString(chunk.Message.Thinking)
+				stream <- &gen.StreamResponse{
+					Type:    gen.TYPE_THINKING_DELTA,
+					Role:    prompt.AssistantRole,
+					Content: chunk.Message.Thinking,
+				}
+			}
+			if chunk.Message.Content != "" {
+				text.WriteString(chunk.Message.Content)
+			}
+			if chunk.Message.Content != "" && !constrained {
+				stream <- &gen.StreamResponse{
+					Type:    gen.TYPE_DELTA,
+					Role:    prompt.AssistantRole,
+					Content: chunk.Message.Content,
+				}
+			}
+			for _, t := range chunk.Message.ToolCalls {
+				call, err := toCall(t, reqModel.toolBelt)
+				if err != nil {
+					stream <- &gen.StreamResponse{Type: gen.TYPE_ERROR, Content: err.Error()}
+					return
+				}
+				calls = append(calls, call)
+				stream <- &gen.StreamResponse{
+					Type:     gen.TYPE_DELTA,
+					Role:     prompt.ToolCallRole,
+					Index:    len(calls) - 1,
+					ToolCall: &call,
+				}
+			}
+
+			if !chunk.Done {
+				continue
+			}
+
+			content := text.String()
+			if constrained && content != "" {
+				content = unquote(content)
+				stream <- &gen.StreamResponse{
+					Type:    gen.TYPE_DELTA,
+					Role:    prompt.AssistantRole,
+					Content: content,
+				}
+			}
+
+			// the replay-ready turn, thinking and text before tool calls
+			if thinking.Len() > 0 {
+				p := prompt.AsThinking(thinking.String(), nil, "")
+				stream <- &gen.StreamResponse{
+					Type:  gen.TYPE_BLOCK,
+					Role:  prompt.ThinkingRole,
+					Block: &p,
+				}
+			}
+			if content != "" {
+				p := prompt.AsAssistant(content)
+				stream <- &gen.StreamResponse{
+					Type:  gen.TYPE_BLOCK,
+					Role:  prompt.AssistantRole,
+					Block: &p,
+				}
+			}
+			for i, call := range calls {
+				p := prompt.AsToolCall(call.ID, call.Name, call.Argument)
+				stream <- &gen.StreamResponse{
+					Type:     gen.TYPE_BLOCK,
+					Role:     prompt.ToolCallRole,
+					Index:    i,
+					Block:    &p,
+					ToolCall: &call,
+				}
+			}
+			stream <- &gen.StreamResponse{
+				Type:     gen.TYPE_METADATA,
+				Metadata: g.metadata(chunk),
+			}
+			return
+		}
+		if err := scanner.Err(); err != nil {
+			stream <- &gen.StreamResponse{
+				Type:    gen.TYPE_ERROR,
+				Content: fmt.Sprintf("ndjson read error: %v", err),
+			}
+		}
+	}()
+
+	return stream, nil
 }
 
 func (g *generator) Prompt(conversation ...prompt.Prompt) (*gen.Response, error) {
+	g.request.Stream = false
+	req, reqModel, err := g.prompt(conversation...)
+	if err != nil {
+		return nil, err
+	}
+
+	reqc := atomic.AddInt64(&requestNo, 1)
+	g.logRequest(reqc)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not post ollama request, %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ollama response, %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code, %d: err %s", resp.StatusCode, string(body))
+	}
+
+	var respModel genResponse
+	err = json.Unmarshal(body, &respModel)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode ollama response, %w", err)
+	}
+
+	res := &gen.Response{
+		Metadata: *g.metadata(respModel),
+	}
+	if len(respModel.Message.Thinking) > 0 {
+		res.Thinking = []string{respModel.Message.Thinking}
+		res.Turn = append(res.Turn, prompt.AsThinking(respModel.Message.Thinking, nil, ""))
+	}
+	if len(respModel.Message.Content) > 0 {
+		content := respModel.Message.Content
+		if len(g.request.Constraints()) > 0 {
+			content = unquote(content)
+		}
+		res.Texts = []string{content}
+		res.Turn = append(res.Turn, prompt.AsAssistant(content))
+	}
+	for _, t := range respModel.Message.ToolCalls {
+		call, err := toCall(t, reqModel.toolBelt)
+		if err != nil {
+			return nil, err
+		}
+		res.Tools = append(res.Tools, call)
+		res.Turn = append(res.Turn, prompt.AsToolCall(call.ID, call.Name, call.Argument))
+	}
+
+	g.ollama.log("[gen] response",
+		"request", reqc,
+		"model", g.request.Model.FQN(),
+		"token-input", res.Metadata.InputTokens,
+		"token-output", res.Metadata.OutputTokens,
+		"token-total", res.Metadata.TotalTokens,
+	)
+
+	return res, nil
+}
+
+func (g *generator) logRequest(reqc int64) {
+	g.ollama.log("[gen] request",
+		"request", reqc,
+		"model", g.request.Model.FQN(),
+		"stream", g.request.Stream,
+		"tools", len(g.request.Tools) > 0,
+		"tool_choice", g.request.ToolConfig != nil,
+		"output_schema", g.request.OutputSchema != nil,
+		"system_prompt", g.request.SystemPrompt != "",
+		"temperature", g.request.Temperature,
+		"top_p", g.request.TopP,
+		"max_tokens", g.request.MaxTokens,
+		"stop_sequences", g.request.StopSequences,
+	)
+}
+
+func (g *generator) metadata(r genResponse) *models.Metadata {
+	return &models.Metadata{
+		Model:          g.request.Model.FQN(),
+		InputTokens:    r.PromptEvalCount,
+		OutputTokens:   r.EvalCount,
+		ThinkingTokens: 0,
+		TotalTokens:    r.PromptEvalCount + r.EvalCount,
+	}
+}
+
+// toCall converts a tool call of ollama, which has no id in older versions of ollama, to a tools.Call.
+func toCall(t toolCall, toolBelt map[string]*tools.Tool) (tools.Call, error) {
+	args, err := json.Marshal(t.Function.Args)
+	if err != nil {
+		return tools.Call{}, fmt.Errorf("could not marshal tool arguments, %w", err)
+	}
+	id := t.ID
+	if id == "" {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		id = "call_" + hex.EncodeToString(b)
+	}
+	return tools.Call{
+		ID:       id,
+		Name:     t.Function.Name,
+		Argument: args,
+		Ref:      toolBelt[t.Function.Name],
+	}, nil
+}
+
+// toolChoice returns the tools to send, and an instruction for the system prompt, for the tool config of the request.
+func (g *generator) toolChoice() ([]tools.Tool, string, error) {
+	if g.request.ToolConfig == nil || len(g.request.Tools) == 0 {
+		return g.request.Tools, "", nil
+	}
+	switch g.request.ToolConfig.Name {
+	case tools.AutoTool.Name:
+		return g.request.Tools, "", nil
+	case tools.NoTool.Name:
+		return nil, "", nil
+	case tools.RequiredTool.Name:
+		return g.request.Tools, "You must call one or more of the tools, do not reply with text only.", nil
+	}
+	for _, t := range g.request.Tools {
+		if t.Name == g.request.ToolConfig.Name {
+			return []tools.Tool{t}, fmt.Sprintf("You must call the tool %s, do not reply with text only.", t.Name), nil
+		}
+	}
+	return nil, "", fmt.Errorf("tool choice %s is not among the tools", g.request.ToolConfig.Name)
+}
+
+// think translates a thinking budget to the think parameter of ollama. gpt-oss ignores booleans and only takes a level,
+// where "low" is <2.000, "medium" is 2.000-10.000, and "high" is 10.001+.
+func think(model string, budget int) any {
+	if !strings.HasPrefix(model, "gpt-oss") {
+		return budget > 0
+	}
+	switch {
+	case budget < 2000:
+		return "low"
+	case budget <= 10000:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// runtimeOptions sets the runtime options of ollama found in Model.Config, ie. num_ctx, seed and keep_alive.
+func (g *generator) runtimeOptions(reqModel *genRequest) error {
+	for key, v := range g.request.Model.Config {
+		switch key {
+		case "num_ctx":
+			n, err := configInt(key, v)
+			if err != nil {
+				return err
+			}
+			reqModel.Option.NumCtx = &n
+		case "seed":
+			n, err := configInt(key, v)
+			if err != nil {
+				return err
+			}
+			reqModel.Option.Seed = &n
+		case "keep_alive":
+			switch v.(type) {
+			case string, int, int64, float64, json.Number:
+				reqModel.KeepAlive = v
+			default:
+				return fmt.Errorf("keep_alive must be a duration or a number of seconds, got %T", v)
+			}
+		}
+	}
+	return nil
+}
+
+func configInt(key string, v any) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64: // from json
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	case json.Number:
+		i, err := n.Int64()
+		if err == nil {
+			return int(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%s must be an integer, got %v", key, v)
+}
+
+func (g *generator) prompt(conversation ...prompt.Prompt) (*http.Request, genRequest, error) {
+
+	// Selecting specific tool. Ollama has no tool choice, so it is emulated by which tools are sent, and by
+	// instructing the model to call them
+	toolset, instruction, err := g.toolChoice()
+	if err != nil {
+		return nil, genRequest{}, err
+	}
 
 	// Open Ai specific
-	if g.request.SystemPrompt != "" {
-		conversation = append([]prompt.Prompt{{Role: "system", Text: g.request.SystemPrompt}}, conversation...)
+	system := strings.TrimSpace(strings.Join([]string{g.request.SystemPrompt, instruction}, "\n\n"))
+	if system != "" {
+		conversation = append([]prompt.Prompt{{Role: "system", Text: system}}, conversation...)
 	}
 
 	reqModel := genRequest{
@@ -53,59 +409,101 @@ func (g *generator) Prompt(conversation ...prompt.Prompt) (*gen.Response, error)
 
 			StopSequences: g.request.StopSequences,
 		},
-		Stream: false,
+		Stream: g.request.Stream,
 	}
 
 	if g.request.ThinkingBudget != nil {
-		reqModel.Think = *g.request.ThinkingBudget > 0
+		reqModel.Think = think(g.request.Model.Name, *g.request.ThinkingBudget)
 	}
 
 	if g.request.Model.Name == "" {
-		return nil, fmt.Errorf("model is required")
+		return nil, reqModel, fmt.Errorf("model is required")
 	}
 
-	toolBelt := map[string]*tools.Tool{}
-	// Dealing with Tools
+	err = g.runtimeOptions(&reqModel)
+	if err != nil {
+		return nil, reqModel, err
+	}
+
+	reqModel.toolBelt = map[string]*tools.Tool{}
 	for _, t := range g.request.Tools {
+		reqModel.toolBelt[t.Name] = &t
+	}
+	// Dealing with Tools
+	for _, t := range toolset {
+		parameters := &JSONSchema{Type: Object}
+		if t.ArgumentSchema != nil {
+			parameters = fromBellmanSchema(t.ArgumentSchema)
+		}
 		reqModel.Tools = append(reqModel.Tools, tool{
 			Type: "function",
 			Function: toolFunction{
 				Name:        t.Name,
-				Parameters:  fromBellmanSchema(t.ArgumentSchema),
+				Parameters:  parameters,
 				Description: t.Description,
 			},
 		})
-		toolBelt[t.Name] = &t
-	}
-	//// Selecting specific tool
-	//if g.request.ToolConfig != nil {
-	//	switch g.request.ToolConfig.Name {
-	//	case tools.NoTool.Name, tools.AutoTool.Name, tools.RequiredTool.Name:
-	//		reqModel.ToolChoice = g.request.ToolConfig.Name
-	//	default:
-	//		reqModel.ToolChoice = requestTool{
-	//			Type: "function",
-	//			Function: toolFunc{
-	//				Name: g.request.ToolConfig.Name,
-	//			},
-	//		}
-	//	}
-	//}
+	}
 
 	// Dealing with Output Schema
 	if g.request.OutputSchema != nil {
 		reqModel.Format = fromBellmanSchema(g.request.OutputSchema)
 	}
 
+	// Dealing with constrained decoding. Ollama only constrains to a json schema, so regex and choices are decoded as
+	// a json string, that is unquoted in the response
+	err = g.request.CheckConstraints(gen.ConstraintRegex, gen.ConstraintChoices)
+	if err != nil {
+		return nil, reqModel, err
+	}
+	if g.request.Regex != "" {
+		reqModel.Format = &JSONSchema{Type: String, Pattern: g.request.Regex}
+	}
+	if len(g.request.Choices) > 0 {
+		reqModel.Format = &JSONSchema{Type: String}
+		for _, c := range g.request.Choices {
+			reqModel.Format.Enum = append(reqModel.Format.Enum, c)
+		}
+	}
+
 	// Dealing with Prompt Messages
 	//var hasPayload bool
 	messages := []genRequestMessage{}
 	for _, c := range conversation {
-		if c.Role == prompt.ThinkingRole {
+		switch c.Role {
+		case prompt.ThinkingRole:
 			// Ollama does not participate in the signature/thoughtSignature
 			// protocol; thinking prompts from other providers are dropped.
 			continue
+		case prompt.ToolCallRole:
+			if c.ToolCall == nil {
+				return nil, reqModel, fmt.Errorf("ToolCall is required for role tool call")
+			}
+			var args map[string]any
+			err := json.Unmarshal(c.ToolCall.Arguments, &args)
+			if err != nil {
+				return nil, reqModel, fmt.Errorf("ToolCall.Arguments is not valid JSON object: %w", err)
+			}
+			call := toolCall{Function: function{Name: c.ToolCall.Name, Args: args}}
+			// parallel tool calls, and the text before them, belongs to the same assistant message
+			if n := len(messages); n > 0 && messages[n-1].Role == string(prompt.AssistantRole) {
+				messages[n-1].ToolCalls = append(messages[n-1].ToolCalls, call)
+				continue
+			}
+			messages = append(messages, genRequestMessage{Role: string(prompt.AssistantRole), ToolCalls: []toolCall{call}})
+			continue
+		case prompt.ToolResponseRole:
+			if c.ToolResponse == nil {
+				return nil, reqModel, fmt.Errorf("ToolResponse is required for role tool response")
+			}
+			messages = append(messages, genRequestMessage{
+				Role:     "tool",
+				Content:  c.ToolResponse.Response,
+				ToolName: c.ToolResponse.Name,
+			})
+			continue
 		}
+
 		message := genRequestMessage{
 			Role:    string(c.Role),
 			Content: c.Text,
@@ -124,12 +522,12 @@ func (g *generator) Prompt(conversation ...prompt.Prompt) (*gen.Response, error)
 
 	body, err := json.Marshal(reqModel)
 	if err != nil {
-		return nil, fmt.Errorf("could not marshal open ai request, %w", err)
+		return nil, reqModel, fmt.Errorf("could not marshal ollama request, %w", err)
 	}
 
 	u, err := url.JoinPath(g.ollama.uri, "/api/chat")
 	if err != nil {
-		return nil, fmt.Errorf("could not join url, %w", err)
+		return nil, reqModel, fmt.Errorf("could not join url, %w", err)
 	}
 
 	ctx := g.request.Context
@@ -138,76 +536,19 @@ func (g *generator) Prompt(conversation ...prompt.Prompt) (*gen.Response, error)
 	}
 	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("could not create openai request, %w", err)
+		return nil, reqModel, fmt.Errorf("could not create ollama request, %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	reqc := atomic.AddInt64(&requestNo, 1)
-	g.ollama.log("[gen] request",
-		"request", reqc,
-		"model", g.request.Model.FQN(),
-		"tools", len(g.request.Tools) > 0,
-		"tool_choice", g.request.ToolConfig != nil,
-		"output_schema", g.request.OutputSchema != nil,
-		"system_prompt", g.request.SystemPrompt != "",
-		"temperature", g.request.Temperature,
-		"top_p", g.request.TopP,
-		"max_tokens", g.request.MaxTokens,
-		"stop_sequences", g.request.StopSequences,
-	)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("could not post openai request, %w", err)
-	}
-	defer resp.Body.Close()
-	body, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("could not read openai response, %w", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code, %d: err %s", resp.StatusCode, string(body))
-	}
-
-	var respModel genResponse
-	err = json.Unmarshal(body, &respModel)
-	if err != nil {
-		return nil, fmt.Errorf("could not decode openai response, %w", err)
-	}
+	return req, reqModel, nil
+}
 
-	res := &gen.Response{
-		Metadata: models.Metadata{
-			Model:          g.request.Model.FQN(),
-			InputTokens:    respModel.PromptEvalCount,
-			OutputTokens:   respModel.EvalCount,
-			ThinkingTokens: 0,
-			TotalTokens:    respModel.PromptEvalCount + respModel.EvalCount,
-		},
-	}
-	if len(respModel.Message.Content) > 0 {
-		res.Texts = []string{respModel.Message.Content}
+// unquote returns the json string content, as replied by a model constrained to regex or choices, unquoted. Content
+// that is not a json string is returned as is.
+func unquote(content string) string {
+	var text string
+	if json.Unmarshal([]byte(content), &text) != nil {
+		return content
 	}
-	if len(respModel.Message.ToolCalls) > 0 {
-		for _, t := range respModel.Message.ToolCalls {
-			args, err := json.Marshal(t.Function.Args)
-			if err != nil {
-				return nil, fmt.Errorf("could not marshal tool arguments, %w", err)
-			}
-			res.Tools = append(res.Tools, tools.Call{
-				Name:     t.Function.Name,
-				Argument: args,
-				Ref:      toolBelt[t.Function.Name],
-			})
-		}
-	}
-
-	g.ollama.log("[gen] response",
-		"request", reqc,
-		"model", g.request.Model.FQN(),
-		"token-input", res.Metadata.InputTokens,
-		"token-output", res.Metadata.OutputTokens,
-		"token-total", res.Metadata.TotalTokens,
-	)
-
-	return res, nil
+	return text
 }
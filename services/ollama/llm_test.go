@@ -0,0 +1,172 @@
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/tools"
+)
+
+func TestStream(t *testing.T) {
+	var got genRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		got = genRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		for _, chunk := range []string{
+			`{"message":{"role":"assistant","content":"","thinking":"The user wants a quote."},"done":false}`,
+			`{"message":{"role":"assistant","content":"Let me check."},"done":false}`,
+			`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_quote","arguments":{"symbol":"AAPL"}}}]},"done":false}`,
+			`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":10,"eval_count":5}`,
+		} {
+			_, _ = fmt.Fprintln(w, chunk)
+		}
+	}))
+	defer srv.Close()
+
+	quote := tools.NewTool("get_quote", tools.WithArgSchema(struct {
+		Symbol string `json:"symbol"`
+	}{}))
+	g := New(srv.URL).Generator().
+		Model(GenModel_llama_3_2).
+		SetTools(quote)
+
+	conversation := []prompt.Prompt{
+		prompt.AsUser("What is the price of MSFT?"),
+		prompt.AsToolCall("call_0", "get_quote", []byte(`{"symbol":"MSFT"}`)),
+		prompt.AsToolResponse("call_0", "get_quote", "200"),
+		prompt.AsUser("And AAPL?"),
+	}
+	stream, err := g.Stream(conversation...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var thinking, text string
+	var turn []prompt.Prompt
+	var calls []tools.Call
+	var metadata *gen.StreamResponse
+	for r := range stream {
+		switch r.Type {
+		case gen.TYPE_ERROR:
+			t.Fatal(r.Content)
+		case gen.TYPE_THINKING_DELTA:
+			thinking += r.Content
+		case gen.TYPE_DELTA:
+			text += r.Content
+		case gen.TYPE_BLOCK:
+			turn = append(turn, *r.Block)
+			if r.ToolCall != nil {
+				calls = append(calls, *r.ToolCall)
+			}
+		case gen.TYPE_METADATA:
+			metadata = r
+		}
+	}
+
+	if !got.Stream || len(got.Messages) != 4 || len(got.Messages[1].ToolCalls) != 1 ||
+		got.Messages[2].Role != "tool" || got.Messages[2].ToolName != "get_quote" {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	if thinking != "The user wants a quote." || text != "Let me check." {
+		t.Fatalf("unexpected deltas %q, %q", thinking, text)
+	}
+	if len(calls) != 1 || calls[0].ID == "" || string(calls[0].Argument) != `{"symbol":"AAPL"}` || calls[0].Ref == nil {
+		t.Fatalf("unexpected tool calls %+v", calls)
+	}
+	if len(turn) != 3 || turn[0].Role != prompt.ThinkingRole || turn[1].Text != "Let me check." || turn[2].Role != prompt.ToolCallRole {
+		t.Fatalf("unexpected turn %+v", turn)
+	}
+	if metadata == nil || metadata.Metadata.TotalTokens != 15 {
+		t.Fatalf("unexpected metadata %+v", metadata)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = nil
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","thinking":"Easy.","content":"Hello!"},"done":true,"prompt_eval_count":3,"eval_count":2}`))
+	}))
+	defer srv.Close()
+
+	quote := tools.NewTool("get_quote")
+	search := tools.NewTool("search")
+	model := GenModel_llama_3_2
+	model.Config = map[string]any{"num_ctx": 8192, "seed": float64(42), "keep_alive": "10m"}
+	g := New(srv.URL).Generator().
+		Model(model).
+		SetTools(quote, search).
+		SetToolConfig(tools.ToolChoice{Name: quote.Name}).
+		ThinkingBudget(1000)
+
+	resp, err := g.Prompt(prompt.AsUser("Hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, _ := got["options"].(map[string]any)
+	if options["num_ctx"] != float64(8192) || options["seed"] != float64(42) || got["keep_alive"] != "10m" || got["think"] != true {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	messages, _ := got["messages"].([]any)
+	system, _ := messages[0].(map[string]any)
+	if sent, _ := got["tools"].([]any); len(sent) != 1 || system["role"] != "system" ||
+		!strings.Contains(system["content"].(string), "You must call the tool get_quote") {
+		t.Fatalf("expected the tool choice to be emulated, got %+v", got)
+	}
+	if len(resp.Thinking) != 1 || resp.Thinking[0] != "Easy." || len(resp.Turn) != 2 || resp.Turn[0].Role != prompt.ThinkingRole {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	_, err = g.SetToolConfig(tools.NoTool).Prompt(prompt.AsUser("Hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["tools"]; ok {
+		t.Fatalf("expected no tools, got %+v", got["tools"])
+	}
+}
+
+func TestStreamChoices(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, chunk := range []string{
+			`{"message":{"role":"assistant","content":"\"posi"},"done":false}`,
+			`{"message":{"role":"assistant","content":"tive\""},"done":false}`,
+			`{"message":{"role":"assistant","content":""},"done":true}`,
+		} {
+			_, _ = fmt.Fprintln(w, chunk)
+		}
+	}))
+	defer srv.Close()
+
+	stream, err := New(srv.URL).Generator().
+		Model(GenModel_llama_3_2).
+		Choices("positive", "negative").
+		Stream(prompt.AsUser("I love bellman"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text string
+	var turn []prompt.Prompt
+	for r := range stream {
+		switch r.Type {
+		case gen.TYPE_ERROR:
+			t.Fatal(r.Content)
+		case gen.TYPE_DELTA:
+			text += r.Content
+		case gen.TYPE_BLOCK:
+			turn = append(turn, *r.Block)
+		}
+	}
+	if text != "positive" || len(turn) != 1 || turn[0].Text != "positive" {
+		t.Fatalf("expected the choice unquoted, got %q and %+v", text, turn)
+	}
+}
@@ -0,0 +1,150 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+)
+
+type ModelDetails struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// LocalModel is a model available on the ollama server, as returned by List.
+type LocalModel struct {
+	Name       string       `json:"name"`
+	Model      string       `json:"model"`
+	ModifiedAt string       `json:"modified_at"`
+	Size       int64        `json:"size"`
+	Digest     string       `json:"digest"`
+	Details    ModelDetails `json:"details"`
+}
+
+// ModelInfo is the information about a model, as returned by Show.
+type ModelInfo struct {
+	Modelfile    string         `json:"modelfile"`
+	Parameters   string         `json:"parameters"`
+	Template     string         `json:"template"`
+	Details      ModelDetails   `json:"details"`
+	ModelInfo    map[string]any `json:"model_info"`
+	Capabilities []string       `json:"capabilities"` // eg. completion, tools, vision, thinking and embedding
+}
+
+// GenModel returns a gen.Model named name, with the capabilities of the model info declared.
+func (m *ModelInfo) GenModel(name string) gen.Model {
+	model := gen.Model{
+		Provider:     Provider,
+		Name:         name,
+		SupportTools: slices.Contains(m.Capabilities, "tools"),
+		// all models are constrained to a json schema by the format parameter
+		SupportStructuredOutput: true,
+		TextOnly:                !slices.Contains(m.Capabilities, "vision"),
+	}
+	if slices.Contains(m.Capabilities, "vision") {
+		for mime, ok := range prompt.MIMEImages {
+			if ok {
+				model.InputContentTypes = append(model.InputContentTypes, mime)
+			}
+		}
+		slices.Sort(model.InputContentTypes)
+	}
+	for key, v := range m.ModelInfo {
+		if n, ok := v.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			model.InputMaxToken = int(n)
+		}
+	}
+	return model
+}
+
+// List returns the models available on the ollama server.
+func (g *Ollama) List(ctx context.Context) ([]LocalModel, error) {
+	var res struct {
+		Models []LocalModel `json:"models"`
+	}
+	err := g.call(ctx, http.MethodGet, "/api/tags", nil, &res)
+	if err != nil {
+		return nil, fmt.Errorf("could not list ollama models, %w", err)
+	}
+	return res.Models, nil
+}
+
+// Show returns information about a model, eg. its capabilities and parameters.
+func (g *Ollama) Show(ctx context.Context, name string) (*ModelInfo, error) {
+	var res ModelInfo
+	err := g.call(ctx, http.MethodPost, "/api/show", map[string]any{"model": name}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("could not show ollama model %s, %w", name, err)
+	}
+	return &res, nil
+}
+
+// Pull downloads a model from the ollama library, and returns once it is done. Pulling a large model can take a
+// long time, so consider the deadline of ctx.
+func (g *Ollama) Pull(ctx context.Context, name string) error {
+	var res struct {
+		Status string `json:"status"`
+	}
+	err := g.call(ctx, http.MethodPost, "/api/pull", map[string]any{"model": name, "stream": false}, &res)
+	if err != nil {
+		return fmt.Errorf("could not pull ollama model %s, %w", name, err)
+	}
+	if res.Status != "success" {
+		return fmt.Errorf("could not pull ollama model %s, status %s", name, res.Status)
+	}
+	g.log("[manage] pulled", "model", name)
+	return nil
+}
+
+func (g *Ollama) call(ctx context.Context, method string, path string, reqModel any, respModel any) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var body io.Reader
+	if reqModel != nil {
+		b, err := json.Marshal(reqModel)
+		if err != nil {
+			return fmt.Errorf("could not marshal request, %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	u, err := url.JoinPath(g.uri, path)
+	if err != nil {
+		return fmt.Errorf("could not join url, %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return fmt.Errorf("could not create request, %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not post request, %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		d, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code, %d, %s", resp.StatusCode, string(d))
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(respModel)
+	if err != nil {
+		return fmt.Errorf("could not decode response, %w", err)
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package ollama
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/modfin/bellman/models"
 	"github.com/modfin/bellman/models/embed"
@@ -11,12 +13,16 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
 )
 
 type embedRequest struct {
-	Model string `json:"model"`
-	Input string `json:"input"`
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
 }
 type embedResponse struct {
 	Embedding [][]float64 `json:"embeddings"`
@@ -26,17 +32,43 @@ type embedResponse struct {
 	PromptEvalCount int `json:"prompt_eval_count"`
 }
 
+const (
+	defaultEmbedBatchSize   = 64
+	defaultEmbedConcurrency = 1
+
+	// documentContextLength is the max number of characters of the document added to each chunk by EmbedDocument
+	documentContextLength = 4000
+)
+
 type Ollama struct {
 	uri string
 	Log *slog.Logger `json:"-"`
+
+	embedBatchSize   int
+	embedConcurrency int
 }
 
 func New(uri string) *Ollama {
 	return &Ollama{
-		uri: uri,
+		uri:              uri,
+		embedBatchSize:   defaultEmbedBatchSize,
+		embedConcurrency: defaultEmbedConcurrency,
 	}
 }
 
+// SetEmbedBatchSize sets the max number of texts sent to ollama in each embed request. Default 64.
+func (g *Ollama) SetEmbedBatchSize(size int) *Ollama {
+	g.embedBatchSize = max(size, 1)
+	return g
+}
+
+// SetEmbedConcurrency sets the max number of embed requests in flight at once. Default 1, ollama only runs
+// requests in parallel when OLLAMA_NUM_PARALLEL is set on the server.
+func (g *Ollama) SetEmbedConcurrency(concurrency int) *Ollama {
+	g.embedConcurrency = max(concurrency, 1)
+	return g
+}
+
 func (g *Ollama) log(msg string, args ...any) {
 	if g.Log == nil {
 		return
@@ -50,62 +82,61 @@ func (g *Ollama) Provider() string {
 
 func (g *Ollama) Embed(request *embed.Request) (*embed.Response, error) {
 	var reqc = atomic.AddInt64(&requestNo, 1)
+	if request.IsMultimodal() {
+		return nil, fmt.Errorf("multimodal inputs are not supported by ollama embed models")
+	}
 	if len(request.Texts) == 0 {
 		return nil, fmt.Errorf("no texts provided")
 	}
-	var embeddings [][]float64
-	var tokenTotal int
-	for _, text := range request.Texts {
-		reqModel := embedRequest{
-			Input: text,
-			Model: request.Model.Name,
-		}
-
-		body, err := json.Marshal(reqModel)
-		if err != nil {
-			return nil, fmt.Errorf("could not marshal openai request, %w", err)
-		}
-
-		u, err := url.JoinPath(g.uri, "/api/embed")
-		if err != nil {
-			return nil, fmt.Errorf("could not join url, %w", err)
-		}
-		req, err := http.NewRequest("POST", u, bytes.NewReader(body))
-		if err != nil {
-			return nil, fmt.Errorf("could not create openai request, %w", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := http.DefaultClient.Do(req)
+	if request.OutputDType.Quantized() {
+		return nil, fmt.Errorf("output dtype %s is not supported by ollama embed models", request.OutputDType)
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("could not post openai request, %w", err)
-		}
-		defer resp.Body.Close()
+	embeddings, tokenTotal, err := g.embed(request.Ctx, request.Model, request.Dimensions, request.Texts)
+	if err != nil {
+		return nil, err
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			d, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("unexpected status code, %d, %s", resp.StatusCode, string(d))
-		}
+	g.log("[embed] response", "request", reqc, "texts", len(request.Texts), "token-total", tokenTotal)
 
-		var respModel embedResponse
-		err = json.NewDecoder(resp.Body).Decode(&respModel)
+	return &embed.Response{
+		Embeddings: embeddings,
+		Metadata: models.Metadata{
+			Model:       request.Model.FQN(),
+			TotalTokens: tokenTotal,
+		},
+	}, nil
+}
 
-		if err != nil {
-			return nil, fmt.Errorf("could not decode openai response, %w", err)
-		}
+// EmbedDocument embeds each chunk together with the context of its document, since ollama has no contextualized
+// embedding models. The chunk comes first, followed by the beginning of the document, so that it is the context that
+// is truncated if the text is longer than the context of the model.
+func (g *Ollama) EmbedDocument(request *embed.DocumentRequest) (*embed.DocumentResponse, error) {
+	var reqc = atomic.AddInt64(&requestNo, 1)
+	if len(request.DocumentChunks) == 0 {
+		return nil, fmt.Errorf("no texts provided")
+	}
+	if request.OutputDType.Quantized() {
+		return nil, fmt.Errorf("output dtype %s is not supported by ollama embed models", request.OutputDType)
+	}
 
-		if len(respModel.Embedding) == 0 {
-			return nil, fmt.Errorf("no embeddings in response")
-		}
-		embeddings = append(embeddings, respModel.Embedding[0])
-		tokenTotal += respModel.PromptEvalCount
+	document := []rune(strings.Join(request.DocumentChunks, "\n"))
+	if len(document) > documentContextLength {
+		document = document[:documentContextLength]
+	}
+	texts := make([]string, len(request.DocumentChunks))
+	for i, chunk := range request.DocumentChunks {
+		texts[i] = chunk + "\n\nDocument:\n" + string(document)
+	}
 
+	embeddings, tokenTotal, err := g.embed(request.Ctx, request.Model, request.Dimensions, texts)
+	if err != nil {
+		return nil, err
 	}
 
-	g.log("[embed] response", "request", reqc, "token-total", tokenTotal)
+	g.log("[embed] document response", "request", reqc, "chunks", len(texts), "token-total", tokenTotal)
 
-	return &embed.Response{
+	return &embed.DocumentResponse{
 		Embeddings: embeddings,
 		Metadata: models.Metadata{
 			Model:       request.Model.FQN(),
@@ -114,8 +145,105 @@ func (g *Ollama) Embed(request *embed.Request) (*embed.Response, error) {
 	}, nil
 }
 
-func (g *Ollama) EmbedDocument(request *embed.DocumentRequest) (*embed.DocumentResponse, error) {
-	return nil, fmt.Errorf("not supported by ollama embed models")
+// embed embeds texts in batches of embedBatchSize, with at most embedConcurrency requests in flight.
+func (g *Ollama) embed(ctx context.Context, model embed.Model, dimensions int, texts []string) ([][]float64, int, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var batches [][]string
+	for batch := range slices.Chunk(texts, g.embedBatchSize) {
+		batches = append(batches, batch)
+	}
+
+	embeddings := make([][][]float64, len(batches))
+	tokens := make([]int, len(batches))
+	errs := make([]error, len(batches))
+
+	semaphore := make(chan struct{}, g.embedConcurrency)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			if ctx.Err() != nil {
+				errs[i] = ctx.Err()
+				return
+			}
+			embeddings[i], tokens[i], errs[i] = g.embedBatch(ctx, model, dimensions, batch)
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var res [][]float64
+	var tokenTotal int
+	for i := range batches {
+		if errs[i] != nil && !errors.Is(errs[i], context.Canceled) {
+			return nil, 0, errs[i]
+		}
+	}
+	for i := range batches {
+		if errs[i] != nil {
+			return nil, 0, errs[i]
+		}
+		res = append(res, embeddings[i]...)
+		tokenTotal += tokens[i]
+	}
+	return res, tokenTotal, nil
+}
+
+func (g *Ollama) embedBatch(ctx context.Context, model embed.Model, dimensions int, texts []string) ([][]float64, int, error) {
+	reqModel := embedRequest{
+		Input:      texts,
+		Model:      model.Name,
+		Dimensions: dimensions,
+	}
+
+	body, err := json.Marshal(reqModel)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not marshal ollama request, %w", err)
+	}
+
+	u, err := url.JoinPath(g.uri, "/api/embed")
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not join url, %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not create ollama request, %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not post ollama request, %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		d, _ := io.ReadAll(resp.Body)
+		return nil, 0, &embed.StatusError{StatusCode: resp.StatusCode, Message: string(d)}
+	}
+
+	var respModel embedResponse
+	err = json.NewDecoder(resp.Body).Decode(&respModel)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not decode ollama response, %w", err)
+	}
+
+	if len(respModel.Embedding) != len(texts) {
+		return nil, 0, fmt.Errorf("expected %d embeddings in response, got %d", len(texts), len(respModel.Embedding))
+	}
+	return respModel.Embedding, respModel.PromptEvalCount, nil
 }
 
 func (g *Ollama) Generator(options ...gen.Option) *gen.Generator {
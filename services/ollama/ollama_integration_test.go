@@ -0,0 +1,37 @@
+package ollama_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/services/ollama"
+	"github.com/modfin/bellman/testsuite"
+)
+
+func TestOllamaIntegration(t *testing.T) {
+	url := os.Getenv("OLLAMA_URL")
+	if url == "" {
+		t.Skip("OLLAMA_URL not set")
+	}
+	model := ollama.GenModel_llama_3_2
+	if name := os.Getenv("OLLAMA_MODEL"); name != "" {
+		model = gen.Model{Provider: ollama.Provider, Name: name}
+	}
+
+	client := ollama.New(url)
+	g := client.Generator(gen.WithModel(model))
+
+	testsuite.Run(t, g, testsuite.Capabilities{
+		Tools:               true,
+		StructuredOutput:    true,
+		Streaming:           true,
+		Agent:               true,
+		StreamAgentMultiHop: true,
+	})
+	testsuite.RunEmbed(t, client, ollama.EmbedModel_nomic_embed_text, testsuite.EmbedCapabilities{
+		Single:   true,
+		Many:     true,
+		Document: true,
+	})
+}
@@ -0,0 +1,59 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/modfin/bellman/models/embed"
+)
+
+func TestEmbedBatches(t *testing.T) {
+	var mu sync.Mutex
+	var inputs [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req embedRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		inputs = append(inputs, req.Input)
+		mu.Unlock()
+
+		var res embedResponse
+		for _, text := range req.Input {
+			res.Embedding = append(res.Embedding, []float64{float64(len(text))})
+			res.PromptEvalCount++
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	defer srv.Close()
+
+	client := New(srv.URL).SetEmbedBatchSize(2).SetEmbedConcurrency(2)
+	texts := []string{"a", "bb", "ccc", "dddd", "eeeee"}
+	resp, err := client.Embed(embed.NewManyRequest(context.Background(), EmbedModel_nomic_embed_text, texts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 3 || resp.Metadata.TotalTokens != 5 {
+		t.Fatalf("expected 3 batches and 5 tokens, got %v, %d", inputs, resp.Metadata.TotalTokens)
+	}
+	for i, emb := range resp.Embeddings {
+		if emb[0] != float64(len(texts[i])) {
+			t.Fatalf("expected embeddings in the order of the texts, got %v", resp.Embeddings)
+		}
+	}
+
+	inputs = nil
+	doc, err := client.EmbedDocument(embed.NewDocumentRequest(context.Background(), EmbedModel_nomic_embed_text, []string{"first", "second"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := fmt.Sprint(inputs)
+	if len(doc.Embeddings) != 2 || !strings.Contains(all, "second\n\nDocument:\nfirst\nsecond") {
+		t.Fatalf("expected chunks with their document, got %v", inputs)
+	}
+}
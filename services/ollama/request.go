@@ -1,9 +1,13 @@
 package ollama
 
+import "github.com/modfin/bellman/tools"
+
 type genRequestMessage struct {
-	Role    string   `json:"role"` // system, user, assistant, or tool
-	Content string   `json:"content,omitempty"`
-	Images  []string `json:"images,omitempty"`
+	Role      string     `json:"role"` // system, user, assistant, or tool
+	Content   string     `json:"content,omitempty"`
+	Images    []string   `json:"images,omitempty"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"` // for role tool
 }
 
 // https://github.com/ollama/ollama/blob/main/docs/modelfile.md#valid-parameters-and-values
@@ -19,6 +23,10 @@ type genRequestOption struct {
 	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
 
 	StopSequences []string `json:"stop,omitempty"`
+
+	// Runtime options, set through gen.Model.Config
+	NumCtx *int `json:"num_ctx,omitempty"` // size of the context window (Default: 2048, or 4096 in newer versions)
+	Seed   *int `json:"seed,omitempty"`    // random seed, for reproducible output
 }
 
 // / https://github.com/ollama/ollama/blob/main/docs/api.md#generate-a-chat-completion
@@ -28,11 +36,14 @@ type genRequest struct {
 
 	Format *JSONSchema `json:"format,omitempty"`
 
-	Option genRequestOption `json:"option,omitempty"`
-	Think  bool             `json:"think,omitempty"`
-	Stream bool             `json:"stream"`
+	Option    genRequestOption `json:"options,omitempty"`
+	Think     any              `json:"think,omitempty"`      // bool, or "low", "medium" and "high" for gpt-oss
+	KeepAlive any              `json:"keep_alive,omitempty"` // duration, eg "10m", or seconds. 0 unloads the model after the request
+	Stream    bool             `json:"stream"`
 
 	Tools []tool `json:"tools,omitempty"`
+
+	toolBelt map[string]*tools.Tool
 }
 
 type toolFunction struct {
@@ -3,6 +3,7 @@ package ollama
 type genResponseMessage struct {
 	Role      string     `json:"role"`
 	Content   string     `json:"content"`
+	Thinking  string     `json:"thinking,omitempty"`
 	ToolCalls []toolCall `json:"tool_calls"`
 }
 
@@ -12,6 +13,7 @@ type function struct {
 }
 
 type toolCall struct {
+	ID       string   `json:"id,omitempty"` // not sent by older versions of ollama
 	Function function `json:"function"`
 }
 
@@ -28,4 +30,6 @@ type genResponse struct {
 	PromptEvalDuration int    `json:"prompt_eval_duration"`
 	EvalCount          int    `json:"eval_count"`
 	EvalDuration       int    `json:"eval_duration"`
+
+	Error string `json:"error,omitempty"` // in stream chunks
 }
@@ -25,7 +25,8 @@ type JSONSchema struct {
 	Type any `json:"type,omitempty"`
 	// Description is the description of the schema.
 	Description string `json:"description,omitempty"`
-	Format      string `json:"format,omitempty"` // Format of the data, e.g. "email", "date-time", etc.
+	Format      string `json:"format,omitempty"`  // Format of the data, e.g. "email", "date-time", etc.
+	Pattern     string `json:"pattern,omitempty"` // regular expression, for strings
 	// Enum is used to restrict a value to a fixed set of values. It must be an array with at least
 	// one element, where each element is unique. You will probably only use this with strings.
 	Enum []any `json:"enum,omitempty"`
@@ -105,6 +106,9 @@ func fromBellmanSchema(bellmanSchema *schema.JSON) *JSONSchema {
 	if bellmanSchema.Format != nil {
 		def.Format = *bellmanSchema.Format
 	}
+	if bellmanSchema.Pattern != nil {
+		def.Pattern = *bellmanSchema.Pattern
+	}
 
 	return def
 }
@@ -6,8 +6,9 @@ import (
 
 func New(baseURL string, apiKey string) *openai.OpenAI {
 	return openai.NewCompatible(openai.CompatibleConfig{
-		Provider: Provider,
-		APIKey:   apiKey,
-		BaseURL:  baseURL,
+		Provider:          Provider,
+		APIKey:            apiKey,
+		BaseURL:           baseURL,
+		StructuredOutputs: true,
 	})
 }
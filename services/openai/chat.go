@@ -0,0 +1,633 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/tools"
+)
+
+// https://platform.openai.com/docs/api-reference/chat
+
+type chatMessage struct {
+	Role       string         `json:"role"` // system, user, assistant or tool
+	Content    any            `json:"content,omitempty"`
+	ToolCalls  []chatToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+}
+
+type chatContentPart struct {
+	Type     string        `json:"type"` // "text" | "image_url" | "file"
+	Text     string        `json:"text,omitempty"`
+	ImageURL *chatImageURL `json:"image_url,omitempty"`
+	File     *chatFile     `json:"file,omitempty"`
+}
+
+type chatFile struct {
+	FileID string `json:"file_id"`
+}
+
+type chatImageURL struct {
+	URL string `json:"url"`
+}
+
+type chatToolCall struct {
+	Index    int              `json:"index,omitempty"` // only in stream deltas
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"` // "function"
+	Function chatToolFunction `json:"function"`
+}
+
+type chatToolFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type chatTool struct {
+	Type     string         `json:"type"` // "function"
+	Function chatToolSchema `json:"function"`
+}
+
+type chatToolSchema struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  *JSONSchema `json:"parameters,omitempty"`
+	Strict      bool        `json:"strict,omitempty"`
+}
+
+type chatResponseFormat struct {
+	Type       string          `json:"type"` // "json_schema"
+	JSONSchema *chatJSONSchema `json:"json_schema,omitempty"`
+}
+
+type chatJSONSchema struct {
+	Name   string      `json:"name"`
+	Schema *JSONSchema `json:"schema"`
+	Strict bool        `json:"strict,omitempty"`
+}
+
+type chatStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+
+	Tools          []chatTool          `json:"tools,omitempty"`
+	ToolChoice     any                 `json:"tool_choice,omitempty"`
+	ResponseFormat *chatResponseFormat `json:"response_format,omitempty"`
+
+	MaxTokens           *int     `json:"max_tokens,omitempty"`            // compatible backends
+	MaxCompletionTokens *int     `json:"max_completion_tokens,omitempty"` // OpenAI
+	Temperature         *float64 `json:"temperature,omitempty"`
+	TopP                *float64 `json:"top_p,omitempty"`
+	TopK                *int     `json:"top_k,omitempty"` // not supported by OpenAI
+	FrequencyPenalty    *float64 `json:"frequency_penalty,omitempty"`
+	PresencePenalty     *float64 `json:"presence_penalty,omitempty"`
+	Stop                []string `json:"stop,omitempty"`
+
+	ReasoningEffort *ReasoningEffort `json:"reasoning_effort,omitempty"`
+	ServiceTier     *ServiceTier     `json:"service_tier,omitempty"`
+
+	Stream        bool               `json:"stream,omitempty"`
+	StreamOptions *chatStreamOptions `json:"stream_options,omitempty"`
+
+	StructuredOutputs *structuredOutputs `json:"structured_outputs,omitempty"` // not supported by OpenAI
+
+	toolBelt map[string]*tools.Tool
+}
+
+type chatResponseMessage struct {
+	Role             string         `json:"role"`
+	Content          string         `json:"content"`
+	ReasoningContent string         `json:"reasoning_content,omitempty"` // vLLM, llama.cpp
+	Reasoning        string         `json:"reasoning,omitempty"`         // newer vLLM
+	ToolCalls        []chatToolCall `json:"tool_calls,omitempty"`
+}
+
+type chatUsage struct {
+	PromptTokens            int `json:"prompt_tokens"`
+	CompletionTokens        int `json:"completion_tokens"`
+	TotalTokens             int `json:"total_tokens"`
+	CompletionTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+}
+
+type chatChoice struct {
+	Index        int                 `json:"index"`
+	Message      chatResponseMessage `json:"message"`
+	Delta        chatResponseMessage `json:"delta"` // only in stream chunks
+	FinishReason string              `json:"finish_reason"`
+}
+
+type chatResponse struct {
+	ID          string               `json:"id"`
+	Model       string               `json:"model"`
+	Choices     []chatChoice         `json:"choices"`
+	Usage       *chatUsage           `json:"usage,omitempty"`
+	ServiceTier *ServiceTier         `json:"service_tier,omitempty"`
+	Error       *openaiResponseError `json:"error,omitempty"`
+}
+
+func (g *generator) chatRequest(conversation ...prompt.Prompt) (*http.Request, chatRequest, error) {
+	reqModel := chatRequest{
+		Model:            g.request.Model.Name,
+		Temperature:      g.request.Temperature,
+		TopP:             g.request.TopP,
+		FrequencyPenalty: g.request.FrequencyPenalty,
+		PresencePenalty:  g.request.PresencePenalty,
+		Stop:             g.request.StopSequences,
+		Stream:           g.request.Stream,
+	}
+	if g.request.Model.Name == "" {
+		return nil, reqModel, fmt.Errorf("model is required")
+	}
+	if g.request.Stream {
+		reqModel.StreamOptions = &chatStreamOptions{IncludeUsage: true}
+	}
+
+	if g.openai.provider == Provider {
+		reqModel.MaxCompletionTokens = g.request.MaxTokens
+		if g.request.TopK != nil {
+			g.openai.log("[gen] dropping top_k (not supported by OpenAI)")
+		}
+	} else {
+		reqModel.MaxTokens = g.request.MaxTokens
+		reqModel.TopK = g.request.TopK
+	}
+
+	if !g.openai.structuredOutputs {
+		err := g.request.CheckConstraints()
+		if err != nil {
+			return nil, reqModel, err
+		}
+	} else if len(g.request.Constraints()) > 0 {
+		err := g.request.CheckConstraints(gen.ConstraintRegex, gen.ConstraintChoices, gen.ConstraintGrammar)
+		if err != nil {
+			return nil, reqModel, err
+		}
+		reqModel.StructuredOutputs = &structuredOutputs{
+			Regex:   g.request.Regex,
+			Choice:  g.request.Choices,
+			Grammar: g.request.Grammar,
+		}
+	}
+
+	if v, ok := g.request.Model.Config["service_tier"]; ok {
+		tier := ServiceTier(fmt.Sprintf("%v", v))
+		switch tier {
+		case ServiceTierAuto, ServiceTierDefault, ServiceTierFlex, ServiceTierPriority:
+			reqModel.ServiceTier = &tier
+		default:
+			return nil, reqModel, fmt.Errorf("unknown service tier: %s", v)
+		}
+	}
+
+	reqModel.toolBelt = map[string]*tools.Tool{}
+	for _, t := range g.request.Tools {
+		reqModel.Tools = append(reqModel.Tools, chatTool{
+			Type: "function",
+			Function: chatToolSchema{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  fromBellmanSchema(t.ArgumentSchema),
+				Strict:      g.request.StrictOutput,
+			},
+		})
+		reqModel.toolBelt[t.Name] = &t
+	}
+	if g.request.ToolConfig != nil {
+		switch g.request.ToolConfig.Name {
+		case tools.NoTool.Name, tools.AutoTool.Name, tools.RequiredTool.Name:
+			reqModel.ToolChoice = g.request.ToolConfig.Name
+		default:
+			reqModel.ToolChoice = map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": g.request.ToolConfig.Name},
+			}
+		}
+	}
+
+	if g.request.OutputSchema != nil {
+		reqModel.ResponseFormat = &chatResponseFormat{
+			Type: "json_schema",
+			JSONSchema: &chatJSONSchema{
+				Name:   "response",
+				Schema: fromBellmanSchema(g.request.OutputSchema),
+				Strict: g.request.StrictOutput,
+			},
+		}
+	}
+
+	if !g.request.Model.UsesAdaptiveThinking && g.request.ThinkingBudget != nil {
+		var effort ReasoningEffort
+		switch true {
+		case *g.request.ThinkingBudget == 0:
+			effort = ReasoningEffortNone
+		case *g.request.ThinkingBudget < 2_000:
+			effort = ReasoningEffortLow
+		case *g.request.ThinkingBudget < 10_000:
+			effort = ReasoningEffortMedium
+		default:
+			effort = ReasoningEffortHigh
+		}
+		reqModel.ReasoningEffort = &effort
+	}
+
+	if g.request.SystemPrompt != "" {
+		reqModel.Messages = append(reqModel.Messages, chatMessage{Role: "system", Content: g.request.SystemPrompt})
+	}
+	for _, c := range conversation {
+		switch c.Role {
+		case prompt.ToolResponseRole:
+			if c.ToolResponse == nil {
+				return nil, reqModel, fmt.Errorf("ToolResponse is required for role tool response")
+			}
+			reqModel.Messages = append(reqModel.Messages, chatMessage{
+				Role:       "tool",
+				ToolCallID: c.ToolResponse.ToolCallID,
+				Content:    c.ToolResponse.Response,
+			})
+		case prompt.ToolCallRole:
+			if c.ToolCall == nil {
+				return nil, reqModel, fmt.Errorf("ToolCall is required for role tool call")
+			}
+			call := chatToolCall{
+				ID:       c.ToolCall.ToolCallID,
+				Type:     "function",
+				Function: chatToolFunction{Name: c.ToolCall.Name, Arguments: string(c.ToolCall.Arguments)},
+			}
+			// parallel tool calls, and the text before them, belongs to the same assistant message
+			if n := len(reqModel.Messages); n > 0 && reqModel.Messages[n-1].Role == "assistant" {
+				reqModel.Messages[n-1].ToolCalls = append(reqModel.Messages[n-1].ToolCalls, call)
+				continue
+			}
+			reqModel.Messages = append(reqModel.Messages, chatMessage{Role: "assistant", ToolCalls: []chatToolCall{call}})
+		case prompt.ThinkingRole:
+			// reasoning is not replayed on /v1/chat/completions
+			continue
+		case prompt.AssistantRole:
+			reqModel.Messages = append(reqModel.Messages, chatMessage{Role: "assistant", Content: c.Text})
+		default: // prompt.UserRole
+			if c.Payload == nil {
+				reqModel.Messages = append(reqModel.Messages, chatMessage{Role: "user", Content: c.Text})
+				continue
+			}
+			var parts []chatContentPart
+			if c.Text != "" {
+				parts = append(parts, chatContentPart{Type: "text", Text: c.Text})
+			}
+			id, ok, err := fileID(c.Payload, g.openai.provider)
+			if err != nil {
+				return nil, reqModel, err
+			}
+			switch {
+			case ok && strings.HasPrefix(c.Payload.Mime, "image/"):
+				// file parts of chat completions are documents only, eg. pdf
+				return nil, reqModel, fmt.Errorf("uploaded image %s is not supported by %s chat completions, send the image as data or url, or use the responses api", id, g.openai.provider)
+			case ok:
+				parts = append(parts, chatContentPart{Type: "file", File: &chatFile{FileID: id}})
+			case c.Payload.Mime == "" || strings.HasPrefix(c.Payload.Mime, "image/"):
+				parts = append(parts, chatContentPart{Type: "image_url", ImageURL: &chatImageURL{URL: imagePayloadURL(c.Payload)}})
+			default:
+				// image_url is the only inline payload of chat completions, other data has to be uploaded as a file
+				return nil, reqModel, fmt.Errorf("payload of mime type %s is not supported by %s chat completions, only images and uploaded files", c.Payload.Mime, g.openai.provider)
+			}
+			reqModel.Messages = append(reqModel.Messages, chatMessage{Role: "user", Content: parts})
+		}
+	}
+
+	body, err := json.Marshal(reqModel)
+	if err != nil {
+		return nil, reqModel, fmt.Errorf("could not marshal %s request, %w", g.openai.provider, err)
+	}
+
+	u, err := url.JoinPath(g.openai.getBaseURL(g.request.Model.Name), "/v1/chat/completions")
+	if err != nil {
+		return nil, reqModel, fmt.Errorf("could not construct chat completions URL, %w", err)
+	}
+
+	ctx := g.request.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, reqModel, fmt.Errorf("could not create %s request, %w", g.openai.provider, err)
+	}
+	if g.openai.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+g.openai.apiKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, reqModel, nil
+}
+
+func (g *generator) logChatRequest(reqc int64) {
+	g.openai.log("[gen] request",
+		"request", reqc,
+		"api", APIChatCompletions,
+		"model", g.request.Model.FQN(),
+		"tools", len(g.request.Tools) > 0,
+		"tool_choice", g.request.ToolConfig != nil,
+		"output_schema", g.request.OutputSchema != nil,
+		"system_prompt", g.request.SystemPrompt != "",
+		"temperature", g.request.Temperature,
+		"top_p", g.request.TopP,
+		"max_tokens", g.request.MaxTokens,
+		"stop_sequences", g.request.StopSequences,
+		"thinking_budget", g.request.ThinkingBudget != nil,
+		"thinking_parts", g.request.ThinkingParts != nil,
+	)
+}
+
+func chatUsageToMetadata(model string, u *chatUsage) *models.Metadata {
+	m := &models.Metadata{Model: model}
+	if u == nil {
+		return m
+	}
+	m.InputTokens = u.PromptTokens
+	m.ThinkingTokens = u.CompletionTokensDetails.ReasoningTokens
+	m.OutputTokens = max(u.CompletionTokens-m.ThinkingTokens, 0)
+	m.TotalTokens = u.TotalTokens
+	return m
+}
+
+func (g *generator) promptChat(conversation ...prompt.Prompt) (*gen.Response, error) {
+	g.request.Stream = false
+	req, reqModel, err := g.chatRequest(conversation...)
+	if err != nil {
+		return nil, err
+	}
+
+	reqc := atomic.AddInt64(&requestNo, 1)
+	g.logChatRequest(reqc)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not post %s request, %w", g.openai.provider, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s response, %w", g.openai.provider, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code, %d: err %s", resp.StatusCode, string(body))
+	}
+
+	var respModel chatResponse
+	err = json.Unmarshal(body, &respModel)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %s response, %w", g.openai.provider, err)
+	}
+	if respModel.Error != nil && respModel.Error.Message != "" {
+		return nil, fmt.Errorf("%s response error: %s", g.openai.provider, respModel.Error.Message)
+	}
+	if len(respModel.Choices) == 0 {
+		return nil, fmt.Errorf("no choices in %s response", g.openai.provider)
+	}
+
+	res := &gen.Response{
+		Metadata: *chatUsageToMetadata(g.request.Model.FQN(), respModel.Usage),
+	}
+	if respModel.ServiceTier != nil {
+		res.Metadata.Other = map[string]any{"service_tier": *respModel.ServiceTier}
+	}
+
+	message := respModel.Choices[0].Message
+	thinking := message.ReasoningContent + message.Reasoning
+	if thinking != "" && g.request.ThinkingParts != nil && *g.request.ThinkingParts {
+		res.Thinking = []string{thinking}
+	}
+	if message.Content != "" {
+		res.Texts = []string{message.Content}
+		res.Turn = append(res.Turn, prompt.AsAssistant(message.Content))
+	}
+	for _, t := range message.ToolCalls {
+		res.Tools = append(res.Tools, tools.Call{
+			ID:       t.ID,
+			Name:     t.Function.Name,
+			Argument: []byte(t.Function.Arguments),
+			Ref:      reqModel.toolBelt[t.Function.Name],
+		})
+		res.Turn = append(res.Turn, prompt.AsToolCall(t.ID, t.Function.Name, []byte(t.Function.Arguments)))
+	}
+
+	g.openai.log("[gen] response",
+		"request", reqc,
+		"model", g.request.Model.FQN(),
+		"token-input", res.Metadata.InputTokens,
+		"token-output", res.Metadata.OutputTokens,
+		"token-thinking", res.Metadata.ThinkingTokens,
+		"token-total", res.Metadata.TotalTokens,
+	)
+
+	return res, nil
+}
+
+func (g *generator) streamChat(conversation ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
+	g.request.Stream = true
+	req, reqModel, err := g.chatRequest(conversation...)
+	if err != nil {
+		return nil, err
+	}
+
+	reqc := atomic.AddInt64(&requestNo, 1)
+	g.logChatRequest(reqc)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not post %s request, %w", g.openai.provider, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, errors.Join(fmt.Errorf("unexpected status code, %d, err: {%s}", resp.StatusCode, string(b)), err)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	stream := make(chan *gen.StreamResponse)
+
+	go func() {
+		defer resp.Body.Close()
+		defer close(stream)
+
+		defer func() {
+			stream <- &gen.StreamResponse{
+				Type: gen.TYPE_EOF,
+			}
+		}()
+
+		var text bytes.Buffer
+		calls := map[int]*chatToolCall{}
+		sent := map[int]int{} // length of the arguments of each call that has been streamed
+		var usage *chatUsage
+		var tier *ServiceTier
+
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 || bytes.HasPrefix(line, []byte(":")) {
+				continue
+			}
+			if !bytes.HasPrefix(line, []byte("data: ")) {
+				stream <- &gen.StreamResponse{
+					Type:    gen.TYPE_ERROR,
+					Content: "expected 'data' header from sse",
+				}
+				return
+			}
+			line = line[6:]
+			if bytes.Equal(line, []byte("[DONE]")) {
+				break
+			}
+
+			var chunk chatResponse
+			err := json.Unmarshal(line, &chunk)
+			if err != nil {
+				stream <- &gen.StreamResponse{
+					Type:    gen.TYPE_ERROR,
+					Content: fmt.Sprintf("could not unmarshal chunk, %v", err),
+				}
+				return
+			}
+			if chunk.Error != nil && chunk.Error.Message != "" {
+				stream <- &gen.StreamResponse{Type: gen.TYPE_ERROR, Content: chunk.Error.Message}
+				return
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			if chunk.ServiceTier != nil {
+				tier = chunk.ServiceTier
+			}
+
+			for _, choice := range chunk.Choices {
+				delta := choice.Delta
+				if thinking := delta.ReasoningContent + delta.Reasoning; thinking != "" &&
+					g.request.ThinkingParts != nil && *g.request.ThinkingParts {
+					stream <- &gen.StreamResponse{
+						Type:    gen.TYPE_THINKING_DELTA,
+						Role:    prompt.AssistantRole,
+						Index:   choice.Index,
+						Content: thinking,
+					}
+				}
+				if delta.Content != "" {
+					text.WriteString(delta.Content)
+					stream <- &gen.StreamResponse{
+						Type:    gen.TYPE_DELTA,
+						Role:    prompt.AssistantRole,
+						Index:   choice.Index,
+						Content: delta.Content,
+					}
+				}
+				for _, t := range delta.ToolCalls {
+					call, ok := calls[t.Index]
+					if !ok {
+						call = &chatToolCall{Index: t.Index}
+						calls[t.Index] = call
+					}
+					if t.ID != "" {
+						call.ID = t.ID
+					}
+					if t.Function.Name != "" {
+						call.Function.Name = t.Function.Name
+					}
+					call.Function.Arguments += t.Function.Arguments
+					// some backends send the id and name after the first delta, arguments are held back until both are known
+					if call.ID == "" || call.Function.Name == "" {
+						continue
+					}
+					stream <- &gen.StreamResponse{
+						Type:  gen.TYPE_DELTA,
+						Role:  prompt.ToolCallRole,
+						Index: t.Index,
+						ToolCall: &tools.Call{
+							ID:       call.ID,
+							Name:     call.Function.Name,
+							Argument: []byte(call.Function.Arguments[sent[t.Index]:]),
+							Ref:      reqModel.toolBelt[call.Function.Name],
+						},
+					}
+					sent[t.Index] = len(call.Function.Arguments)
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			stream <- &gen.StreamResponse{
+				Type:    gen.TYPE_ERROR,
+				Content: fmt.Sprintf("sse read error: %v", err),
+			}
+			return
+		}
+
+		// the replay-ready turn, text before tool calls
+		if text.Len() > 0 {
+			p := prompt.AsAssistant(text.String())
+			stream <- &gen.StreamResponse{
+				Type:  gen.TYPE_BLOCK,
+				Role:  prompt.AssistantRole,
+				Block: &p,
+			}
+		}
+		indexes := make([]int, 0, len(calls))
+		for i := range calls {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		for _, i := range indexes {
+			if calls[i].ID == "" || calls[i].Function.Name == "" {
+				stream <- &gen.StreamResponse{
+					Type:    gen.TYPE_ERROR,
+					Content: fmt.Sprintf("tool call %d without name or id", i),
+				}
+				return
+			}
+		}
+		for _, i := range indexes {
+			call := calls[i]
+			p := prompt.AsToolCall(call.ID, call.Function.Name, []byte(call.Function.Arguments))
+			stream <- &gen.StreamResponse{
+				Type:  gen.TYPE_BLOCK,
+				Role:  prompt.ToolCallRole,
+				Index: i,
+				Block: &p,
+				ToolCall: &tools.Call{
+					ID:       call.ID,
+					Name:     call.Function.Name,
+					Argument: []byte(call.Function.Arguments),
+					Ref:      reqModel.toolBelt[call.Function.Name],
+				},
+			}
+		}
+
+		metadata := chatUsageToMetadata(g.request.Model.FQN(), usage)
+		if tier != nil {
+			metadata.Other = map[string]any{"service_tier": *tier}
+		}
+		stream <- &gen.StreamResponse{
+			Type:     gen.TYPE_METADATA,
+			Metadata: metadata,
+		}
+	}()
+
+	return stream, nil
+}
@@ -0,0 +1,207 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/modfin/bellman/models/files"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/tools"
+)
+
+func TestChatCompletions(t *testing.T) {
+	var got chatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		got = chatRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		if got.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, chunk := range []string{
+				`{"choices":[{"index":0,"delta":{"role":"assistant","content":"Let me check."}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_quote","arguments":"{\"symbol\":"}}]}}]}`,
+				`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"AAPL\"}"}}]}}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`,
+				`[DONE]`,
+			} {
+				_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
+			}
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"AAPL is at 100"}}],
+			"usage":{"prompt_tokens":20,"completion_tokens":6,"total_tokens":26}}`))
+	}))
+	defer srv.Close()
+
+	client := NewCompatible(CompatibleConfig{Provider: "local", BaseURL: srv.URL, API: APIChatCompletions})
+	quote := tools.NewTool("get_quote", tools.WithArgSchema(struct {
+		Symbol string `json:"symbol"`
+	}{}))
+	g := client.Generator().
+		Model(gen.Model{Provider: "local", Name: "llama"}).
+		System("You are a stock bot").
+		SetTools(quote).
+		StopAt("\n\n").
+		FrequencyPenalty(0.5)
+
+	stream, err := g.Stream(prompt.AsUser("What is the price of AAPL?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var turn []prompt.Prompt
+	var calls []tools.Call
+	var metadata *gen.StreamResponse
+	for r := range stream {
+		switch r.Type {
+		case gen.TYPE_ERROR:
+			t.Fatal(r.Content)
+		case gen.TYPE_BLOCK:
+			turn = append(turn, *r.Block)
+			if r.ToolCall != nil {
+				calls = append(calls, *r.ToolCall)
+			}
+		case gen.TYPE_METADATA:
+			metadata = r
+		}
+	}
+	if len(got.Messages) != 2 || got.Messages[0].Role != "system" || len(got.Tools) != 1 ||
+		len(got.Stop) != 1 || got.FrequencyPenalty == nil || got.StreamOptions == nil {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	if len(calls) != 1 || calls[0].ID != "call_1" || string(calls[0].Argument) != `{"symbol":"AAPL"}` || calls[0].Ref == nil {
+		t.Fatalf("unexpected tool calls %+v", calls)
+	}
+	if len(turn) != 2 || turn[0].Text != "Let me check." || turn[1].Role != prompt.ToolCallRole {
+		t.Fatalf("unexpected turn %+v", turn)
+	}
+	if metadata == nil || metadata.Metadata.TotalTokens != 15 {
+		t.Fatalf("unexpected metadata %+v", metadata)
+	}
+
+	conversation := append([]prompt.Prompt{prompt.AsUser("What is the price of AAPL?")}, turn...)
+	conversation = append(conversation, prompt.AsToolResponse("call_1", "get_quote", "100"))
+	resp, err := g.Prompt(conversation...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Messages) != 4 || len(got.Messages[2].ToolCalls) != 1 || got.Messages[2].Content != "Let me check." ||
+		got.Messages[3].Role != "tool" || got.Messages[3].ToolCallID != "call_1" {
+		t.Fatalf("unexpected messages %+v", got.Messages)
+	}
+	text, err := resp.AsText()
+	if err != nil || text != "AAPL is at 100" || resp.Metadata.TotalTokens != 26 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	var got chatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = chatRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"positive"}}]}`))
+	}))
+	defer srv.Close()
+
+	model := gen.Model{Provider: "local", Name: "llama"}
+	_, err := NewCompatible(CompatibleConfig{Provider: "local", BaseURL: srv.URL, API: APIChatCompletions}).
+		Generator().Model(model).Choices("positive", "negative").Prompt(prompt.AsUser("I love bellman"))
+	var capErr *gen.CapabilityError
+	if !errors.As(err, &capErr) {
+		t.Fatalf("expected a capability error without structured outputs, got %v", err)
+	}
+
+	_, err = NewCompatible(CompatibleConfig{Provider: "local", BaseURL: srv.URL, API: APIChatCompletions, StructuredOutputs: true}).
+		Generator().Model(model).Choices("positive", "negative").Prompt(prompt.AsUser("I love bellman"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.StructuredOutputs == nil || len(got.StructuredOutputs.Choice) != 2 {
+		t.Fatalf("expected the choices in structured_outputs, got %+v", got.StructuredOutputs)
+	}
+}
+
+func TestChatStreamLateToolCallID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"symbol\":"}}]}}]}`,
+			`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"get_quote","arguments":"\"AAPL\"}"}}]}}]}`,
+			`[DONE]`,
+		} {
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewCompatible(CompatibleConfig{Provider: "local", BaseURL: srv.URL, API: APIChatCompletions})
+	stream, err := client.Generator().
+		Model(gen.Model{Provider: "local", Name: "llama"}).
+		SetTools(tools.NewTool("get_quote", tools.WithArgSchema(struct {
+			Symbol string `json:"symbol"`
+		}{}))).
+		Stream(prompt.AsUser("What is the price of AAPL?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deltas string
+	var call *tools.Call
+	for r := range stream {
+		switch {
+		case r.Type == gen.TYPE_ERROR:
+			t.Fatal(r.Content)
+		case r.Type == gen.TYPE_DELTA && r.ToolCall != nil:
+			deltas += string(r.ToolCall.Argument)
+		case r.Type == gen.TYPE_BLOCK && r.ToolCall != nil:
+			call = r.ToolCall
+		}
+	}
+	if deltas != `{"symbol":"AAPL"}` || call == nil || call.ID != "call_1" || string(call.Argument) != deltas {
+		t.Fatalf("expected the arguments to be streamed once the id is known, got %q and %+v", deltas, call)
+	}
+}
+
+func TestChatPayloads(t *testing.T) {
+	var got chatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = chatRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"a cat"}}]}`))
+	}))
+	defer srv.Close()
+
+	g := NewCompatible(CompatibleConfig{Provider: "local", BaseURL: srv.URL, API: APIChatCompletions}).
+		Generator().Model(gen.Model{Provider: "local", Name: "llama"})
+	_, err := g.Prompt(prompt.AsUserWithData(prompt.MimeImagePNG, []byte("png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Messages) != 1 {
+		t.Fatalf("expected the image to be sent, got %+v", got.Messages)
+	}
+
+	_, err = g.Prompt(prompt.AsUserWithData(prompt.MimeApplicationPDF, []byte("%PDF-1.7")))
+	if err == nil {
+		t.Fatal("expected an error for a pdf payload")
+	}
+
+	_, err = g.Prompt(prompt.AsUserWithURI(prompt.MimeApplicationPDF, files.URI("local", "file-1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts, ok := got.Messages[0].Content.([]any); !ok || len(parts) != 1 || parts[0].(map[string]any)["type"] != "file" {
+		t.Fatalf("expected the uploaded pdf as a file part, got %+v", got.Messages[0].Content)
+	}
+
+	_, err = g.Prompt(prompt.AsUserWithURI(prompt.MimeImagePNG, files.URI("local", "file-2")))
+	if err == nil {
+		t.Fatal("expected an error for an uploaded image")
+	}
+}
@@ -0,0 +1,47 @@
+package openai
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/modfin/bellman/models/embed"
+)
+
+func TestEmbedBase64(t *testing.T) {
+	var got embedRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		var data []byte
+		for range got.Dimensions {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(0.25))
+		}
+		_, _ = fmt.Fprintf(w, `{"data":[{"index":0,"embedding":%q}],"usage":{"total_tokens":1}}`, base64.StdEncoding.EncodeToString(data))
+	}))
+	defer srv.Close()
+
+	client := New("key").SetBaseURL(srv.URL)
+	req := embed.NewSingleRequest(context.Background(), EmbedModel_text3_small, "text").WithDimensions(4)
+	res, err := client.Embed(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.EncodingFormat != "base64" || got.Dimensions != 4 {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	vec, err := res.Single()
+	if err != nil || len(vec) != 4 || vec[0] != 0.25 {
+		t.Fatalf("unexpected embedding %v, %v", vec, err)
+	}
+
+	_, err = client.Embed(req.WithOutputDType(embed.DTypeInt8))
+	if err == nil {
+		t.Fatal("expected int8 to be unsupported")
+	}
+}
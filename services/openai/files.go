@@ -0,0 +1,177 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/modfin/bellman/models/files"
+)
+
+// filePurpose is the purpose of uploaded files, ie. to be used as input of prompts.
+const filePurpose = "user_data"
+
+type fileObject struct {
+	ID        string `json:"id"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+type fileList struct {
+	Data    []fileObject `json:"data"`
+	HasMore bool         `json:"has_more"`
+}
+
+func (g *OpenAI) toFile(f fileObject, mime string) files.File {
+	return files.File{
+		Provider:  g.provider,
+		ID:        f.ID,
+		Name:      f.Filename,
+		Mime:      mime,
+		Size:      f.Bytes,
+		CreatedAt: time.Unix(f.CreatedAt, 0),
+		URI:       files.URI(g.provider, f.ID),
+	}
+}
+
+// Upload uploads a file with the Files API, to be used in prompts by its URI.
+func (g *OpenAI) Upload(request *files.UploadRequest) (*files.File, error) {
+	var reqc = atomic.AddInt64(&requestNo, 1)
+	if request.Reader == nil {
+		return nil, errors.New("no file content provided")
+	}
+	u, err := url.JoinPath(g.getBaseURL(""), "/v1/files")
+	if err != nil {
+		return nil, fmt.Errorf("could not construct files URL, %w", err)
+	}
+
+	body, contentType := multipartFile(request, map[string]string{"purpose": filePurpose})
+	req, err := http.NewRequestWithContext(request.Context(), "POST", u, body)
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s request, %w", g.provider, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	var file fileObject
+	err = g.doFiles(req, &file)
+	if err != nil {
+		return nil, err
+	}
+	g.log("[files] uploaded", "request", reqc, "id", file.ID, "bytes", file.Bytes)
+	res := g.toFile(file, request.Mime)
+	return &res, nil
+}
+
+// List returns the uploaded files. The Files API does not keep the mime type of files, so it is not set.
+func (g *OpenAI) List(ctx context.Context) ([]files.File, error) {
+	var res []files.File
+	after := ""
+	for {
+		u, err := url.JoinPath(g.getBaseURL(""), "/v1/files")
+		if err != nil {
+			return nil, fmt.Errorf("could not construct files URL, %w", err)
+		}
+		query := url.Values{"purpose": {filePurpose}, "limit": {"10000"}}
+		if after != "" {
+			query.Set("after", after)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", u+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not create %s request, %w", g.provider, err)
+		}
+		var list fileList
+		err = g.doFiles(req, &list)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range list.Data {
+			res = append(res, g.toFile(f, ""))
+		}
+		if !list.HasMore || len(list.Data) == 0 {
+			return res, nil
+		}
+		after = list.Data[len(list.Data)-1].ID
+	}
+}
+
+func (g *OpenAI) Delete(ctx context.Context, id string) error {
+	u, err := url.JoinPath(g.getBaseURL(""), "/v1/files", id)
+	if err != nil {
+		return fmt.Errorf("could not construct files URL, %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "DELETE", u, nil)
+	if err != nil {
+		return fmt.Errorf("could not create %s request, %w", g.provider, err)
+	}
+	return g.doFiles(req, nil)
+}
+
+func (g *OpenAI) doFiles(req *http.Request, v any) error {
+	if g.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+g.apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send %s files request, %w", g.provider, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, err := io.ReadAll(resp.Body)
+		return errors.Join(fmt.Errorf("unexpected status code, %d, err: {%s}", resp.StatusCode, string(b)), err)
+	}
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("could not decode %s files response, %w", g.provider, err)
+	}
+	return nil
+}
+
+// multipartFile returns a multipart/form-data body of the fields and the file of request, and its content type.
+// The file is streamed through a pipe, rather than read into memory, which is closed by the http client when done.
+func multipartFile(request *files.UploadRequest, fields map[string]string) (io.ReadCloser, string) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	go func() {
+		err := func() error {
+			for k, v := range fields {
+				err := w.WriteField(k, v)
+				if err != nil {
+					return err
+				}
+			}
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(request.Name)))
+			if request.Mime != "" {
+				header.Set("Content-Type", request.Mime)
+			}
+			part, err := w.CreatePart(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(part, request.Reader)
+			if err != nil {
+				return err
+			}
+			return w.Close()
+		}()
+		pw.CloseWithError(err)
+	}()
+	return pr, w.FormDataContentType()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
@@ -0,0 +1,93 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/modfin/bellman/models/files"
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+)
+
+func TestFiles(t *testing.T) {
+	var deleted string
+	var sent struct {
+		Input []map[string]any `json:"input"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1/files":
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b, _ := io.ReadAll(file)
+			if r.FormValue("purpose") != filePurpose || string(b) != "%PDF-1.7" || header.Header.Get("Content-Type") != "application/pdf" {
+				t.Errorf("unexpected upload %s, %q, %v", r.FormValue("purpose"), b, header.Header)
+			}
+			_, _ = fmt.Fprintf(w, `{"id":"file-1","bytes":%d,"created_at":1700000000,"filename":%q}`, len(b), header.Filename)
+		case r.Method == "GET" && r.URL.Path == "/v1/files":
+			if r.URL.Query().Get("after") == "" {
+				_, _ = w.Write([]byte(`{"data":[{"id":"file-1"}],"has_more":true}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":[{"id":"file-2"}],"has_more":false}`))
+		case r.Method == "DELETE":
+			deleted = strings.TrimPrefix(r.URL.Path, "/v1/files/")
+			_, _ = w.Write([]byte(`{"id":"file-1","deleted":true}`))
+		case r.URL.Path == "/v1/responses":
+			_ = json.NewDecoder(r.Body).Decode(&sent)
+			_, _ = w.Write([]byte(`{"output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"a report"}]}]}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	client := New("key").SetBaseURL(srv.URL)
+	ctx := context.Background()
+
+	file, err := client.Upload(files.NewUploadRequest(ctx, "report.pdf", "application/pdf", strings.NewReader("%PDF-1.7")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.ID != "file-1" || file.Size != 8 || file.Name != "report.pdf" || file.URI != "bellman-file://OpenAI/file-1" {
+		t.Fatalf("unexpected file %+v", file)
+	}
+
+	list, err := client.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[1].ID != "file-2" {
+		t.Fatalf("expected both pages, got %+v", list)
+	}
+
+	_, err = client.Generator().Model(gen.Model{Provider: Provider, Name: "gpt"}).
+		Prompt(prompt.AsUserWithURI(file.Mime, file.URI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := sent.Input[0]["content"].([]any)[1].(map[string]any)
+	if content["type"] != "input_file" || content["file_id"] != "file-1" {
+		t.Fatalf("expected the file by id, got %v", content)
+	}
+
+	_, err = client.Generator().Model(gen.Model{Provider: Provider, Name: "gpt"}).
+		Prompt(prompt.AsUserWithURI("application/pdf", files.URI("Anthropic", "file_1")))
+	if err == nil {
+		t.Fatal("expected an error for a file of another provider")
+	}
+
+	err = client.Delete(ctx, file.ID)
+	if err != nil || deleted != "file-1" {
+		t.Fatalf("expected file-1 to be deleted, got %q, %v", deleted, err)
+	}
+}
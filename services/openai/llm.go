@@ -11,9 +11,11 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync/atomic"
 
 	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/files"
 	"github.com/modfin/bellman/models/gen"
 	"github.com/modfin/bellman/prompt"
 	"github.com/modfin/bellman/tools"
@@ -36,6 +38,9 @@ type streamingFunctionCall struct {
 }
 
 func (g *generator) Stream(conversation ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
+	if g.openai.api == APIChatCompletions {
+		return g.streamChat(conversation...)
+	}
 	g.request.Stream = true
 	req, reqModel, err := g.prompt(conversation...)
 	if err != nil {
@@ -282,6 +287,9 @@ func (g *generator) Stream(conversation ...prompt.Prompt) (<-chan *gen.StreamRes
 
 }
 func (g *generator) Prompt(conversation ...prompt.Prompt) (*gen.Response, error) {
+	if g.openai.api == APIChatCompletions {
+		return g.promptChat(conversation...)
+	}
 
 	req, reqModel, err := g.prompt(conversation...)
 	if err != nil {
@@ -488,6 +496,23 @@ func (g *generator) prompt(conversation ...prompt.Prompt) (*http.Request, genReq
 		}
 	}
 
+	if !g.openai.structuredOutputs {
+		err := g.request.CheckConstraints()
+		if err != nil {
+			return nil, reqModel, err
+		}
+	} else if len(g.request.Constraints()) > 0 {
+		err := g.request.CheckConstraints(gen.ConstraintRegex, gen.ConstraintChoices, gen.ConstraintGrammar)
+		if err != nil {
+			return nil, reqModel, err
+		}
+		reqModel.StructuredOutputs = &structuredOutputs{
+			Regex:   g.request.Regex,
+			Choice:  g.request.Choices,
+			Grammar: g.request.Grammar,
+		}
+	}
+
 	if !g.request.Model.UsesAdaptiveThinking && g.request.ThinkingBudget != nil {
 		var reffort ReasoningEffort
 		switch true {
@@ -569,10 +594,21 @@ func (g *generator) prompt(conversation ...prompt.Prompt) (*http.Request, genReq
 				},
 			}
 			if c.Payload != nil {
-				item.Content = append(item.Content, messageContent{
-					Type:     "input_image",
-					ImageURL: new(imagePayloadURL(c.Payload)),
-				})
+				id, ok, err := fileID(c.Payload, g.openai.provider)
+				if err != nil {
+					return nil, reqModel, err
+				}
+				switch {
+				case ok && strings.HasPrefix(c.Payload.Mime, "image/"):
+					item.Content = append(item.Content, messageContent{Type: "input_image", FileID: new(id)})
+				case ok:
+					item.Content = append(item.Content, messageContent{Type: "input_file", FileID: new(id)})
+				default:
+					item.Content = append(item.Content, messageContent{
+						Type:     "input_image",
+						ImageURL: new(imagePayloadURL(c.Payload)),
+					})
+				}
 			}
 			input = append(input, item)
 		}
@@ -605,6 +641,19 @@ func (g *generator) prompt(conversation ...prompt.Prompt) (*http.Request, genReq
 	return req, reqModel, err
 }
 
+// fileID returns the id of the file of p, if its uri is of a file uploaded to provider, see files.URI. Files of other
+// providers are an error.
+func fileID(p *prompt.Payload, provider string) (string, bool, error) {
+	fileProvider, id, ok := files.ParseURI(p.Uri)
+	if !ok {
+		return "", false, nil
+	}
+	if fileProvider != provider {
+		return "", false, fmt.Errorf("file %s is uploaded to %s, and cannot be used with %s", id, fileProvider, provider)
+	}
+	return id, true, nil
+}
+
 func imagePayloadURL(p *prompt.Payload) string {
 	if p.Uri != "" {
 		return p.Uri
@@ -345,6 +345,8 @@ var EmbedModel_text3_small = embed.Model{
 	Name:             "text-embedding-3-small",
 	Description:      "Most capable embedding Model for both english and non-english tasks",
 	InputMaxTokens:   8191,
+	MaxRequestTexts:  2048,
+	MaxRequestTokens: 300000,
 	OutputDimensions: 1536,
 }
 var EmbedModel_text3_large = embed.Model{
@@ -352,6 +354,8 @@ var EmbedModel_text3_large = embed.Model{
 	Name:             "text-embedding-3-large",
 	Description:      "Increased performance over 2nd generation ada embedding Model",
 	InputMaxTokens:   8191,
+	MaxRequestTexts:  2048,
+	MaxRequestTokens: 300000,
 	OutputDimensions: 3072,
 }
 var EmbedModel_text_ada_002 = embed.Model{
@@ -359,6 +363,8 @@ var EmbedModel_text_ada_002 = embed.Model{
 	Name:             "text-embedding-ada-002",
 	Description:      "Most capable 2nd generation embedding Model, replacing 16 first generation models",
 	InputMaxTokens:   8191,
+	MaxRequestTexts:  2048,
+	MaxRequestTokens: 300000,
 	OutputDimensions: 1536,
 }
 
@@ -20,14 +20,15 @@ type embedRequest struct {
 	Input          []string `json:"input"`
 	Model          string   `json:"model"`
 	EncodingFormat string   `json:"encoding_format"`
+	Dimensions     int      `json:"dimensions,omitempty"` // text-embedding-3 and later
 }
 
 type embedResponse struct {
 	Object string `json:"object"`
 	Data   []struct {
-		Object    string    `json:"object"`
-		Index     int       `json:"index"`
-		Embedding []float64 `json:"embedding"`
+		Object    string          `json:"object"`
+		Index     int             `json:"index"`
+		Embedding json.RawMessage `json:"embedding"` // []float64, or a base64 string
 	} `json:"data"`
 	Model string `json:"model"`
 	Usage struct {
@@ -36,23 +37,53 @@ type embedResponse struct {
 	} `json:"usage"`
 }
 
+// API is the wire format used for generation.
+type API string
+
+const (
+	// APIResponses is /v1/responses, the default.
+	APIResponses API = "responses"
+	// APIChatCompletions is /v1/chat/completions, for backends that does not implement /v1/responses, eg. older
+	// vLLM versions, llama.cpp server and LM Studio.
+	APIChatCompletions API = "chat_completions"
+)
+
+// ParseAPI returns the API named s, where empty means APIResponses.
+func ParseAPI(s string) (API, error) {
+	switch api := API(s); api {
+	case "", APIResponses, APIChatCompletions:
+		return api, nil
+	}
+	return "", fmt.Errorf("unknown api %q, expected %q or %q", s, APIResponses, APIChatCompletions)
+}
+
 type OpenAI struct {
 	apiKey      string
 	provider    string
 	baseURL     string
 	baseURLFunc func(model string) string
-	Log         *slog.Logger `json:"-"`
+	api         API
+	// structuredOutputs is set for backends that constrain the output with structured_outputs, see
+	// CompatibleConfig.StructuredOutputs
+	structuredOutputs bool
+	Log               *slog.Logger `json:"-"`
 }
 
 // CompatibleConfig configures an OpenAI-compatible backend (xAI, vLLM, Fireworks,
 // oMLX, etc.) that speaks the /v1/responses (and optionally /v1/embeddings) API.
 // Exactly one of BaseURL or BaseURLFunc should be set; if both are provided,
-// BaseURLFunc wins.
+// BaseURLFunc wins. API selects the /v1/chat/completions wire format instead,
+// when set to APIChatCompletions. StructuredOutputs is set for backends, eg.
+// vLLM, that support regex, choices and grammar constraints through the
+// structured_outputs field; requests with constraints to other backends return
+// a *gen.CapabilityError.
 type CompatibleConfig struct {
-	Provider    string
-	APIKey      string
-	BaseURL     string
-	BaseURLFunc func(model string) string
+	Provider          string
+	APIKey            string
+	BaseURL           string
+	BaseURLFunc       func(model string) string
+	API               API
+	StructuredOutputs bool
 }
 
 func New(key string) *OpenAI {
@@ -72,10 +103,12 @@ func NewCompatible(cfg CompatibleConfig) *OpenAI {
 		name = Provider
 	}
 	return &OpenAI{
-		apiKey:      cfg.APIKey,
-		provider:    name,
-		baseURL:     cfg.BaseURL,
-		baseURLFunc: cfg.BaseURLFunc,
+		apiKey:            cfg.APIKey,
+		provider:          name,
+		baseURL:           cfg.BaseURL,
+		baseURLFunc:       cfg.BaseURLFunc,
+		api:               cfg.API,
+		structuredOutputs: cfg.StructuredOutputs,
 	}
 }
 
@@ -93,13 +126,24 @@ func (g *OpenAI) Provider() string {
 
 func (g *OpenAI) Embed(request *embed.Request) (*embed.Response, error) {
 	var reqc = atomic.AddInt64(&requestNo, 1)
+	if request.IsMultimodal() {
+		return nil, fmt.Errorf("multimodal inputs are not supported by %s embed models", g.provider)
+	}
 	if len(request.Texts) == 0 {
 		return nil, fmt.Errorf("no texts provided")
 	}
+	if request.OutputDType.Quantized() {
+		return nil, fmt.Errorf("output dtype %s is not supported by %s embed models", request.OutputDType, g.provider)
+	}
 	reqModel := embedRequest{
 		Input:          request.Texts,
 		Model:          request.Model.Name,
 		EncodingFormat: "float",
+		Dimensions:     request.Dimensions,
+	}
+	// base64 is a quarter of the size, and faster to decode, but not supported by all compatible backends
+	if g.provider == Provider {
+		reqModel.EncodingFormat = "base64"
 	}
 
 	u, err := url.JoinPath(g.getBaseURL(request.Model.Name), "/v1/embeddings")
@@ -130,7 +174,7 @@ func (g *OpenAI) Embed(request *embed.Request) (*embed.Response, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		_, _ = io.Copy(os.Stdout, resp.Body)
-		return nil, fmt.Errorf("unexpected status code, %d", resp.StatusCode)
+		return nil, &embed.StatusError{StatusCode: resp.StatusCode}
 	}
 
 	var respModel embedResponse
@@ -155,7 +199,15 @@ func (g *OpenAI) Embed(request *embed.Request) (*embed.Response, error) {
 		},
 	}
 	for idx, data := range respModel.Data {
-		embeddingResp.Embeddings[idx] = data.Embedding
+		var encoded string
+		if json.Unmarshal(data.Embedding, &encoded) == nil {
+			embeddingResp.Embeddings[idx], err = embed.DecodeFloat32(encoded)
+		} else {
+			err = json.Unmarshal(data.Embedding, &embeddingResp.Embeddings[idx])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not decode %s embedding, %w", g.provider, err)
+		}
 	}
 
 	return embeddingResp, nil
@@ -190,6 +242,12 @@ func (g *OpenAI) SetBaseURLFunc(f func(model string) string) *OpenAI {
 	return g
 }
 
+// SetAPI selects the wire format used for generation, see APIChatCompletions.
+func (g *OpenAI) SetAPI(api API) *OpenAI {
+	g.api = api
+	return g
+}
+
 func (g *OpenAI) getBaseURL(model string) string {
 	if g.baseURLFunc != nil {
 		return g.baseURLFunc(model)
@@ -38,7 +38,8 @@ func TestOpenAIIntegration(t *testing.T) {
 	})
 
 	testsuite.RunEmbed(t, client, openai.EmbedModel_text3_small, testsuite.EmbedCapabilities{
-		Single: true,
-		Many:   true,
+		Single:     true,
+		Many:       true,
+		Dimensions: true,
 	})
 }
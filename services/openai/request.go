@@ -18,9 +18,10 @@ type messageItem struct {
 func (messageItem) isInputItem() {}
 
 type messageContent struct {
-	Type     string  `json:"type"` // "input_text" | "output_text" | "input_image"
+	Type     string  `json:"type"` // "input_text" | "output_text" | "input_image" | "input_file"
 	Text     *string `json:"text,omitempty"`
 	ImageURL *string `json:"image_url,omitempty"`
+	FileID   *string `json:"file_id,omitempty"`
 	Detail   *string `json:"detail,omitempty"`
 }
 
@@ -98,6 +99,14 @@ type textConfig struct {
 	Format *responseTextFormat `json:"format,omitempty"`
 }
 
+// structuredOutputs is the constrained decoding of vLLM, and other OpenAI-compatible servers,
+// https://docs.vllm.ai/en/latest/features/structured_outputs.html
+type structuredOutputs struct {
+	Regex   string   `json:"regex,omitempty"`
+	Choice  []string `json:"choice,omitempty"`
+	Grammar string   `json:"grammar,omitempty"`
+}
+
 type genRequest struct {
 	Model        string      `json:"model"`
 	Input        []inputItem `json:"input"`
@@ -118,5 +127,7 @@ type genRequest struct {
 	Store       *bool        `json:"store,omitempty"`
 	Include     []string     `json:"include,omitempty"` // e.g. ["reasoning.encrypted_content"]
 
+	StructuredOutputs *structuredOutputs `json:"structured_outputs,omitempty"` // not supported by OpenAI
+
 	toolBelt map[string]*tools.Tool
 }
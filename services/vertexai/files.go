@@ -0,0 +1,161 @@
+package vertexai
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/modfin/bellman/models/files"
+)
+
+// filesPrefix is the prefix of the objects uploaded to GoogleConfig.Bucket, and listed by List.
+const filesPrefix = "bellman/"
+
+type storageObject struct {
+	Name        string    `json:"name"`
+	Bucket      string    `json:"bucket"`
+	ContentType string    `json:"contentType"`
+	Size        string    `json:"size"` // int64 as a string
+	TimeCreated time.Time `json:"timeCreated"`
+}
+
+type storageObjects struct {
+	Items         []storageObject `json:"items"`
+	NextPageToken string          `json:"nextPageToken"`
+}
+
+func toFile(o storageObject) files.File {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	return files.File{
+		Provider:  Provider,
+		ID:        o.Name,
+		Name:      path.Base(o.Name),
+		Mime:      o.ContentType,
+		Size:      size,
+		CreatedAt: o.TimeCreated,
+		URI:       "gs://" + o.Bucket + "/" + o.Name,
+	}
+}
+
+func (g *Google) bucket() (string, error) {
+	if g.config.Bucket == "" {
+		return "", errors.New("no bucket configured for files, set GoogleConfig.Bucket")
+	}
+	return g.config.Bucket, nil
+}
+
+// Upload uploads a file to GoogleConfig.Bucket, to be used in prompts by its gs:// URI. Each upload is a new
+// object, under bellman/, so files of the same name do not replace each other.
+func (g *Google) Upload(request *files.UploadRequest) (*files.File, error) {
+	var reqc = atomic.AddInt64(&requestNo, 1)
+	if request.Reader == nil {
+		return nil, errors.New("no file content provided")
+	}
+	bucket, err := g.bucket()
+	if err != nil {
+		return nil, err
+	}
+
+	unique := make([]byte, 8)
+	_, _ = rand.Read(unique)
+	name := filesPrefix + hex.EncodeToString(unique) + "/" + path.Base(request.Name)
+	u := "https://storage.googleapis.com/upload/storage/v1/b/" + url.PathEscape(bucket) + "/o?" +
+		url.Values{"uploadType": {"media"}, "name": {name}}.Encode()
+
+	req, err := http.NewRequestWithContext(request.Context(), "POST", u, request.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not create google request, %w", err)
+	}
+	mime := request.Mime
+	if mime == "" {
+		mime = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", mime)
+
+	var object storageObject
+	err = g.doFiles(req, &object)
+	if err != nil {
+		return nil, err
+	}
+	g.log("[files] uploaded", "request", reqc, "name", object.Name, "bytes", object.Size)
+	res := toFile(object)
+	return &res, nil
+}
+
+// List returns the files uploaded to GoogleConfig.Bucket, ie. the objects under bellman/.
+func (g *Google) List(ctx context.Context) ([]files.File, error) {
+	bucket, err := g.bucket()
+	if err != nil {
+		return nil, err
+	}
+	var res []files.File
+	token := ""
+	for {
+		query := url.Values{"prefix": {filesPrefix}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		u := "https://storage.googleapis.com/storage/v1/b/" + url.PathEscape(bucket) + "/o?" + query.Encode()
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not create google request, %w", err)
+		}
+		var objects storageObjects
+		err = g.doFiles(req, &objects)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range objects.Items {
+			res = append(res, toFile(o))
+		}
+		if objects.NextPageToken == "" {
+			return res, nil
+		}
+		token = objects.NextPageToken
+	}
+}
+
+// Delete deletes the object id, ie. File.ID, from GoogleConfig.Bucket.
+func (g *Google) Delete(ctx context.Context, id string) error {
+	bucket, err := g.bucket()
+	if err != nil {
+		return err
+	}
+	u := "https://storage.googleapis.com/storage/v1/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(id)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", u, nil)
+	if err != nil {
+		return fmt.Errorf("could not create google request, %w", err)
+	}
+	return g.doFiles(req, nil)
+}
+
+func (g *Google) doFiles(req *http.Request, v any) error {
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send google storage request, %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		b, err := io.ReadAll(resp.Body)
+		return errors.Join(fmt.Errorf("unexpected status code, %d, err: {%s}", resp.StatusCode, string(b)), err)
+	}
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("could not decode google storage response, %w", err)
+	}
+	return nil
+}
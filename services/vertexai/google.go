@@ -23,8 +23,12 @@ type googleEmbedRequestInstance struct {
 	//Title    string `json:"title"`
 	Content string `json:"content"`
 }
+type googleEmbedRequestParameters struct {
+	OutputDimensionality int `json:"outputDimensionality,omitempty"`
+}
 type GoogleEmbedRequest struct {
-	Instances []googleEmbedRequestInstance `json:"instances"`
+	Instances  []googleEmbedRequestInstance  `json:"instances"`
+	Parameters *googleEmbedRequestParameters `json:"parameters,omitempty"`
 }
 
 type GoogleEmbedResponse struct {
@@ -43,6 +47,9 @@ type GoogleConfig struct {
 	Project    string
 	Region     string
 	Credential string
+
+	// Bucket is the GCS bucket files are uploaded to, see Google.Upload.
+	Bucket string
 }
 
 type Google struct {
@@ -93,6 +100,10 @@ var regionPattern = regexp.MustCompile(`^(global)|([a-z]+-[a-z]+[1-9][0-9]*)$`)
 var modelNamePattern = regexp.MustCompile(`^[\w.-]+$`) // should probably be gemini-[\w.-]
 
 func (g *Google) Embed(request *embed.Request) (*embed.Response, error) {
+	if request.IsMultimodal() || request.Model.IsMultimodal() {
+		return g.embedMultimodal(request)
+	}
+
 	var reqc = atomic.AddInt64(&requestNo, 1)
 
 	tasktype := ""
@@ -112,9 +123,16 @@ func (g *Google) Embed(request *embed.Request) (*embed.Response, error) {
 		return nil, fmt.Errorf("too many texts provided to embed, max is 250")
 	}
 
+	if request.OutputDType.Quantized() {
+		return nil, fmt.Errorf("output dtype %s is not supported by vertexai embed models", request.OutputDType)
+	}
+
 	req := GoogleEmbedRequest{
 		Instances: make([]googleEmbedRequestInstance, len(request.Texts)),
 	}
+	if request.Dimensions > 0 {
+		req.Parameters = &googleEmbedRequestParameters{OutputDimensionality: request.Dimensions}
+	}
 	for idx, text := range request.Texts {
 		req.Instances[idx] = googleEmbedRequestInstance{
 			TaskType: tasktype,
@@ -122,38 +140,9 @@ func (g *Google) Embed(request *embed.Request) (*embed.Response, error) {
 		}
 	}
 
-	region := g.config.Region
-	project := g.config.Project
-	if len(request.Model.Config) > 0 {
-		cfg := request.Model.Config
-		r, ok := cfg["region"].(string)
-		if ok {
-			region = r
-		}
-		p, ok := cfg["project"].(string)
-		if ok {
-			project = p
-		}
-	}
-
-	if !modelNamePattern.MatchString(request.Model.Name) {
-		return nil, fmt.Errorf("model name %q contains invalid characters, only [\\w.-]+ is allowed", request.Model.Name)
-	}
-
-	if !regionPattern.MatchString(region) {
-		return nil, fmt.Errorf("region %q contains invalid characters, only [a-z]+-[a-z]+[1-9][0-9]* or global is allowed", region)
-	}
-
-	if !projectIdPattern.MatchString(project) {
-		return nil, fmt.Errorf("project %q contains invalid characters, only [a-z]([a-z0-9-]{4,28}[a-z0-9])? is allowed", project)
-	}
-
-	u := fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/%s:predict",
-		region, project, region, request.Model.Name)
-
-	if region == "global" {
-		u = fmt.Sprintf("https://aiplatform.googleapis.com/v1/projects/%s/locations/global/publishers/google/models/%s:predict",
-			project, request.Model.Name)
+	u, err := g.predictURL(request.Model)
+	if err != nil {
+		return nil, err
 	}
 
 	body, err := json.Marshal(req)
@@ -182,7 +171,7 @@ func (g *Google) Embed(request *embed.Request) (*embed.Response, error) {
 		return nil, fmt.Errorf("could not read google response, %w", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code, %d, %s", resp.StatusCode, string(body))
+		return nil, &embed.StatusError{StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
 	err = json.Unmarshal(body, &embeddings)
@@ -209,6 +198,45 @@ func (g *Google) Embed(request *embed.Request) (*embed.Response, error) {
 	return embedResp, nil
 }
 
+// predictURL returns the url of the predict endpoint of model, in the region and project of the client, or of the
+// model config.
+func (g *Google) predictURL(model embed.Model) (string, error) {
+	region := g.config.Region
+	project := g.config.Project
+	if len(model.Config) > 0 {
+		cfg := model.Config
+		r, ok := cfg["region"].(string)
+		if ok {
+			region = r
+		}
+		p, ok := cfg["project"].(string)
+		if ok {
+			project = p
+		}
+	}
+
+	if !modelNamePattern.MatchString(model.Name) {
+		return "", fmt.Errorf("model name %q contains invalid characters, only [\\w.-]+ is allowed", model.Name)
+	}
+
+	if !regionPattern.MatchString(region) {
+		return "", fmt.Errorf("region %q contains invalid characters, only [a-z]+-[a-z]+[1-9][0-9]* or global is allowed", region)
+	}
+
+	if !projectIdPattern.MatchString(project) {
+		return "", fmt.Errorf("project %q contains invalid characters, only [a-z]([a-z0-9-]{4,28}[a-z0-9])? is allowed", project)
+	}
+
+	u := fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/%s:predict",
+		region, project, region, model.Name)
+
+	if region == "global" {
+		u = fmt.Sprintf("https://aiplatform.googleapis.com/v1/projects/%s/locations/global/publishers/google/models/%s:predict",
+			project, model.Name)
+	}
+	return u, nil
+}
+
 func (g *Google) EmbedDocument(request *embed.DocumentRequest) (*embed.DocumentResponse, error) {
 	return nil, fmt.Errorf("not supported by google embed models")
 }
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/files"
 	"github.com/modfin/bellman/models/gen"
 	"github.com/modfin/bellman/prompt"
 	"github.com/modfin/bellman/tools"
@@ -30,9 +31,13 @@ func (g *generator) SetRequest(config gen.Request) {
 	g.request = config
 }
 func (g *generator) Stream(prompts ...prompt.Prompt) (<-chan *gen.StreamResponse, error) {
+	err := g.request.CheckConstraints()
+	if err != nil {
+		return nil, err
+	}
 
 	g.request.Stream = true
-	resp, model, err := g.prompt(prompts...)
+	resp, model, err := g.prompt("streamGenerateContent?alt=sse", prompts...)
 	if err != nil {
 		return nil, fmt.Errorf("could not make http request for prompt, %w", err)
 	}
@@ -352,7 +357,11 @@ func (g *generator) Stream(prompts ...prompt.Prompt) (<-chan *gen.StreamResponse
 }
 
 func (g *generator) Prompt(prompts ...prompt.Prompt) (*gen.Response, error) {
-	resp, model, err := g.prompt(prompts...)
+	err := g.request.CheckConstraints()
+	if err != nil {
+		return nil, err
+	}
+	resp, model, err := g.prompt("generateContent", prompts...)
 	if err != nil {
 		return nil, fmt.Errorf("could not make http request for prompt, %w", err)
 	}
@@ -452,14 +461,11 @@ func (g *generator) Prompt(prompts ...prompt.Prompt) (*gen.Response, error) {
 
 	return res, nil
 }
-func (g *generator) prompt(prompts ...prompt.Prompt) (*http.Response, genRequest, error) {
 
-	//https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/inference
+// prompt posts the request to the mode endpoint of the model, eg. generateContent or countTokens
+func (g *generator) prompt(mode string, prompts ...prompt.Prompt) (*http.Response, genRequest, error) {
 
-	mode := "generateContent"
-	if g.request.Stream {
-		mode = "streamGenerateContent?alt=sse"
-	}
+	//https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/inference
 
 	if g.request.Model.Name == "" {
 		return nil, genRequest{}, errors.New("model is required")
@@ -613,6 +619,9 @@ func (g *generator) prompt(prompts ...prompt.Prompt) (*http.Response, genRequest
 						Data:     p.Payload.Data,
 					}
 				}
+				if provider, id, ok := files.ParseURI(p.Payload.Uri); ok {
+					return nil, model, fmt.Errorf("file %s is uploaded to %s, and cannot be used with %s, upload it with vertexai for a gs:// uri", id, provider, Provider)
+				}
 				if len(p.Payload.Uri) > 0 {
 					part.InlineData = nil
 					part.FileData = &fileData{
@@ -661,6 +670,11 @@ func (g *generator) prompt(prompts ...prompt.Prompt) (*http.Response, genRequest
 			project, g.request.Model.Name, mode)
 	}
 
+	if mode == countTokensMode {
+		// tool config is not part of the countTokens request
+		model.ToolConfig = nil
+	}
+
 	body, err := json.Marshal(model)
 	if err != nil {
 		return nil, model, fmt.Errorf("could not marshal google request, %w", err)
@@ -61,6 +61,8 @@ var EmbedModel_gemini_001 = embed.Model{
 	Name:             "gemini-embedding-001",
 	Description:      "State-of-the-art performance across English, multilingual and code tasks. It unifies the previously specialized models like text-embedding-005 and text-multilingual-embedding-002 and achieves better performance in their respective domains.",
 	InputMaxTokens:   2048,
+	MaxRequestTexts:  1, // gemini-embedding-001 takes a single text per request
+	MaxRequestTokens: 20000,
 	OutputDimensions: 3072,
 }
 var EmbedModel_text_005 = embed.Model{
@@ -68,6 +70,8 @@ var EmbedModel_text_005 = embed.Model{
 	Name:             "text-embedding-005",
 	Description:      "see https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/text-embeddings-api",
 	InputMaxTokens:   2048,
+	MaxRequestTexts:  250,
+	MaxRequestTokens: 20000,
 	OutputDimensions: 768,
 }
 var EmbedModel_text_004 = embed.Model{
@@ -75,6 +79,8 @@ var EmbedModel_text_004 = embed.Model{
 	Name:             "text-embedding-004",
 	Description:      "see https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/text-embeddings-api",
 	InputMaxTokens:   2048,
+	MaxRequestTexts:  250,
+	MaxRequestTokens: 20000,
 	OutputDimensions: 768,
 }
 var EmbedMode_multilang_002 = embed.Model{
@@ -82,6 +88,8 @@ var EmbedMode_multilang_002 = embed.Model{
 	Name:             "text-multilingual-embedding-002",
 	Description:      "see https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/text-embeddings-api",
 	InputMaxTokens:   2048,
+	MaxRequestTexts:  250,
+	MaxRequestTokens: 20000,
 	OutputDimensions: 768,
 }
 
@@ -98,6 +106,8 @@ var EmbedModel_text_gecko_003 = embed.Model{
 	Name:             "textembedding-gecko@003",
 	Description:      "see https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/text-embeddings-api",
 	InputMaxTokens:   2048,
+	MaxRequestTexts:  250,
+	MaxRequestTokens: 20000,
 	OutputDimensions: 768,
 }
 
@@ -106,9 +116,23 @@ var EmbedModel_text_gecko_multilang_001 = embed.Model{
 	Name:             "textembedding-gecko-multilingual@001",
 	Description:      "see https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/text-embeddings-api",
 	InputMaxTokens:   2048,
+	MaxRequestTexts:  250,
+	MaxRequestTokens: 20000,
 	OutputDimensions: 768,
 }
 
+// https://cloud.google.com/vertex-ai/generative-ai/docs/embeddings/get-multimodal-embeddings
+
+var EmbedModel_multimodal_001 = embed.Model{
+	Provider:          Provider,
+	Name:              "multimodalembedding@001",
+	Description:       "Embeds text and images into the same semantic space, of 128, 256, 512 or 1408 dimensions. Each input is either a text or an image.",
+	InputMaxTokens:    32,
+	MaxRequestTexts:   1,
+	OutputDimensions:  1408,
+	InputContentTypes: []string{"image/png", "image/jpeg", "image/bmp", "image/gif"},
+}
+
 const EmbedDimensions = 768
 
 const TypeDocument embed.Type = "RETRIEVAL_DOCUMENT"
@@ -121,10 +145,11 @@ const TypeClassification embed.Type = "CLASSIFICATION"
 const TypeSemanticSimilarity embed.Type = "SEMANTIC_SIMILARITY"
 
 var EmbedModels = map[string]embed.Model{
-	EmbedModel_text_005.Name:     EmbedModel_text_005,
-	EmbedModel_text_004.Name:     EmbedModel_text_004,
-	EmbedMode_multilang_002.Name: EmbedMode_multilang_002,
-	EmbedModel_gemini_001.Name:   EmbedModel_gemini_001,
+	EmbedModel_text_005.Name:       EmbedModel_text_005,
+	EmbedModel_text_004.Name:       EmbedModel_text_004,
+	EmbedMode_multilang_002.Name:   EmbedMode_multilang_002,
+	EmbedModel_gemini_001.Name:     EmbedModel_gemini_001,
+	EmbedModel_multimodal_001.Name: EmbedModel_multimodal_001,
 }
 
 var GenModels = map[string]gen.Model{
@@ -0,0 +1,141 @@
+package vertexai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/embed"
+)
+
+type googleMultimodalImage struct {
+	BytesBase64Encoded string `json:"bytesBase64Encoded,omitempty"`
+	GcsUri             string `json:"gcsUri,omitempty"`
+	MimeType           string `json:"mimeType,omitempty"`
+}
+
+type googleMultimodalInstance struct {
+	Text  string                 `json:"text,omitempty"`
+	Image *googleMultimodalImage `json:"image,omitempty"`
+}
+
+type googleMultimodalParameters struct {
+	Dimension int `json:"dimension,omitempty"`
+}
+
+type googleMultimodalRequest struct {
+	Instances  []googleMultimodalInstance  `json:"instances"`
+	Parameters *googleMultimodalParameters `json:"parameters,omitempty"`
+}
+
+type googleMultimodalResponse struct {
+	Predictions []struct {
+		TextEmbedding  []float64 `json:"textEmbedding"`
+		ImageEmbedding []float64 `json:"imageEmbedding"`
+	} `json:"predictions"`
+}
+
+// embedMultimodal embeds the inputs, or texts, of request with a multimodal model. The model embeds one instance per
+// request, and returns separate embeddings for the text and the image of an instance, so each input has to be a
+// single part, and the inputs are embedded one at the time.
+func (g *Google) embedMultimodal(request *embed.Request) (*embed.Response, error) {
+	var reqc = atomic.AddInt64(&requestNo, 1)
+
+	if request.OutputDType.Quantized() {
+		return nil, fmt.Errorf("output dtype %s is not supported by vertexai embed models", request.OutputDType)
+	}
+	inputs, err := request.MultimodalInputs()
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no inputs provided to embed")
+	}
+
+	instances := make([]googleMultimodalInstance, len(inputs))
+	for i, input := range inputs {
+		if len(input.Parts) != 1 {
+			return nil, fmt.Errorf("input %d: vertexai multimodal models embed one part per input, got %d", i, len(input.Parts))
+		}
+		part := input.Parts[0]
+		switch {
+		case part.Payload == nil:
+			instances[i].Text = part.Text
+		case part.Payload.Uri != "":
+			if !strings.HasPrefix(part.Payload.Uri, "gs://") {
+				return nil, fmt.Errorf("input %d: only gs:// uris are supported by vertexai, got %s", i, part.Payload.Uri)
+			}
+			instances[i].Image = &googleMultimodalImage{GcsUri: part.Payload.Uri, MimeType: part.Payload.Mime}
+		default:
+			instances[i].Image = &googleMultimodalImage{BytesBase64Encoded: part.Payload.Data, MimeType: part.Payload.Mime}
+		}
+	}
+
+	u, err := g.predictURL(request.Model)
+	if err != nil {
+		return nil, err
+	}
+	ctx := request.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	embedResp := &embed.Response{
+		Embeddings: make([][]float64, len(instances)),
+		Metadata: models.Metadata{
+			Model: request.Model.FQN(),
+		},
+	}
+	for i, instance := range instances {
+		req := googleMultimodalRequest{
+			Instances: []googleMultimodalInstance{instance},
+		}
+		if request.Dimensions > 0 {
+			req.Parameters = &googleMultimodalParameters{Dimension: request.Dimensions}
+		}
+		body, err := json.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal google request, %w", err)
+		}
+
+		hreq, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("could not create google request, %w", err)
+		}
+		hreq.Header.Set("Content-Type", "application/json")
+		resp, err := g.client.Do(hreq)
+		if err != nil {
+			return nil, fmt.Errorf("could not post google request, %w", err)
+		}
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read google response, %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, &embed.StatusError{StatusCode: resp.StatusCode, Message: string(body)}
+		}
+
+		var res googleMultimodalResponse
+		err = json.Unmarshal(body, &res)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode google response, %w", err)
+		}
+		if len(res.Predictions) != 1 {
+			return nil, fmt.Errorf("wrong number of predictions, %d, expected 1", len(res.Predictions))
+		}
+		embedResp.Embeddings[i] = res.Predictions[0].TextEmbedding
+		if instance.Image != nil {
+			embedResp.Embeddings[i] = res.Predictions[0].ImageEmbedding
+		}
+	}
+
+	g.log("[embed] multimodal response", "request", reqc, "inputs", len(instances))
+	return embedResp, nil
+}
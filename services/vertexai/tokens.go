@@ -0,0 +1,54 @@
+package vertexai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+)
+
+const countTokensMode = "countTokens"
+
+// https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/count-tokens
+type countResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
+// CountTokens counts the input tokens of the request with the countTokens endpoint, implementing gen.TokenCounter
+func (g *generator) CountTokens(prompts ...prompt.Prompt) (*gen.TokenCount, error) {
+	resp, model, err := g.prompt(countTokensMode, prompts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not make http request for count tokens, %w", err)
+	}
+	defer resp.Body.Close()
+
+	reqc := atomic.AddInt64(&requestNo, 1)
+	g.google.log("[gen] count tokens request",
+		"request", reqc,
+		"model", g.request.Model.FQN(),
+		"url", model.url,
+	)
+
+	if resp.StatusCode != http.StatusOK {
+		b, err := io.ReadAll(resp.Body)
+		return nil, errors.Join(fmt.Errorf("unexpected status code, %d, err: {%s}, for url: {%s} ", resp.StatusCode, string(b), model.url), err)
+	}
+
+	var respModel countResponse
+	err = json.NewDecoder(resp.Body).Decode(&respModel)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode google count tokens response, %w", err)
+	}
+
+	g.google.log("[gen] count tokens response",
+		"request", reqc,
+		"model", g.request.Model.FQN(),
+		"token-input", respModel.TotalTokens,
+	)
+	return &gen.TokenCount{InputTokens: respModel.TotalTokens}, nil
+}
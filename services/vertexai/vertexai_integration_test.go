@@ -50,7 +50,13 @@ func TestVertexAIIntegration(t *testing.T) {
 	})
 
 	testsuite.RunEmbed(t, client, vertexai.EmbedModel_text_004, testsuite.EmbedCapabilities{
-		Single: true,
-		Many:   true,
+		Single:     true,
+		Many:       true,
+		Dimensions: true,
+	})
+	testsuite.RunEmbed(t, client, vertexai.EmbedModel_multimodal_001, testsuite.EmbedCapabilities{
+		Single:     true,
+		Many:       true,
+		Multimodal: true,
 	})
 }
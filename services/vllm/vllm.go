@@ -10,7 +10,8 @@ func New(uris []string, models []string) *openai.OpenAI {
 		m[model] = uris[i]
 	}
 	return openai.NewCompatible(openai.CompatibleConfig{
-		Provider: Provider,
+		Provider:          Provider,
+		StructuredOutputs: true,
 		BaseURLFunc: func(model string) string {
 			if u, ok := m[model]; ok {
 				return u
@@ -2,6 +2,8 @@ package voyageai
 
 import (
 	"github.com/modfin/bellman/models/embed"
+	"github.com/modfin/bellman/models/rerank"
+	"github.com/modfin/bellman/prompt"
 )
 
 const TypeQuery embed.Type = "Represent the query for retrieving supporting documents"
@@ -15,6 +17,8 @@ var EmbedModel_voyage_context_3 = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-context-3",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 120000,
 	OutputDimensions: 1024,
 	Description:      "Used for contextualized embeddings and used with EmbedDocument",
 }
@@ -23,6 +27,8 @@ var EmbedModel_voyage_3_5 = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-3.5",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 320000,
 	OutputDimensions: 1024,
 	Description:      "Optimized for general-purpose and multilingual retrieval quality.",
 }
@@ -31,6 +37,8 @@ var EmbedModel_voyage_3_5_lite = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-3.5-lite",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 1000000,
 	OutputDimensions: 1024,
 	Description:      "Optimized for latency and cost.",
 }
@@ -39,6 +47,8 @@ var EmbedModel_voyage_3_large = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-3-large",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 120000,
 	OutputDimensions: 1024,
 	Description:      "The best general-purpose and multilingual retrieval quality",
 }
@@ -47,6 +57,8 @@ var EmbedModel_voyage_3 = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-3",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 320000,
 	OutputDimensions: 1024,
 	Description:      "Optimized for general-purpose and multilingual retrieval quality.",
 }
@@ -55,6 +67,8 @@ var EmbedModel_voyage_3_lite = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-3-lite",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 1000000,
 	OutputDimensions: 512,
 	Description:      "Optimized for latency and cost",
 }
@@ -63,6 +77,8 @@ var EmbedModel_voyage_4_large = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-4-large",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 120000,
 	OutputDimensions: 1024,
 	Description:      "The best general-purpose and multilingual retrieval quality",
 }
@@ -71,6 +87,8 @@ var EmbedModel_voyage_4 = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-4",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 320000,
 	OutputDimensions: 1024,
 	Description:      "Optimized for general-purpose and multilingual retrieval quality.",
 }
@@ -79,6 +97,8 @@ var EmbedModel_voyage_4_lite = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-4-lite",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 1000000,
 	OutputDimensions: 1024,
 	Description:      "Optimized for latency and cost",
 }
@@ -87,6 +107,8 @@ var EmbedModel_voyage_finance_2 = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-finance-2",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 120000,
 	OutputDimensions: 1024,
 	Description:      "Optimized for finance retrieval and RAG.",
 }
@@ -95,6 +117,8 @@ var EmbedModel_voyage_multilingual_2 = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-multilingual-2",
 	InputMaxTokens:   32000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 120000,
 	OutputDimensions: 1024,
 	Description:      "Optimized for multilingual retrieval and RAG.",
 }
@@ -103,6 +127,8 @@ var EmbedModel_voyage_law_2 = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-law-2",
 	InputMaxTokens:   16000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 120000,
 	OutputDimensions: 1024,
 	Description:      "Optimized for legal and long-context retrieval and RAG. Also improved performance across all domains.",
 }
@@ -111,6 +137,8 @@ var EmbedModel_voyage_code_2 = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-code-2",
 	InputMaxTokens:   16000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 120000,
 	OutputDimensions: 1536,
 	Description:      "Optimized for code retrieval (17% better than alternatives)",
 }
@@ -119,6 +147,8 @@ var EmbedModel_voyage_large_2_instruct = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-large-2-instruct",
 	InputMaxTokens:   16000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 120000,
 	OutputDimensions: 1024,
 	Description:      "Top of MTEB leaderboard . Instruction-tuned general-purpose embedding model optimized for clustering, classification, and retrieval. For retrieval, please use input_type parameter to specify whether the text is a query or document. For classification and clustering, please use the instructions here . See blog post for details. We recommend existing voyage-large-2-instruct users to transition to voyage-3",
 }
@@ -127,6 +157,8 @@ var EmbedModel_voyage_large_2 = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-large-2",
 	InputMaxTokens:   16000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 120000,
 	OutputDimensions: 1536,
 	Description:      "General-purpose embedding model that is optimized for retrieval quality (e.g., better than OpenAI V3 Large). Please transition to voyage-3.",
 }
@@ -135,11 +167,38 @@ var EmbedModel_voyage_2 = embed.Model{
 	Provider:         Provider,
 	Name:             "voyage-2",
 	InputMaxTokens:   4000,
+	MaxRequestTexts:  1000,
+	MaxRequestTokens: 320000,
 	OutputDimensions: 1024,
 	Description:      "General-purpose embedding model optimized for a balance between cost, latency, and retrieval quality. Please transition to voyage-3-lite.",
 }
 
+var EmbedModel_voyage_multimodal_3_5 = embed.Model{
+	Provider:          Provider,
+	Name:              "voyage-multimodal-3.5",
+	InputMaxTokens:    32000,
+	MaxRequestTexts:   1000,
+	MaxRequestTokens:  320000,
+	OutputDimensions:  1024,
+	InputContentTypes: []string{prompt.MimeImagePNG, prompt.MimeImageJPEG, prompt.MimeImageWebp, "image/gif"},
+	Description:       "Multimodal embedding model that embeds interleaved text and images.",
+}
+
+var EmbedModel_voyage_multimodal_3 = embed.Model{
+	Provider:          Provider,
+	Name:              "voyage-multimodal-3",
+	InputMaxTokens:    32000,
+	MaxRequestTexts:   1000,
+	MaxRequestTokens:  320000,
+	OutputDimensions:  1024,
+	InputContentTypes: []string{prompt.MimeImagePNG, prompt.MimeImageJPEG, prompt.MimeImageWebp, "image/gif"},
+	Description:       "Multimodal embedding model that embeds interleaved text and images, eg. screenshots of PDFs, slides and tables.",
+}
+
 var EmbedModels = map[string]embed.Model{
+	EmbedModel_voyage_multimodal_3_5.Name: EmbedModel_voyage_multimodal_3_5,
+	EmbedModel_voyage_multimodal_3.Name:   EmbedModel_voyage_multimodal_3,
+
 	EmbedModel_voyage_3_large.Name:        EmbedModel_voyage_3_large,
 	EmbedModel_voyage_3.Name:              EmbedModel_voyage_3,
 	EmbedModel_voyage_3_lite.Name:         EmbedModel_voyage_3_lite,
@@ -152,3 +211,44 @@ var EmbedModels = map[string]embed.Model{
 	EmbedModel_voyage_large_2.Name:          EmbedModel_voyage_large_2,
 	EmbedModel_voyage_2.Name:                EmbedModel_voyage_2,
 }
+
+// https://docs.voyageai.com/docs/reranker
+
+var RerankModel_rerank_2_5 = rerank.Model{
+	Provider:            Provider,
+	Name:                "rerank-2.5",
+	InputMaxTokens:      32000,
+	MaxRequestDocuments: 1000,
+	Description:         "Generalist reranker optimized for quality, with instruction-following and multilingual support.",
+}
+
+var RerankModel_rerank_2_5_lite = rerank.Model{
+	Provider:            Provider,
+	Name:                "rerank-2.5-lite",
+	InputMaxTokens:      32000,
+	MaxRequestDocuments: 1000,
+	Description:         "Generalist reranker optimized for latency and quality, with instruction-following and multilingual support.",
+}
+
+var RerankModel_rerank_2 = rerank.Model{
+	Provider:            Provider,
+	Name:                "rerank-2",
+	InputMaxTokens:      16000,
+	MaxRequestDocuments: 1000,
+	Description:         "Generalist reranker optimized for quality, with multilingual support.",
+}
+
+var RerankModel_rerank_2_lite = rerank.Model{
+	Provider:            Provider,
+	Name:                "rerank-2-lite",
+	InputMaxTokens:      8000,
+	MaxRequestDocuments: 1000,
+	Description:         "Generalist reranker optimized for latency and quality, with multilingual support.",
+}
+
+var RerankModels = map[string]rerank.Model{
+	RerankModel_rerank_2_5.Name:      RerankModel_rerank_2_5,
+	RerankModel_rerank_2_5_lite.Name: RerankModel_rerank_2_5_lite,
+	RerankModel_rerank_2.Name:        RerankModel_rerank_2,
+	RerankModel_rerank_2_lite.Name:   RerankModel_rerank_2_lite,
+}
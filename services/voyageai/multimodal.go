@@ -0,0 +1,139 @@
+package voyageai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/embed"
+)
+
+type multimodalContent struct {
+	Type        string `json:"type"` // text, image_url or image_base64
+	Text        string `json:"text,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	ImageBase64 string `json:"image_base64,omitempty"` // data url, eg. data:image/png;base64,...
+}
+
+type multimodalInput struct {
+	Content []multimodalContent `json:"content"`
+}
+
+type multimodalRequest struct {
+	Inputs          []multimodalInput `json:"inputs"`
+	Model           string            `json:"model"`
+	InputType       string            `json:"input_type,omitempty"`
+	OutputDimension int               `json:"output_dimension,omitempty"`
+	OutputEncoding  string            `json:"output_encoding,omitempty"`
+}
+
+// embedMultimodal embeds the inputs, or texts, of request with the multimodal endpoint, which interleaves text and
+// images into one embedding.
+func (v *VoyageAI) embedMultimodal(request *embed.Request) (*embed.Response, error) {
+	var reqc = atomic.AddInt64(&requestNo, 1)
+
+	u := `https://api.voyageai.com/v1/multimodalembeddings`
+
+	if request.OutputDType.Quantized() {
+		return nil, fmt.Errorf("output dtype %s is not supported by voyageai multimodal models", request.OutputDType)
+	}
+	inputs, err := request.MultimodalInputs()
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no inputs provided")
+	}
+	if len(inputs) > 1000 {
+		// https://docs.voyageai.com/reference/multimodal-embeddings-api
+		return nil, fmt.Errorf("too many inputs provided, max 1000")
+	}
+
+	reqModel := multimodalRequest{
+		Model:           request.Model.Name,
+		OutputDimension: request.Dimensions,
+		OutputEncoding:  "base64",
+	}
+	switch request.Model.Type {
+	case embed.TypeQuery:
+		reqModel.InputType = "query"
+	case embed.TypeDocument:
+		reqModel.InputType = "document"
+	}
+	for i, input := range inputs {
+		var in multimodalInput
+		for _, part := range input.Parts {
+			switch {
+			case part.Payload == nil:
+				in.Content = append(in.Content, multimodalContent{Type: "text", Text: part.Text})
+			case !strings.HasPrefix(part.Payload.Mime, "image/"):
+				return nil, fmt.Errorf("input %d: content type %s is not supported, only images", i, part.Payload.Mime)
+			case part.Payload.Uri != "":
+				in.Content = append(in.Content, multimodalContent{Type: "image_url", ImageURL: part.Payload.Uri})
+			default:
+				in.Content = append(in.Content, multimodalContent{
+					Type:        "image_base64",
+					ImageBase64: "data:" + part.Payload.Mime + ";base64," + part.Payload.Data,
+				})
+			}
+		}
+		reqModel.Inputs = append(reqModel.Inputs, in)
+	}
+
+	jsonReq, err := json.Marshal(reqModel)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal multimodal request, %w", err)
+	}
+
+	ctx := request.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(jsonReq))
+	if err != nil {
+		return nil, fmt.Errorf("could not create multimodal request, %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+v.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not post multimodal request, %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errText, _ := io.ReadAll(resp.Body)
+		length := min(len(errText), 1000)
+		return nil, fmt.Errorf("unexpected status code, %d, err: %s", resp.StatusCode, string(errText[:length]))
+	}
+
+	var respModel localResponse
+	err = json.NewDecoder(resp.Body).Decode(&respModel)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode multimodal response, %w", err)
+	}
+	if len(respModel.Data) != len(inputs) {
+		return nil, fmt.Errorf("wrong number of embeddings, %d, expected %d", len(respModel.Data), len(inputs))
+	}
+
+	v.log("[embed] multimodal response", "request", reqc, "model", request.Model.FQN(), "token-total", respModel.Usage.TotalTokens)
+
+	decoded, err := decode(respModel.Data, embed.DTypeFloat)
+	if err != nil {
+		return nil, err
+	}
+	return &embed.Response{
+		Embeddings: decoded.floats,
+		Metadata: models.Metadata{
+			Model:       request.Model.FQN(),
+			TotalTokens: respModel.Usage.TotalTokens,
+		},
+	}, nil
+}
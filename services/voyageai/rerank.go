@@ -0,0 +1,111 @@
+package voyageai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/rerank"
+)
+
+type rerankRequest struct {
+	Query           string   `json:"query"`
+	Documents       []string `json:"documents"`
+	Model           string   `json:"model"`
+	TopK            int      `json:"top_k,omitempty"`
+	ReturnDocuments bool     `json:"return_documents"`
+}
+
+type rerankResponse struct {
+	Object string `json:"object"`
+	Data   []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"data"`
+	Model string `json:"model"`
+	Usage struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+func (v *VoyageAI) Rerank(request *rerank.Request) (*rerank.Response, error) {
+	var reqc = atomic.AddInt64(&requestNo, 1)
+
+	u := `https://api.voyageai.com/v1/rerank`
+
+	if len(request.Documents) == 0 {
+		return nil, fmt.Errorf("no documents provided")
+	}
+	if len(request.Documents) > 1000 {
+		// https://docs.voyageai.com/reference/reranker-api
+		return nil, fmt.Errorf("too many documents provided, max 1000")
+	}
+
+	reqModel := rerankRequest{
+		Query:     request.Query,
+		Documents: request.Documents,
+		Model:     request.Model.Name,
+		TopK:      request.TopK,
+	}
+
+	jsonReq, err := json.Marshal(reqModel)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal rerank request, %w", err)
+	}
+
+	ctx := request.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(jsonReq))
+	if err != nil {
+		return nil, fmt.Errorf("could not create rerank request, %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+v.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not post rerank request, %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errText, _ := io.ReadAll(resp.Body)
+		length := min(len(errText), 1000)
+		return nil, fmt.Errorf("unexpected status code, %d, err: %s", resp.StatusCode, string(errText[:length]))
+	}
+
+	var respModel rerankResponse
+	err = json.NewDecoder(resp.Body).Decode(&respModel)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode rerank response, %w", err)
+	}
+
+	v.log("[rerank] response", "request", reqc, "model", request.Model.FQN(), "token-total", respModel.Usage.TotalTokens)
+
+	res := &rerank.Response{
+		Metadata: models.Metadata{
+			Model:       request.Model.FQN(),
+			TotalTokens: respModel.Usage.TotalTokens,
+		},
+	}
+	for _, data := range respModel.Data {
+		if data.Index < 0 || data.Index >= len(request.Documents) {
+			return nil, fmt.Errorf("rerank result index %d out of range", data.Index)
+		}
+		res.Results = append(res.Results, rerank.Result{
+			Index:          data.Index,
+			Document:       request.Documents[data.Index],
+			RelevanceScore: data.RelevanceScore,
+		})
+	}
+	res.Results = rerank.Sort(res.Results, request.TopK)
+
+	return res, nil
+}
@@ -32,13 +32,16 @@ func New(apiKey string) *VoyageAI {
 }
 
 type localRequest struct {
-	Input     []string `json:"input"`
-	Model     string   `json:"model"`
-	InputType string   `json:"input_type,omitempty"`
+	Input           []string `json:"input"`
+	Model           string   `json:"model"`
+	InputType       string   `json:"input_type,omitempty"`
+	OutputDimension int      `json:"output_dimension,omitempty"`
+	OutputDType     string   `json:"output_dtype,omitempty"`
+	EncodingFormat  string   `json:"encoding_format,omitempty"`
 }
 type responseData struct {
 	Object    string         `json:"object"`
-	Embedding []float64      `json:"embedding"`
+	Embedding string         `json:"embedding"` // base64
 	Index     int            `json:"index"`
 	Data      []responseData `json:"data"`
 }
@@ -51,9 +54,47 @@ type localResponse struct {
 	} `json:"usage"`
 }
 type localContextualizedRequest struct {
-	Inputs    [][]string `json:"inputs"`
-	Model     string     `json:"model"`
-	InputType string     `json:"input_type,omitempty"`
+	Inputs          [][]string `json:"inputs"`
+	Model           string     `json:"model"`
+	InputType       string     `json:"input_type,omitempty"`
+	OutputDimension int        `json:"output_dimension,omitempty"`
+	OutputDType     string     `json:"output_dtype,omitempty"`
+	EncodingFormat  string     `json:"encoding_format,omitempty"`
+}
+
+// embeddings holds the decoded embeddings of a response, in the field of the output dtype.
+type embeddings struct {
+	floats [][]float64
+	int8s  [][]int8
+	uint8s [][]uint8
+}
+
+// decode decodes the base64 embeddings of data, which are packed as the output dtype.
+func decode(data []responseData, dtype embed.DType) (embeddings, error) {
+	var res embeddings
+	for _, d := range data {
+		switch dtype {
+		case embed.DTypeInt8, embed.DTypeBinary:
+			e, err := embed.DecodeInt8(d.Embedding)
+			if err != nil {
+				return res, err
+			}
+			res.int8s = append(res.int8s, e)
+		case embed.DTypeUint8, embed.DTypeUbinary:
+			e, err := embed.DecodeUint8(d.Embedding)
+			if err != nil {
+				return res, err
+			}
+			res.uint8s = append(res.uint8s, e)
+		default:
+			e, err := embed.DecodeFloat32(d.Embedding)
+			if err != nil {
+				return res, err
+			}
+			res.floats = append(res.floats, e)
+		}
+	}
+	return res, nil
 }
 
 func (v *VoyageAI) Provider() string {
@@ -61,6 +102,9 @@ func (v *VoyageAI) Provider() string {
 }
 
 func (v *VoyageAI) Embed(request *embed.Request) (*embed.Response, error) {
+	if request.IsMultimodal() || request.Model.IsMultimodal() {
+		return v.embedMultimodal(request)
+	}
 
 	var reqc = atomic.AddInt64(&requestNo, 1)
 
@@ -74,8 +118,11 @@ func (v *VoyageAI) Embed(request *embed.Request) (*embed.Response, error) {
 		return nil, fmt.Errorf("too many texts provided, max 1000")
 	}
 	reqModel := localRequest{
-		Input: request.Texts,
-		Model: request.Model.Name,
+		Input:           request.Texts,
+		Model:           request.Model.Name,
+		OutputDimension: request.Dimensions,
+		OutputDType:     string(request.OutputDType),
+		EncodingFormat:  "base64",
 	}
 	switch request.Model.Type {
 	case embed.TypeQuery:
@@ -109,7 +156,7 @@ func (v *VoyageAI) Embed(request *embed.Request) (*embed.Response, error) {
 	if resp.StatusCode != http.StatusOK {
 		errText, _ := io.ReadAll(resp.Body)
 		length := min(len(errText), 1000)
-		return nil, fmt.Errorf("unexpected status code, %d, err: %s", resp.StatusCode, string(errText[:length]))
+		return nil, &embed.StatusError{StatusCode: resp.StatusCode, Message: string(errText[:length])}
 	}
 
 	var respModel localResponse
@@ -126,16 +173,19 @@ func (v *VoyageAI) Embed(request *embed.Request) (*embed.Response, error) {
 
 	v.log("[embed] response", "request", reqc, "model", request.Model.FQN(), "token-total", respModel.Usage.TotalTokens)
 
+	decoded, err := decode(respModel.Data, request.OutputDType)
+	if err != nil {
+		return nil, err
+	}
 	embedResp := &embed.Response{
-		Embeddings: make([][]float64, len(respModel.Data)),
+		Embeddings: decoded.floats,
+		Int8:       decoded.int8s,
+		Uint8:      decoded.uint8s,
 		Metadata: models.Metadata{
 			Model:       request.Model.FQN(),
 			TotalTokens: respModel.Usage.TotalTokens,
 		},
 	}
-	for idx, data := range respModel.Data {
-		embedResp.Embeddings[idx] = data.Embedding
-	}
 
 	return embedResp, nil
 }
@@ -153,8 +203,11 @@ func (v *VoyageAI) EmbedDocument(request *embed.DocumentRequest) (*embed.Documen
 		return nil, fmt.Errorf("too many texts provided, max 1000")
 	}
 	reqModel := localContextualizedRequest{
-		Inputs: [][]string{request.DocumentChunks},
-		Model:  request.Model.Name,
+		Inputs:          [][]string{request.DocumentChunks},
+		Model:           request.Model.Name,
+		OutputDimension: request.Dimensions,
+		OutputDType:     string(request.OutputDType),
+		EncodingFormat:  "base64",
 	}
 	switch request.Model.Type {
 	case embed.TypeQuery:
@@ -188,7 +241,7 @@ func (v *VoyageAI) EmbedDocument(request *embed.DocumentRequest) (*embed.Documen
 	if resp.StatusCode != http.StatusOK {
 		errText, _ := io.ReadAll(resp.Body)
 		length := min(len(errText), 1000)
-		return nil, fmt.Errorf("unexpected status code, %d, err: %s", resp.StatusCode, string(errText[:length]))
+		return nil, &embed.StatusError{StatusCode: resp.StatusCode, Message: string(errText[:length])}
 	}
 
 	var respModel localResponse
@@ -208,16 +261,19 @@ func (v *VoyageAI) EmbedDocument(request *embed.DocumentRequest) (*embed.Documen
 
 	v.log("[embed] response", "request", reqc, "model", request.Model.FQN(), "token-total", respModel.Usage.TotalTokens)
 
+	decoded, err := decode(respModel.Data[0].Data, request.OutputDType)
+	if err != nil {
+		return nil, err
+	}
 	embedResp := &embed.DocumentResponse{
-		Embeddings: make([][]float64, len(respModel.Data[0].Data)),
+		Embeddings: decoded.floats,
+		Int8:       decoded.int8s,
+		Uint8:      decoded.uint8s,
 		Metadata: models.Metadata{
 			Model:       request.Model.FQN(),
 			TotalTokens: respModel.Usage.TotalTokens,
 		},
 	}
-	for idx, data := range respModel.Data[0].Data {
-		embedResp.Embeddings[idx] = data.Embedding
-	}
 
 	return embedResp, nil
 }
@@ -1,9 +1,11 @@
 package voyageai_test
 
 import (
+	"context"
 	"os"
 	"testing"
 
+	"github.com/modfin/bellman/models/rerank"
 	"github.com/modfin/bellman/services/voyageai"
 	"github.com/modfin/bellman/testsuite"
 )
@@ -17,10 +19,38 @@ func TestVoyageAIIntegration(t *testing.T) {
 	client := voyageai.New(key)
 
 	testsuite.RunEmbed(t, client, voyageai.EmbedModel_voyage_4_lite, testsuite.EmbedCapabilities{
-		Single: true,
-		Many:   true,
+		Single:     true,
+		Many:       true,
+		Dimensions: true,
+		Quantized:  true,
 	})
 	testsuite.RunEmbed(t, client, voyageai.EmbedModel_voyage_context_3, testsuite.EmbedCapabilities{
 		Document: true,
 	})
+	testsuite.RunEmbed(t, client, voyageai.EmbedModel_voyage_multimodal_3_5, testsuite.EmbedCapabilities{
+		Single:     true,
+		Many:       true,
+		Multimodal: true,
+	})
+}
+
+func TestVoyageAIRerankIntegration(t *testing.T) {
+	key := os.Getenv("VOYAGEAI_API_KEY")
+	if key == "" {
+		t.Skip("VOYAGEAI_API_KEY not set")
+	}
+
+	docs := []string{
+		"The capital of France is Paris.",
+		"Stockholm is the capital of Sweden.",
+		"The quick brown fox jumps over the lazy dog.",
+	}
+	res, err := voyageai.New(key).Rerank(rerank.NewRequest(context.Background(), voyageai.RerankModel_rerank_2_5_lite,
+		"What is the capital of Sweden?", docs).WithTopK(2))
+	if err != nil {
+		t.Fatalf("Rerank() error = %v", err)
+	}
+	if len(res.Results) != 2 || res.Results[0].Index != 1 {
+		t.Fatalf("expected the document about Stockholm first, got %+v", res.Results)
+	}
 }
@@ -1,7 +1,11 @@
 package testsuite
 
 import (
+	"bytes"
 	"context"
+	"image"
+	"image/color"
+	"image/png"
 
 	"github.com/modfin/bellman/models/embed"
 )
@@ -74,3 +78,81 @@ func testEmbedDocument(e embed.Embeder, m embed.Model) func(tester) {
 		}
 	}
 }
+
+func testEmbedDimensions(e embed.Embeder, m embed.Model) func(tester) {
+	return func(t tester) {
+		res, err := e.Embed(embed.NewSingleRequest(context.Background(), m, "text").WithDimensions(256))
+		if err != nil {
+			t.Fatalf("Embed() error = %v", err)
+		}
+
+		vec, err := res.Single()
+		if err != nil {
+			t.Fatalf("Single() error = %v", err)
+		}
+		if len(vec) != 256 {
+			t.Fatalf("expected 256 dimensions, got %d", len(vec))
+		}
+	}
+}
+
+func testEmbedQuantized(e embed.Embeder, m embed.Model) func(tester) {
+	return func(t tester) {
+		res, err := e.Embed(embed.NewManyRequest(context.Background(), m, embedManyTexts).WithOutputDType(embed.DTypeInt8))
+		if err != nil {
+			t.Fatalf("Embed() error = %v", err)
+		}
+		if len(res.Int8) != len(embedManyTexts) || len(res.Embeddings) != 0 {
+			t.Fatalf("expected %d int8 embeddings, got %d, and %d float", len(embedManyTexts), len(res.Int8), len(res.Embeddings))
+		}
+		if m.OutputDimensions != 0 && len(res.Int8[0]) != m.OutputDimensions {
+			t.Fatalf("expected %d dimensions, got %d", m.OutputDimensions, len(res.Int8[0]))
+		}
+
+		res, err = e.Embed(embed.NewSingleRequest(context.Background(), m, "text").WithOutputDType(embed.DTypeUbinary))
+		if err != nil {
+			t.Fatalf("Embed() error = %v", err)
+		}
+		if len(res.Uint8) != 1 {
+			t.Fatalf("expected 1 ubinary embedding, got %d", len(res.Uint8))
+		}
+		if m.OutputDimensions != 0 && len(res.Uint8[0]) != m.OutputDimensions/8 {
+			t.Fatalf("expected %d bytes, got %d", m.OutputDimensions/8, len(res.Uint8[0]))
+		}
+	}
+}
+
+func testEmbedMultimodal(e embed.Embeder, m embed.Model) func(tester) {
+	return func(t tester) {
+		// a plain red square, generated rather than checked in
+		img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+		for x := range 64 {
+			for y := range 64 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+		var buf bytes.Buffer
+		err := png.Encode(&buf, img)
+		if err != nil {
+			t.Fatalf("png.Encode() error = %v", err)
+		}
+
+		inputs := []embed.Input{
+			embed.NewInput(embed.DataPart("image/png", buf.Bytes())),
+			embed.NewInput(embed.TextPart("a red square")),
+		}
+		res, err := e.Embed(embed.NewMultimodalRequest(context.Background(), m, inputs))
+		if err != nil {
+			t.Fatalf("Embed() error = %v", err)
+		}
+		if len(res.Embeddings) != len(inputs) {
+			t.Fatalf("expected %d embeddings, got %d", len(inputs), len(res.Embeddings))
+		}
+		if m.OutputDimensions != 0 && len(res.Embeddings[0]) != m.OutputDimensions {
+			t.Fatalf("expected %d dimensions, got %d", m.OutputDimensions, len(res.Embeddings[0]))
+		}
+		if len(res.Embeddings[0]) != len(res.Embeddings[1]) {
+			t.Fatalf("expected image and text in the same space, got %d and %d dimensions", len(res.Embeddings[0]), len(res.Embeddings[1]))
+		}
+	}
+}
@@ -25,9 +25,12 @@ type Capabilities struct {
 // EmbedCapabilities declares which embed-side features the model under test
 // supports.
 type EmbedCapabilities struct {
-	Single   bool
-	Many     bool
-	Document bool
+	Single     bool
+	Many       bool
+	Document   bool
+	Dimensions bool // Request.Dimensions, reduced Matryoshka dimensions
+	Quantized  bool // Request.OutputDType int8 and ubinary
+	Multimodal bool // Request.Inputs with images
 }
 
 func Run(t *testing.T, g *gen.Generator, caps Capabilities) {
@@ -124,5 +127,26 @@ func RunEmbed(t *testing.T, e embed.Embeder, m embed.Model, caps EmbedCapabiliti
 			}
 			withRetry(t, retryAttempts, testEmbedDocument(e, m))
 		})
+
+		t.Run("embed/dimensions", func(t *testing.T) {
+			if !caps.Dimensions {
+				t.Skip("capability Dimensions not advertised")
+			}
+			withRetry(t, retryAttempts, testEmbedDimensions(e, m))
+		})
+
+		t.Run("embed/quantized", func(t *testing.T) {
+			if !caps.Quantized {
+				t.Skip("capability Quantized not advertised")
+			}
+			withRetry(t, retryAttempts, testEmbedQuantized(e, m))
+		})
+
+		t.Run("embed/multimodal", func(t *testing.T) {
+			if !caps.Multimodal {
+				t.Skip("capability Multimodal not advertised")
+			}
+			withRetry(t, retryAttempts, testEmbedMultimodal(e, m))
+		})
 	})
 }
@@ -53,6 +53,15 @@ func WithArgSchema(arg any) ToolOption {
 	}
 }
 
+// WithApproval marks the tool as requiring a human decision before it is executed. agent.Run pauses with an
+// *agent.ApprovalRequiredError when the model calls such a tool, see agent.Resume.
+func WithApproval() ToolOption {
+	return func(tool Tool) Tool {
+		tool.RequiresApproval = true
+		return tool
+	}
+}
+
 func NewTool(name string, options ...ToolOption) Tool {
 	t := Tool{
 		Name: name,
@@ -68,6 +77,10 @@ type Tool struct {
 	Description    string                                               `json:"description"`
 	ArgumentSchema *schema.JSON                                         `json:"argument_schema,omitempty"`
 	Function       func(ctx context.Context, call Call) (string, error) `json:"-"`
+
+	// RequiresApproval makes agent runs pause before calling Function, so that the call can be approved, denied
+	// or edited by a human.
+	RequiresApproval bool `json:"-"`
 }
 
 type Call struct {
@@ -0,0 +1,108 @@
+package vectorstore
+
+// Filter selects documents by their metadata.
+type Filter func(metadata map[string]any) bool
+
+// Eq matches documents where the metadata key equals value. Numbers are compared by value, so int 2024 equals the
+// float64 2024 metadata is decoded to when a snapshot is loaded.
+func Eq(key string, value any) Filter {
+	return func(metadata map[string]any) bool {
+		v, ok := metadata[key]
+		return ok && equal(v, value)
+	}
+}
+
+// In matches documents where the metadata key equals one of values.
+func In(key string, values ...any) Filter {
+	return func(metadata map[string]any) bool {
+		v, ok := metadata[key]
+		if !ok {
+			return false
+		}
+		for _, value := range values {
+			if equal(v, value) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Exists matches documents that have the metadata key.
+func Exists(key string) Filter {
+	return func(metadata map[string]any) bool {
+		_, ok := metadata[key]
+		return ok
+	}
+}
+
+func And(filters ...Filter) Filter {
+	return func(metadata map[string]any) bool {
+		for _, f := range filters {
+			if !f(metadata) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func Or(filters ...Filter) Filter {
+	return func(metadata map[string]any) bool {
+		for _, f := range filters {
+			if f(metadata) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func Not(filter Filter) Filter {
+	return func(metadata map[string]any) bool {
+		return !filter(metadata)
+	}
+}
+
+func equal(a, b any) (eq bool) {
+	x, ok1 := number(a)
+	y, ok2 := number(b)
+	if ok1 && ok2 {
+		return x == y
+	}
+	if ok1 || ok2 {
+		return false
+	}
+	defer func() { _ = recover() }() // uncomparable values, eg. slices, are not equal
+	return a == b
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
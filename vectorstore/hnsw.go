@@ -0,0 +1,208 @@
+package vectorstore
+
+import (
+	"container/heap"
+	"math"
+	"math/rand/v2"
+)
+
+const (
+	defaultHNSWM              = 16
+	defaultHNSWEfConstruction = 200
+	defaultHNSWEfSearch       = 64
+)
+
+// hnsw is a hierarchical navigable small world graph, see https://arxiv.org/abs/1603.09320. It finds approximate
+// nearest neighbours in about logarithmic time, at the cost of memory for the links, and of recall, which
+// efSearch trades against speed.
+type hnsw struct {
+	space *space
+
+	m              int
+	efConstruction int
+	efSearch       int
+	levelMult      float64
+	rng            *rand.Rand
+
+	// links[id][layer] are the neighbours of id in layer, the node is in layers 0 to len(links[id])-1
+	links    [][][]uint32
+	entry    uint32
+	maxLevel int
+}
+
+func newHNSW(space *space, m int, efConstruction int, efSearch int) *hnsw {
+	m = max(m, 2)
+	return &hnsw{
+		space:          space,
+		m:              m,
+		efConstruction: max(efConstruction, m),
+		efSearch:       max(efSearch, 1),
+		levelMult:      1 / math.Log(float64(m)),
+		rng:            rand.New(rand.NewPCG(uint64(m), uint64(efConstruction))),
+		maxLevel:       -1,
+	}
+}
+
+// maxLinks is the max number of neighbours in layer, twice as many in layer 0 as suggested by the paper.
+func (h *hnsw) maxLinks(layer int) int {
+	if layer == 0 {
+		return 2 * h.m
+	}
+	return h.m
+}
+
+func (h *hnsw) add(id uint32) {
+	for uint32(len(h.links)) <= id {
+		h.links = append(h.links, nil)
+	}
+	level := int(-math.Log(1-h.rng.Float64()) * h.levelMult)
+	h.links[id] = make([][]uint32, level+1)
+	if h.maxLevel < 0 {
+		h.entry, h.maxLevel = id, level
+		return
+	}
+
+	query := h.space.vectors[id]
+	entry := h.entry
+	for layer := h.maxLevel; layer > level; layer-- {
+		entry = h.greedy(query, entry, layer)
+	}
+	for layer := min(level, h.maxLevel); layer >= 0; layer-- {
+		candidates := h.searchLayer(query, entry, h.efConstruction, layer, nil)
+		neighbours := h.selectNeighbours(candidates, h.m)
+		h.links[id][layer] = neighbours
+		for _, n := range neighbours {
+			h.link(n, id, layer)
+		}
+		entry = candidates[0].id
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = id, level
+	}
+}
+
+// link adds id to the neighbours of n, pruning them to the max if needed.
+func (h *hnsw) link(n uint32, id uint32, layer int) {
+	links := append(h.links[n][layer], id)
+	if len(links) > h.maxLinks(layer) {
+		query := h.space.vectors[n]
+		candidates := make([]hit, len(links))
+		for i, l := range links {
+			candidates[i] = hit{id: l, score: h.space.score(query, l)}
+		}
+		sortHits(candidates)
+		links = h.selectNeighbours(candidates, h.maxLinks(layer))
+	}
+	h.links[n][layer] = links
+}
+
+// selectNeighbours selects up to m of the candidates, most similar first, with the heuristic of the paper: a
+// candidate is skipped if it is closer to an already selected neighbour than to the query, which keeps links to
+// other clusters. Skipped candidates fill up the rest.
+func (h *hnsw) selectNeighbours(candidates []hit, m int) []uint32 {
+	res := make([]uint32, 0, m)
+	var skipped []uint32
+	for _, c := range candidates {
+		if len(res) >= m {
+			break
+		}
+		good := true
+		for _, r := range res {
+			if h.space.score(h.space.vectors[c.id], r) > c.score {
+				good = false
+				break
+			}
+		}
+		if good {
+			res = append(res, c.id)
+		} else {
+			skipped = append(skipped, c.id)
+		}
+	}
+	for _, s := range skipped {
+		if len(res) >= m {
+			break
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// greedy walks to the most similar node to query in layer, from entry.
+func (h *hnsw) greedy(query []float32, entry uint32, layer int) uint32 {
+	best := h.space.score(query, entry)
+	for changed := true; changed; {
+		changed = false
+		for _, n := range h.links[entry][layer] {
+			if s := h.space.score(query, n); s > best {
+				entry, best, changed = n, s, true
+			}
+		}
+	}
+	return entry
+}
+
+// searchLayer returns the ef most similar nodes to query in layer, most similar first. Nodes not accepted are
+// traversed, but not returned.
+func (h *hnsw) searchLayer(query []float32, entry uint32, ef int, layer int, accept func(id uint32) bool) []hit {
+	visited := map[uint32]bool{entry: true}
+	first := hit{id: entry, score: h.space.score(query, entry)}
+	candidates := &bestFirst{first}
+	results := &worstFirst{}
+	if accept == nil || accept(entry) {
+		heap.Push(results, first)
+	}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(hit)
+		if results.Len() >= ef && c.score < (*results)[0].score {
+			break
+		}
+		for _, n := range h.links[c.id][layer] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+			next := hit{id: n, score: h.space.score(query, n)}
+			if results.Len() >= ef && next.score <= (*results)[0].score {
+				continue
+			}
+			heap.Push(candidates, next)
+			if accept != nil && !accept(n) {
+				continue
+			}
+			heap.Push(results, next)
+			if results.Len() > ef {
+				heap.Pop(results)
+			}
+		}
+	}
+	return results.sorted()
+}
+
+func (h *hnsw) search(query []float32, k int, accept func(id uint32) bool) []hit {
+	if h.maxLevel < 0 {
+		return nil
+	}
+	entry := h.entry
+	for layer := h.maxLevel; layer > 0; layer-- {
+		entry = h.greedy(query, entry, layer)
+	}
+
+	// with a selective filter, or many removed documents, the ef nearest may not hold k accepted, then widen the
+	// search, and finally scan all
+	for ef := max(h.efSearch, k); ; ef *= 2 {
+		if ef >= len(h.links) {
+			return (&exact{space: h.space}).search(query, k, accept)
+		}
+		res := h.searchLayer(query, entry, ef, 0, accept)
+		if len(res) >= k {
+			return res[:k]
+		}
+	}
+}
+
+func sortHits(hits []hit) {
+	h := worstFirst(hits)
+	copy(hits, h.sorted())
+}
@@ -0,0 +1,94 @@
+package vectorstore
+
+import (
+	"container/heap"
+	"slices"
+)
+
+// space holds the vectors of a store, by id, for its index.
+type space struct {
+	metric  Metric
+	vectors [][]float32
+}
+
+func (s *space) score(query []float32, id uint32) float32 {
+	return s.metric.score(query, s.vectors[id])
+}
+
+type hit struct {
+	id    uint32
+	score float32
+}
+
+// index finds the vectors most similar to a query, of the ids accepted.
+type index interface {
+	add(id uint32)
+	search(query []float32, k int, accept func(id uint32) bool) []hit
+}
+
+// exact scans all vectors, which is exact, and fast enough for tens of thousands of vectors.
+type exact struct {
+	space *space
+}
+
+func (e *exact) add(id uint32) {}
+
+func (e *exact) search(query []float32, k int, accept func(id uint32) bool) []hit {
+	top := &worstFirst{}
+	for id := range e.space.vectors {
+		if !accept(uint32(id)) {
+			continue
+		}
+		h := hit{id: uint32(id), score: e.space.score(query, uint32(id))}
+		if top.Len() < k {
+			heap.Push(top, h)
+		} else if h.score > (*top)[0].score {
+			(*top)[0] = h
+			heap.Fix(top, 0)
+		}
+	}
+	return top.sorted()
+}
+
+// worstFirst is a heap of hits, the least similar on top.
+type worstFirst []hit
+
+func (h worstFirst) Len() int           { return len(h) }
+func (h worstFirst) Less(i, j int) bool { return h[i].score < h[j].score }
+func (h worstFirst) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *worstFirst) Push(x any)        { *h = append(*h, x.(hit)) }
+func (h *worstFirst) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// sorted returns the hits, the most similar first.
+func (h worstFirst) sorted() []hit {
+	res := slices.Clone(h)
+	slices.SortFunc(res, func(a, b hit) int {
+		switch {
+		case a.score > b.score:
+			return -1
+		case a.score < b.score:
+			return 1
+		}
+		return 0
+	})
+	return res
+}
+
+// bestFirst is a heap of hits, the most similar on top.
+type bestFirst []hit
+
+func (h bestFirst) Len() int           { return len(h) }
+func (h bestFirst) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h bestFirst) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *bestFirst) Push(x any)        { *h = append(*h, x.(hit)) }
+func (h *bestFirst) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,58 @@
+package vectorstore
+
+import (
+	"fmt"
+	"math"
+)
+
+// Metric is the similarity of vectors. Scores are higher for more similar vectors, for all metrics.
+type Metric string
+
+const (
+	// Cosine is the cosine similarity, -1 to 1. Vectors are normalized when added, so it is as fast as DotProduct.
+	Cosine Metric = "cosine"
+	// DotProduct is the dot product, for embeddings that are normalized, or where the magnitude matters.
+	DotProduct Metric = "dot"
+	// L2 is the negated squared euclidean distance, 0 for equal vectors.
+	L2 Metric = "l2"
+)
+
+func (m Metric) valid() error {
+	switch m {
+	case Cosine, DotProduct, L2:
+		return nil
+	}
+	return fmt.Errorf("unknown metric %q", m)
+}
+
+// prepare returns the vector as stored, ie. normalized for Cosine.
+func (m Metric) prepare(v []float64) []float32 {
+	res := make([]float32, len(v))
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	norm = math.Sqrt(norm)
+	if m != Cosine || norm == 0 {
+		norm = 1
+	}
+	for i, x := range v {
+		res[i] = float32(x / norm)
+	}
+	return res
+}
+
+func (m Metric) score(a, b []float32) float32 {
+	var sum float32
+	if m == L2 {
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return -sum
+	}
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,265 @@
+package vectorstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const snapshotMagic = "bellman-vectorstore"
+const snapshotVersion = 1
+
+// A snapshot is the magic and version, a json header, each document as json followed by its vector, and the links
+// of the HNSW graph, if any. Numbers are little endian, and json is prefixed by its length.
+type snapshotHeader struct {
+	Version    int         `json:"version"`
+	Metric     Metric      `json:"metric"`
+	Dimensions int         `json:"dimensions"`
+	Count      int         `json:"count"`
+	HNSW       *hnswConfig `json:"hnsw,omitempty"`
+	Entry      uint32      `json:"entry,omitempty"`
+	MaxLevel   int         `json:"max_level,omitempty"`
+}
+
+type snapshotDocument struct {
+	Document
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Save writes a snapshot of the store to w, including the HNSW graph, so it does not have to be rebuilt on Load.
+func (s *Store) Save(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	sw := &snapshotWriter{w: bw}
+
+	header := snapshotHeader{
+		Version:    snapshotVersion,
+		Metric:     s.metric,
+		Dimensions: s.dimensions,
+		Count:      len(s.docs),
+		HNSW:       s.hnsw,
+	}
+	graph, _ := s.index.(*hnsw)
+	if graph != nil {
+		header.Entry, header.MaxLevel = graph.entry, graph.maxLevel
+	}
+
+	sw.bytes([]byte(snapshotMagic))
+	sw.uint32(snapshotVersion)
+	sw.json(header)
+	for i, doc := range s.docs {
+		sw.json(snapshotDocument{Document: doc, Deleted: s.deleted[i]})
+		for _, x := range s.space.vectors[i] {
+			sw.uint32(math.Float32bits(x))
+		}
+	}
+	if graph != nil {
+		for _, layers := range graph.links {
+			sw.uint32(uint32(len(layers)))
+			for _, links := range layers {
+				sw.uint32(uint32(len(links)))
+				for _, l := range links {
+					sw.uint32(l)
+				}
+			}
+		}
+	}
+	if sw.err != nil {
+		return fmt.Errorf("could not write snapshot, %w", sw.err)
+	}
+	err := bw.Flush()
+	if err != nil {
+		return fmt.Errorf("could not write snapshot, %w", err)
+	}
+	return nil
+}
+
+// SaveFile writes a snapshot of the store to path. It is written to a temporary file first, so a failed save does
+// not leave a broken snapshot behind.
+func (s *Store) SaveFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create snapshot file, %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	err = s.Save(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close snapshot file, %w", err)
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return fmt.Errorf("could not rename snapshot file, %w", err)
+	}
+	return nil
+}
+
+// Load reads a store from a snapshot written by Save, with the metric and index of the snapshot. Options set the
+// rest, eg. WithEmbeder.
+func Load(r io.Reader, options ...Option) (*Store, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+
+	magic := sr.bytes(len(snapshotMagic))
+	version := sr.uint32()
+	if sr.err != nil || string(magic) != snapshotMagic {
+		return nil, errors.New("not a vector store snapshot")
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	var header snapshotHeader
+	sr.json(&header)
+	if sr.err != nil {
+		return nil, fmt.Errorf("could not read snapshot header, %w", sr.err)
+	}
+	if header.Count < 0 || header.Dimensions < 0 || header.Dimensions > 1<<16 {
+		return nil, fmt.Errorf("invalid snapshot header, %d documents of %d dimensions", header.Count, header.Dimensions)
+	}
+
+	// the index has to be the one of the snapshot, whatever the options
+	s, err := New(append(options, func(s *Store) {
+		s.metric = header.Metric
+		s.hnsw = header.HNSW
+	})...)
+	if err != nil {
+		return nil, err
+	}
+	s.dimensions = header.Dimensions
+
+	for i := 0; i < header.Count && sr.err == nil; i++ {
+		var doc snapshotDocument
+		sr.json(&doc)
+		vector := make([]float32, header.Dimensions)
+		for k := range vector {
+			vector[k] = math.Float32frombits(sr.uint32())
+		}
+		s.space.vectors = append(s.space.vectors, vector)
+		s.docs = append(s.docs, doc.Document)
+		s.deleted = append(s.deleted, doc.Deleted)
+		if doc.Deleted {
+			s.removed++
+			continue
+		}
+		s.ids[doc.ID] = uint32(i)
+	}
+
+	if graph, ok := s.index.(*hnsw); ok {
+		graph.links = make([][][]uint32, header.Count)
+		for i := 0; i < header.Count && sr.err == nil; i++ {
+			layers := make([][]uint32, sr.count(64))
+			for l := range layers {
+				links := make([]uint32, sr.count(graph.maxLinks(0)))
+				for k := range links {
+					links[k] = sr.uint32()
+					if links[k] >= uint32(header.Count) && sr.err == nil {
+						sr.err = fmt.Errorf("link to node %d of %d", links[k], header.Count)
+					}
+				}
+				layers[l] = links
+			}
+			graph.links[i] = layers
+		}
+		graph.entry, graph.maxLevel = header.Entry, header.MaxLevel
+		if header.Count == 0 {
+			graph.maxLevel = -1
+		}
+	}
+	if sr.err != nil {
+		return nil, fmt.Errorf("could not read snapshot, %w", sr.err)
+	}
+	return s, nil
+}
+
+// LoadFile reads a store from a snapshot file written by SaveFile, see Load.
+func LoadFile(path string, options ...Option) (*Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open snapshot file, %w", err)
+	}
+	defer f.Close()
+	return Load(f, options...)
+}
+
+// snapshotWriter keeps the first error, so writes can be chained without checking each.
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+	buf [4]byte
+}
+
+func (w *snapshotWriter) bytes(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+func (w *snapshotWriter) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[:], v)
+	w.bytes(w.buf[:])
+}
+
+func (w *snapshotWriter) json(v any) {
+	b, err := json.Marshal(v)
+	if err != nil && w.err == nil {
+		w.err = err
+		return
+	}
+	w.uint32(uint32(len(b)))
+	w.bytes(b)
+}
+
+// snapshotReader keeps the first error, see snapshotWriter.
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *snapshotReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	_, r.err = io.ReadFull(r.r, b)
+	return b
+}
+
+func (r *snapshotReader) uint32() uint32 {
+	b := r.bytes(4)
+	if r.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// count reads a count, which is an error over limit, since it is used to allocate.
+func (r *snapshotReader) count(limit int) int {
+	n := r.uint32()
+	if r.err == nil && n > uint32(limit) {
+		r.err = fmt.Errorf("count %d is over the limit %d", n, limit)
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (r *snapshotReader) json(v any) {
+	n := r.count(1 << 28)
+	b := r.bytes(n)
+	if r.err == nil {
+		r.err = json.Unmarshal(b, v)
+	}
+}
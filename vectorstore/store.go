@@ -0,0 +1,299 @@
+package vectorstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/modfin/bellman/models/embed"
+)
+
+// Document is a text, or chunk of one, with its embedding.
+type Document struct {
+	// ID identifies the document, a document added with the ID of another replaces it. Documents added without an
+	// ID are given a random one.
+	ID       string         `json:"id"`
+	Text     string         `json:"text"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Vector   []float64      `json:"-"`
+}
+
+type Result struct {
+	Document
+	// Score is the similarity of the document to the query, higher is more similar, see Metric.
+	Score float64 `json:"score"`
+}
+
+// FromResponse returns the documents of texts and their embeddings in res, eg. of embed.NewManyRequest(ctx, model,
+// texts). Quantized embeddings are not supported.
+func FromResponse(texts []string, res *embed.Response) ([]Document, error) {
+	return documents(texts, res.Embeddings)
+}
+
+// FromDocumentResponse returns the documents of the chunks and their embeddings in res, eg. of
+// embed.NewDocumentRequest(ctx, model, chunks). Quantized embeddings are not supported.
+func FromDocumentResponse(chunks []string, res *embed.DocumentResponse) ([]Document, error) {
+	return documents(chunks, res.Embeddings)
+}
+
+func documents(texts []string, embeddings [][]float64) ([]Document, error) {
+	if len(texts) != len(embeddings) {
+		return nil, fmt.Errorf("got %d texts and %d float embeddings, quantized embeddings are not supported", len(texts), len(embeddings))
+	}
+	docs := make([]Document, len(texts))
+	for i, text := range texts {
+		docs[i] = Document{Text: text, Vector: embeddings[i]}
+	}
+	return docs, nil
+}
+
+// Store is an in-memory vector store, for retrieval over corpora small enough to keep in memory, up to a few hundred
+// thousand chunks. It searches exactly by default, or approximately with WithHNSW, which is much faster for larger
+// corpora. It is safe for concurrent use.
+type Store struct {
+	mu sync.RWMutex
+
+	metric     Metric
+	dimensions int
+	hnsw       *hnswConfig
+
+	embeder embed.Embeder
+	model   embed.Model
+
+	space   *space
+	index   index
+	docs    []Document // by the id in space and index, with Vector unset since it is kept in space
+	deleted []bool
+	ids     map[string]uint32
+	removed int
+}
+
+type hnswConfig struct {
+	M              int `json:"m"`
+	EfConstruction int `json:"ef_construction"`
+	EfSearch       int `json:"ef_search"`
+}
+
+type Option func(s *Store)
+
+// WithMetric sets the similarity metric. Default Cosine.
+func WithMetric(metric Metric) Option {
+	return func(s *Store) {
+		s.metric = metric
+	}
+}
+
+// WithHNSW searches with an approximate HNSW index. m is the number of links per node, efConstruction the breadth of
+// the search when adding, and efSearch when searching. Higher values are more accurate and slower. 0 uses the
+// defaults, 16, 200 and 64.
+func WithHNSW(m int, efConstruction int, efSearch int) Option {
+	return func(s *Store) {
+		s.hnsw = &hnswConfig{
+			M:              cmpOr(m, defaultHNSWM),
+			EfConstruction: cmpOr(efConstruction, defaultHNSWEfConstruction),
+			EfSearch:       cmpOr(efSearch, defaultHNSWEfSearch),
+		}
+	}
+}
+
+// WithEmbeder sets the embeder and model Search embeds queries with. The model is used with embed.TypeQuery, and
+// should be the model the documents were embedded with.
+func WithEmbeder(embeder embed.Embeder, model embed.Model) Option {
+	return func(s *Store) {
+		s.embeder = embeder
+		s.model = model
+	}
+}
+
+func New(options ...Option) (*Store, error) {
+	s := &Store{
+		metric: Cosine,
+	}
+	for _, op := range options {
+		op(s)
+	}
+	if err := s.metric.valid(); err != nil {
+		return nil, err
+	}
+	s.reset()
+	return s, nil
+}
+
+// reset empties the store, with a new index.
+func (s *Store) reset() {
+	s.space = &space{metric: s.metric}
+	s.index = &exact{space: s.space}
+	if s.hnsw != nil {
+		s.index = newHNSW(s.space, s.hnsw.M, s.hnsw.EfConstruction, s.hnsw.EfSearch)
+	}
+	s.docs, s.deleted, s.ids, s.removed = nil, nil, map[string]uint32{}, 0
+}
+
+func cmpOr(v int, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// Len returns the number of documents in the store.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.ids)
+}
+
+// Dimensions returns the dimensions of the vectors, set by the first document added.
+func (s *Store) Dimensions() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dimensions
+}
+
+// Add adds documents to the store, replacing documents with the same ID, and returns their IDs.
+func (s *Store) Add(docs ...Document) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, doc := range docs {
+		if len(doc.Vector) == 0 {
+			return nil, fmt.Errorf("document %d has no vector", i)
+		}
+		dims := s.dimensions
+		if dims == 0 {
+			dims = len(docs[0].Vector)
+		}
+		if len(doc.Vector) != dims {
+			return nil, fmt.Errorf("document %d has %d dimensions, expected %d", i, len(doc.Vector), dims)
+		}
+	}
+
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		if doc.ID == "" {
+			doc.ID = randomID()
+		}
+		ids[i] = doc.ID
+		s.remove(doc.ID)
+		s.dimensions = len(doc.Vector)
+		s.insert(doc, s.metric.prepare(doc.Vector))
+	}
+	return ids, nil
+}
+
+func (s *Store) insert(doc Document, vector []float32) {
+	id := uint32(len(s.docs))
+	doc.Vector = nil
+	s.space.vectors = append(s.space.vectors, vector)
+	s.docs = append(s.docs, doc)
+	s.deleted = append(s.deleted, false)
+	s.ids[doc.ID] = id
+	s.index.add(id)
+}
+
+// Remove removes the documents with ids from the store. Removed documents are only marked as such in the index, see
+// Compact.
+func (s *Store) Remove(ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		s.remove(id)
+	}
+}
+
+func (s *Store) remove(id string) {
+	i, ok := s.ids[id]
+	if !ok {
+		return
+	}
+	delete(s.ids, id)
+	s.deleted[i] = true
+	s.removed++
+}
+
+// Compact rebuilds the index without the removed documents, which otherwise still take memory, and slow down HNSW
+// searches.
+func (s *Store) Compact() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.removed == 0 {
+		return
+	}
+
+	vectors, docs, deleted := s.space.vectors, s.docs, s.deleted
+	s.reset()
+	for i, doc := range docs {
+		if !deleted[i] {
+			s.insert(doc, vectors[i])
+		}
+	}
+}
+
+// Get returns the document with id, without its vector.
+func (s *Store) Get(id string) (Document, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.ids[id]
+	if !ok {
+		return Document{}, false
+	}
+	return s.docs[i], true
+}
+
+// SearchVector returns the k documents most similar to vector, which match all filters, the most similar first.
+func (s *Store) SearchVector(vector []float64, k int, filters ...Filter) ([]Result, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	if len(s.ids) == 0 {
+		return nil, nil
+	}
+	if len(vector) != s.dimensions {
+		return nil, fmt.Errorf("query has %d dimensions, expected %d", len(vector), s.dimensions)
+	}
+
+	filter := And(filters...)
+	accept := func(id uint32) bool {
+		return !s.deleted[id] && filter(s.docs[id].Metadata)
+	}
+	hits := s.index.search(s.metric.prepare(vector), k, accept)
+
+	res := make([]Result, len(hits))
+	for i, h := range hits {
+		res[i] = Result{Document: s.docs[h.id], Score: float64(h.score)}
+	}
+	return res, nil
+}
+
+// Search embeds query with the embeder of WithEmbeder, as embed.TypeQuery, and returns the k most similar
+// documents, which match all filters, the most similar first.
+func (s *Store) Search(ctx context.Context, query string, k int, filters ...Filter) ([]Result, error) {
+	if s.embeder == nil {
+		return nil, errors.New("no embeder set, use WithEmbeder, or SearchVector")
+	}
+	req := embed.NewSingleRequest(ctx, s.model.WithType(embed.TypeQuery), query)
+	if dims := s.Dimensions(); dims > 0 && s.model.OutputDimensions > 0 && dims != s.model.OutputDimensions {
+		req = req.WithDimensions(dims)
+	}
+	res, err := s.embeder.Embed(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not embed query, %w", err)
+	}
+	vector, err := res.Single()
+	if err != nil {
+		return nil, fmt.Errorf("could not get query embedding, %w", err)
+	}
+	return s.SearchVector(vector, k, filters...)
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
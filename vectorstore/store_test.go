@@ -0,0 +1,185 @@
+package vectorstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/modfin/bellman/models"
+	"github.com/modfin/bellman/models/embed"
+)
+
+func randomDocs(n int, dims int) []Document {
+	rng := rand.New(rand.NewPCG(1, 2))
+	docs := make([]Document, n)
+	for i := range docs {
+		vector := make([]float64, dims)
+		for k := range vector {
+			vector[k] = rng.NormFloat64()
+		}
+		docs[i] = Document{
+			ID:       fmt.Sprint(i),
+			Text:     fmt.Sprint("doc ", i),
+			Metadata: map[string]any{"parity": i % 2, "bucket": i % 10},
+			Vector:   vector,
+		}
+	}
+	return docs
+}
+
+func TestMetrics(t *testing.T) {
+	docs := []Document{
+		{ID: "near", Vector: []float64{1, 0.1}},
+		{ID: "long", Vector: []float64{10, 5}},
+		{ID: "far", Vector: []float64{-1, 0}},
+	}
+	expected := map[Metric][2]string{
+		Cosine:     {"near", "far"},
+		DotProduct: {"long", "far"},
+		L2:         {"near", "long"},
+	}
+	for metric, order := range expected {
+		s, err := New(WithMetric(metric))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.Add(docs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.SearchVector([]float64{1, 0}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 3 || res[0].ID != order[0] || res[2].ID != order[1] {
+			t.Errorf("%s: expected %s first and %s last, got %+v", metric, order[0], order[1], res)
+		}
+	}
+}
+
+func TestHNSW(t *testing.T) {
+	docs := randomDocs(3000, 16)
+	exact, _ := New()
+	approx, _ := New(WithHNSW(0, 0, 0))
+	_, _ = exact.Add(docs...)
+	_, _ = approx.Add(docs...)
+
+	found, total := 0, 0
+	for _, query := range randomDocs(50, 16) {
+		want, _ := exact.SearchVector(query.Vector, 10)
+		got, _ := approx.SearchVector(query.Vector, 10)
+		ids := map[string]bool{}
+		for _, r := range got {
+			ids[r.ID] = true
+		}
+		for _, r := range want {
+			if ids[r.ID] {
+				found++
+			}
+			total++
+		}
+	}
+	if recall := float64(found) / float64(total); recall < 0.9 {
+		t.Fatalf("expected recall over 0.9, got %.2f", recall)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	for _, options := range [][]Option{nil, {WithHNSW(8, 50, 10)}} {
+		s, _ := New(options...)
+		_, _ = s.Add(randomDocs(500, 8)...)
+		s.Remove("7")
+
+		res, err := s.SearchVector(randomDocs(1, 8)[0].Vector, 5, Eq("parity", 1), Not(In("bucket", 1, 3)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 5 {
+			t.Fatalf("expected 5 results, got %d", len(res))
+		}
+		for _, r := range res {
+			b := r.Metadata["bucket"].(int)
+			if b%2 != 1 || b == 1 || b == 3 || r.ID == "7" {
+				t.Errorf("result %s does not match the filters", r.ID)
+			}
+		}
+
+		// more selective than the ef of the search
+		res, _ = s.SearchVector(randomDocs(1, 8)[0].Vector, 3, Eq("bucket", 9), Eq("parity", 1))
+		if len(res) != 3 {
+			t.Fatalf("expected 3 results, got %d", len(res))
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, options := range [][]Option{nil, {WithMetric(L2), WithHNSW(0, 0, 0)}} {
+		s, _ := New(options...)
+		_, _ = s.Add(randomDocs(300, 8)...)
+		s.Remove("1", "2")
+
+		var buf bytes.Buffer
+		err := s.Save(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := Load(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Len() != 298 || loaded.Dimensions() != 8 {
+			t.Fatalf("expected 298 documents of 8 dimensions, got %d of %d", loaded.Len(), loaded.Dimensions())
+		}
+
+		query := randomDocs(1, 8)[0].Vector
+		want, _ := s.SearchVector(query, 5, Eq("bucket", 2))
+		got, _ := loaded.SearchVector(query, 5, Eq("bucket", 2.0))
+		if fmt.Sprint(want) != fmt.Sprint(got) {
+			t.Fatalf("expected the same results after load, got %v and %v", want, got)
+		}
+	}
+
+	_, err := Load(bytes.NewReader([]byte("not a snapshot at all")))
+	if err == nil {
+		t.Fatal("expected an error for a bad snapshot")
+	}
+}
+
+// fixed embeds any text as the vector {1, 0}, and checks the type of the model
+type fixed struct{}
+
+func (f fixed) Provider() string { return "test" }
+
+func (f fixed) Embed(req *embed.Request) (*embed.Response, error) {
+	if req.Model.Type != embed.TypeQuery {
+		return nil, errors.New("expected a query")
+	}
+	return &embed.Response{Embeddings: [][]float64{{1, 0}}, Metadata: models.Metadata{Model: req.Model.FQN()}}, nil
+}
+
+func (f fixed) EmbedDocument(req *embed.DocumentRequest) (*embed.DocumentResponse, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestSearch(t *testing.T) {
+	docs, err := FromResponse([]string{"east", "north"}, &embed.Response{Embeddings: [][]float64{{1, 0.1}, {0, 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := New(WithEmbeder(fixed{}, embed.Model{Provider: "test", Name: "test"}))
+	_, err = s.Add(docs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.Search(context.Background(), "which way?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Text != "east" {
+		t.Fatalf("expected east, got %+v", res)
+	}
+}